@@ -1,7 +1,10 @@
 package config
 
 import (
+	"log/slog"
 	"os"
+	"strconv"
+	"time"
 )
 
 // Config holds all configuration for the application
@@ -9,6 +12,87 @@ type Config struct {
 	ServerPort        string
 	UserServiceURL    string
 	ListingServiceURL string
+
+	// HTTP clients for the downstream services
+	UserService    DownstreamConfig
+	ListingService DownstreamConfig
+
+	// Cache of users embedded in listings
+	UserCache UserCacheConfig
+
+	// AdminToken authorizes the /admin endpoints; they are disabled when empty
+	AdminToken string
+
+	// Downstream endpoints probed by /readyz
+	UserServiceHealthPath    string
+	ListingServiceHealthPath string
+	HealthCheckTimeout       time.Duration
+
+	// HTTP server timeouts
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+}
+
+// DownstreamConfig tunes the HTTP client used for one downstream service
+type DownstreamConfig struct {
+	// Timeout bounds a whole request, including reading the response body
+	Timeout     time.Duration
+	DialTimeout time.Duration
+	// KeepAlive is the TCP keep-alive probe interval of open connections
+	KeepAlive time.Duration
+
+	// MaxIdleConns is how many idle connections are kept for reuse
+	MaxIdleConns int
+	// MaxConns limits connections to the service, 0 means unlimited
+	MaxConns        int
+	IdleConnTimeout time.Duration
+
+	// UserAgent is sent with every request
+	UserAgent string
+
+	Retry   RetryConfig
+	Breaker BreakerConfig
+}
+
+// UserCacheConfig bounds the cache of users embedded in listings
+type UserCacheConfig struct {
+	// Size is the most users kept, 0 disables the cache
+	Size int
+	// TTL is how long a user is served without asking the user service
+	TTL time.Duration
+	// StaleTTL is how long after TTL a user is still served while it is
+	// refreshed in the background
+	StaleTTL time.Duration
+	// NegativeTTL is how long a user ID the user service doesn't know is
+	// remembered as missing
+	NegativeTTL time.Duration
+}
+
+// BreakerConfig tunes the circuit breaker in front of a downstream service
+type BreakerConfig struct {
+	// FailureThreshold is how many consecutive failures open the circuit,
+	// 0 disables the breaker
+	FailureThreshold int
+	// OpenTimeout is how long an open circuit fails fast before letting
+	// trial requests through
+	OpenTimeout time.Duration
+	// HalfOpenRequests is how many trial requests must succeed to close the
+	// circuit again
+	HalfOpenRequests int
+}
+
+// RetryConfig is the retry policy for idempotent downstream requests
+type RetryConfig struct {
+	// MaxAttempts counts the first try, so 1 disables retries
+	MaxAttempts int
+	// BaseDelay is the backoff before the first retry, doubling up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Budget caps the time from the first attempt until the last retry starts
+	Budget time.Duration
 }
 
 // New returns a new Config with values from environment variables
@@ -17,6 +101,57 @@ func New() *Config {
 		ServerPort:        getEnvOrDefault("SERVER_PORT", "6002"),
 		UserServiceURL:    getEnvOrDefault("USER_SERVICE_URL", "http://localhost:6001"),
 		ListingServiceURL: getEnvOrDefault("LISTING_SERVICE_URL", "http://localhost:6000"),
+
+		UserService:    newDownstreamConfig("USER_SERVICE"),
+		ListingService: newDownstreamConfig("LISTING_SERVICE"),
+
+		UserCache: UserCacheConfig{
+			Size:        getIntOrDefault("USER_CACHE_SIZE", 10000),
+			TTL:         getDurationOrDefault("USER_CACHE_TTL", time.Minute),
+			StaleTTL:    getDurationOrDefault("USER_CACHE_STALE_TTL", 5*time.Minute),
+			NegativeTTL: getDurationOrDefault("USER_CACHE_NEGATIVE_TTL", 10*time.Second),
+		},
+		AdminToken: getEnvOrDefault("ADMIN_TOKEN", ""),
+
+		UserServiceHealthPath:    getEnvOrDefault("USER_SERVICE_HEALTH_PATH", "/healthz"),
+		ListingServiceHealthPath: getEnvOrDefault("LISTING_SERVICE_HEALTH_PATH", "/listings/ping"),
+		HealthCheckTimeout:       getDurationOrDefault("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+
+		ReadTimeout:       getDurationOrDefault("SERVER_READ_TIMEOUT", 10*time.Second),
+		ReadHeaderTimeout: getDurationOrDefault("SERVER_READ_HEADER_TIMEOUT", 5*time.Second),
+		WriteTimeout:      getDurationOrDefault("SERVER_WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:       getDurationOrDefault("SERVER_IDLE_TIMEOUT", 60*time.Second),
+		ShutdownTimeout:   getDurationOrDefault("SHUTDOWN_TIMEOUT", 20*time.Second),
+	}
+}
+
+// newDownstreamConfig reads the client settings of the service whose
+// environment variables start with prefix, e.g. USER_SERVICE_TIMEOUT.
+// Dial, keep-alive, User-Agent, retry and circuit breaker settings are
+// shared by all services.
+func newDownstreamConfig(prefix string) DownstreamConfig {
+	return DownstreamConfig{
+		Timeout:     getDurationOrDefault(prefix+"_TIMEOUT", 5*time.Second),
+		DialTimeout: getDurationOrDefault("DOWNSTREAM_DIAL_TIMEOUT", 2*time.Second),
+		KeepAlive:   getDurationOrDefault("DOWNSTREAM_KEEP_ALIVE", 30*time.Second),
+
+		MaxIdleConns:    getIntOrDefault(prefix+"_MAX_IDLE_CONNS", 32),
+		MaxConns:        getIntOrDefault(prefix+"_MAX_CONNS", 0),
+		IdleConnTimeout: getDurationOrDefault(prefix+"_IDLE_CONN_TIMEOUT", 90*time.Second),
+
+		UserAgent: getEnvOrDefault("DOWNSTREAM_USER_AGENT", "public-api"),
+
+		Retry: RetryConfig{
+			MaxAttempts: getIntOrDefault("DOWNSTREAM_RETRY_MAX_ATTEMPTS", 3),
+			BaseDelay:   getDurationOrDefault("DOWNSTREAM_RETRY_BASE_DELAY", 100*time.Millisecond),
+			MaxDelay:    getDurationOrDefault("DOWNSTREAM_RETRY_MAX_DELAY", time.Second),
+			Budget:      getDurationOrDefault("DOWNSTREAM_RETRY_BUDGET", 3*time.Second),
+		},
+		Breaker: BreakerConfig{
+			FailureThreshold: getIntOrDefault("DOWNSTREAM_BREAKER_FAILURE_THRESHOLD", 5),
+			OpenTimeout:      getDurationOrDefault("DOWNSTREAM_BREAKER_OPEN_TIMEOUT", 10*time.Second),
+			HalfOpenRequests: getIntOrDefault("DOWNSTREAM_BREAKER_HALF_OPEN_REQUESTS", 1),
+		},
 	}
 }
 
@@ -27,3 +162,33 @@ func getEnvOrDefault(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getDurationOrDefault parses a duration such as "500ms" or "5s" from the environment variable or returns a default value
+func getDurationOrDefault(key string, defaultValue time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists || value == "" {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		slog.Warn("Invalid duration, using default", "key", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+	return duration
+}
+
+// getIntOrDefault parses a non-negative integer from the environment variable or returns a default value
+func getIntOrDefault(key string, defaultValue int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists || value == "" {
+		return defaultValue
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		slog.Warn("Invalid integer, using default", "key", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+	return n
+}
@@ -3,35 +3,116 @@ package domain
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
+// ErrUserNotFound is returned when the user service has no user with the requested ID
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrUserDeleted is returned when the requested user has been soft-deleted.
+// It wraps ErrUserNotFound, so callers that don't care about the difference
+// can check for ErrUserNotFound alone.
+var ErrUserDeleted = fmt.Errorf("%w: user has been deleted", ErrUserNotFound)
+
+// UnavailableError is returned instead of calling a downstream service that
+// is known to be failing. RetryAfter is when the service will next be tried.
+type UnavailableError struct {
+	Service    string
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%s is unavailable: circuit breaker open, retry in %s", e.Service, e.RetryAfter.Round(time.Second))
+}
+
+// UpstreamError is an error response returned by a downstream service
+type UpstreamError struct {
+	Service       string
+	StatusCode    int
+	Code          string
+	Message       string
+	CorrelationID string
+	// RetryAfter is the response's Retry-After header, if any
+	RetryAfter string
+}
+
+func (e *UpstreamError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("%s returned status: %d", e.Service, e.StatusCode)
+	}
+	return fmt.Sprintf("%s returned status %d: %s: %s (correlation_id=%s)",
+		e.Service, e.StatusCode, e.Code, e.Message, e.CorrelationID)
+}
+
 // ErrorResponse is the standard error response format
 type ErrorResponse struct {
 	Error   string `json:"error"`
 	Code    int    `json:"code"`
 	Message string `json:"message"`
+	// Reason is the downstream service's error code for errors passed on
+	// to the client, e.g. idempotency_key_reused
+	Reason string `json:"reason,omitempty"`
 }
 
 // RespondWithError writes an error response in JSON format
 func RespondWithError(w http.ResponseWriter, code int, message string, err error) {
+	writeError(w, ErrorResponse{Error: http.StatusText(code), Code: code, Message: message}, err)
+}
+
+// writeError logs and writes errResp
+func writeError(w http.ResponseWriter, errResp ErrorResponse, err error) {
+	code := errResp.Code
+
 	// Log the error
 	slog.Error("API error",
 		"status_code", code,
-		"message", message,
+		"message", errResp.Message,
 		"error", err,
 	)
 
-	// Create error response
-	errResp := ErrorResponse{
-		Error:   http.StatusText(code),
-		Code:    code,
-		Message: message,
-	}
-
 	// Write response
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(errResp)
 }
+
+// RespondWithServiceError writes the error response for a failed call to a
+// downstream service: 503 with Retry-After if the service is unavailable, the
+// service's own status and error code if it rejected the request with a 4xx,
+// and 500 otherwise
+func RespondWithServiceError(w http.ResponseWriter, message string, err error) {
+	var unavailable *UnavailableError
+	if errors.As(err, &unavailable) {
+		seconds := max(1, int(math.Ceil(unavailable.RetryAfter.Seconds())))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		RespondWithError(w, http.StatusServiceUnavailable, message, err)
+		return
+	}
+
+	// The client can act on a rejection, e.g. a reused Idempotency-Key
+	// (422) or one whose first request is still running (409)
+	var upstream *UpstreamError
+	if errors.As(err, &upstream) && upstream.StatusCode >= 400 && upstream.StatusCode < 500 {
+		if upstream.StatusCode == http.StatusConflict && upstream.RetryAfter != "" {
+			w.Header().Set("Retry-After", upstream.RetryAfter)
+		}
+		if upstream.Message != "" {
+			message = upstream.Message
+		}
+		writeError(w, ErrorResponse{
+			Error:   http.StatusText(upstream.StatusCode),
+			Code:    upstream.StatusCode,
+			Message: message,
+			Reason:  upstream.Code,
+		}, err)
+		return
+	}
+
+	RespondWithError(w, http.StatusInternalServerError, message, err)
+}
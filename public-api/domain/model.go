@@ -1,10 +1,13 @@
 package domain
 
+import "context"
+
 type User struct {
 	ID        int    `json:"id"`
 	Name      string `json:"name"`
 	CreatedAt int64  `json:"created_at"`
 	UpdatedAt int64  `json:"updated_at"`
+	DeletedAt *int64 `json:"deleted_at,omitempty"`
 }
 
 type Listing struct {
@@ -17,34 +20,48 @@ type Listing struct {
 	User        *User  `json:"user,omitempty"`
 }
 
-// ListingWithUser represents a listing with embedded user data
+// ListingWithUser represents a listing with embedded user data. User is
+// nil if the user service doesn't know the listing's user.
 type ListingWithUser struct {
 	Listing
-	User User `json:"user"`
+	User *User `json:"user"`
 }
 
 // UserRepository defines the interface for user data operations
 type UserRepository interface {
-	GetUserByID(id int) (*User, error)
-	GetUsers(pageNum, pageSize int) ([]*User, error)
-	CreateUser(name string) (*User, error)
+	GetUserByID(ctx context.Context, id int) (*User, error)
+	// GetUsersByIDs looks up several users at once, including soft-deleted
+	// ones. IDs without a user are returned in missing.
+	GetUsersByIDs(ctx context.Context, ids []int) (users []*User, missing []int, err error)
+	GetUsers(ctx context.Context, pageNum, pageSize int) ([]*User, error)
+	// CreateUser creates a user. A non-empty idempotencyKey lets the user
+	// service deduplicate retries of the same request.
+	CreateUser(ctx context.Context, name, idempotencyKey string) (*User, error)
 }
 
 // ListingRepository defines the interface for listing data operations
 type ListingRepository interface {
-	GetListings(pageNum, pageSize int, userID *int) ([]*Listing, error)
-	CreateListing(userID int, listingType string, price int) (*Listing, error)
+	GetListings(ctx context.Context, pageNum, pageSize int, userID *int) ([]*Listing, error)
+	CreateListing(ctx context.Context, userID int, listingType string, price int) (*Listing, error)
 }
 
 // UserUseCase defines the interface for user business logic
 type UserUseCase interface {
-	GetUserByID(id int) (*User, error)
-	GetUsers(pageNum, pageSize int) ([]*User, error)
-	CreateUser(name string) (*User, error)
+	GetUserByID(ctx context.Context, id int) (*User, error)
+	GetUsers(ctx context.Context, pageNum, pageSize int) ([]*User, error)
+	CreateUser(ctx context.Context, name, idempotencyKey string) (*User, error)
 }
 
 // ListingUseCase defines the interface for listing business logic
 type ListingUseCase interface {
-	GetListings(pageNum, pageSize int, userID *int) ([]*ListingWithUser, error)
-	CreateListing(userID int, listingType string, price int) (*Listing, error)
+	GetListings(ctx context.Context, pageNum, pageSize int, userID *int) ([]*ListingWithUser, error)
+	CreateListing(ctx context.Context, userID int, listingType string, price int) (*Listing, error)
+}
+
+// UserCache holds the users embedded in listings
+type UserCache interface {
+	// Evict removes one user and reports whether it was cached
+	Evict(id int) bool
+	// Flush removes every user and returns how many entries were cached
+	Flush() int
 }
@@ -0,0 +1,170 @@
+package downstream
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"public-api/config"
+	"public-api/metrics"
+)
+
+// State is the state of a circuit breaker
+type State int
+
+const (
+	// StateClosed lets every request through
+	StateClosed State = iota
+	// StateHalfOpen lets a few trial requests through to probe recovery
+	StateHalfOpen
+	// StateOpen fails every request without calling the service
+	StateOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// outcome is how a request that passed the breaker ended
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	// outcomeIgnored means the request says nothing about the service's
+	// health, e.g. the caller cancelled it
+	outcomeIgnored
+)
+
+// ticket is handed out by allow for each request it lets through, and
+// identifies the state the request was admitted in
+type ticket struct {
+	generation uint64
+}
+
+// breaker stops calls to a failing service. FailureThreshold consecutive
+// failures open it; after OpenTimeout it half-opens and admits
+// HalfOpenRequests trial requests. If all of them succeed it closes, and if
+// any fails it opens again.
+type breaker struct {
+	service string
+	cfg     config.BreakerConfig
+	now     func() time.Time
+
+	mu        sync.Mutex
+	state     State
+	failures  int
+	openedAt  time.Time
+	trials    int
+	successes int
+	// generation counts state changes, so outcomes of requests admitted in
+	// an earlier state can be told apart
+	generation uint64
+}
+
+func newBreaker(service string, cfg config.BreakerConfig) *breaker {
+	b := &breaker{service: service, cfg: cfg, now: time.Now}
+	metrics.DownstreamCircuitState.Set(float64(StateClosed), service)
+	return b
+}
+
+// allow reports whether a request may be sent now, and returns the ticket to
+// pass to record once it ends. If not, it also returns how long until the
+// breaker lets trial requests through.
+func (b *breaker) allow() (ticket, bool, time.Duration) {
+	if b.cfg.FailureThreshold <= 0 {
+		return ticket{}, true, 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen {
+		if wait := b.openedAt.Add(b.cfg.OpenTimeout).Sub(b.now()); wait > 0 {
+			return ticket{}, false, wait
+		}
+		b.setState(StateHalfOpen)
+	}
+	if b.state == StateHalfOpen {
+		if b.trials >= max(b.cfg.HalfOpenRequests, 1) {
+			// Wait for the trial requests in flight to decide
+			return ticket{}, false, b.cfg.OpenTimeout
+		}
+		b.trials++
+	}
+	return ticket{generation: b.generation}, true, 0
+}
+
+// record reports how a request allowed by allow ended. Only requests admitted
+// in the current state count: once the breaker has half-opened, just the
+// trial requests decide whether it closes.
+func (b *breaker) record(t ticket, result outcome) {
+	if b.cfg.FailureThreshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if t.generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case StateClosed:
+		switch result {
+		case outcomeSuccess:
+			b.failures = 0
+		case outcomeFailure:
+			b.failures++
+			if b.failures >= b.cfg.FailureThreshold {
+				b.setState(StateOpen)
+			}
+		}
+	case StateHalfOpen:
+		switch result {
+		case outcomeSuccess:
+			b.successes++
+			if b.successes >= max(b.cfg.HalfOpenRequests, 1) {
+				b.setState(StateClosed)
+			}
+		case outcomeFailure:
+			b.setState(StateOpen)
+		case outcomeIgnored:
+			// Free the slot for another trial
+			b.trials--
+		}
+	}
+}
+
+// currentState returns the breaker's state without changing it. An open
+// breaker whose timeout has passed is reported as half-open, since the next
+// request will be let through as a trial.
+func (b *breaker) currentState() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen && !b.now().Before(b.openedAt.Add(b.cfg.OpenTimeout)) {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+// setState moves the breaker to state and resets its counters; b.mu must be held
+func (b *breaker) setState(state State) {
+	slog.Warn("Circuit breaker state changed", "service", b.service, "from", b.state.String(), "to", state.String())
+	b.state = state
+	b.generation++
+	b.failures, b.trials, b.successes = 0, 0, 0
+	if state == StateOpen {
+		b.openedAt = b.now()
+	}
+	metrics.DownstreamCircuitState.Set(float64(state), b.service)
+}
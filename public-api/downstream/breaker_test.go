@@ -0,0 +1,164 @@
+package downstream
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"public-api/config"
+	"public-api/domain"
+)
+
+// testBreaker returns a breaker on a clock the test moves forward
+func testBreaker(cfg config.BreakerConfig) (*breaker, *time.Time) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := newBreaker("test_service", cfg)
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	b, now := testBreaker(config.BreakerConfig{FailureThreshold: 3, OpenTimeout: 10 * time.Second, HalfOpenRequests: 2})
+
+	// A success resets the count of consecutive failures
+	for _, result := range []outcome{outcomeFailure, outcomeFailure, outcomeSuccess, outcomeFailure, outcomeFailure} {
+		admitted, _, _ := b.allow()
+		b.record(admitted, result)
+	}
+	if b.currentState() != StateClosed {
+		t.Fatalf("Expected closed after non-consecutive failures, got %s", b.currentState())
+	}
+
+	admitted, _, _ := b.allow()
+	b.record(admitted, outcomeFailure)
+	if _, allowed, wait := b.allow(); allowed || wait != 10*time.Second {
+		t.Fatalf("Expected open for 10s, got allowed=%v wait=%s", allowed, wait)
+	}
+
+	// After the timeout two trial requests are let through, no more
+	*now = now.Add(10 * time.Second)
+	if b.currentState() != StateHalfOpen {
+		t.Fatalf("Expected half-open after the timeout, got %s", b.currentState())
+	}
+	first, firstAllowed, _ := b.allow()
+	second, secondAllowed, _ := b.allow()
+	_, thirdAllowed, _ := b.allow()
+	if !firstAllowed || !secondAllowed || thirdAllowed {
+		t.Fatalf("Expected two trial requests allowed, got %v %v %v", firstAllowed, secondAllowed, thirdAllowed)
+	}
+
+	// A cancelled trial frees its slot; both trials succeeding close the circuit
+	b.record(first, outcomeIgnored)
+	third, allowed, _ := b.allow()
+	if !allowed {
+		t.Fatal("Expected the freed trial slot reused")
+	}
+	b.record(second, outcomeSuccess)
+	b.record(third, outcomeSuccess)
+	if b.currentState() != StateClosed {
+		t.Errorf("Expected closed after successful trials, got %s", b.currentState())
+	}
+}
+
+func TestBreakerReopensOnTrialFailure(t *testing.T) {
+	b, now := testBreaker(config.BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Second, HalfOpenRequests: 1})
+
+	admitted, _, _ := b.allow()
+	b.record(admitted, outcomeFailure)
+	*now = now.Add(time.Second)
+
+	trial, allowed, _ := b.allow()
+	if !allowed {
+		t.Fatal("Expected a trial request allowed")
+	}
+	b.record(trial, outcomeFailure)
+	if _, allowed, wait := b.allow(); allowed || wait != time.Second {
+		t.Errorf("Expected open again for 1s, got allowed=%v wait=%s", allowed, wait)
+	}
+}
+
+func TestBreakerIgnoresRequestsFromEarlierStates(t *testing.T) {
+	b, now := testBreaker(config.BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Second, HalfOpenRequests: 1})
+
+	// A slow request is admitted while closed, then another one opens the circuit
+	slow, _, _ := b.allow()
+	admitted, _, _ := b.allow()
+	b.record(admitted, outcomeFailure)
+	*now = now.Add(time.Second)
+
+	// Reading the state doesn't half-open the breaker
+	if b.currentState() != StateHalfOpen || b.state != StateOpen {
+		t.Fatalf("Expected half-open reported and open kept, got %s and %s", b.currentState(), b.state)
+	}
+
+	// The slow request neither closes the circuit nor takes the trial's place
+	trial, allowed, _ := b.allow()
+	if !allowed {
+		t.Fatal("Expected a trial request allowed")
+	}
+	b.record(slow, outcomeSuccess)
+	if b.currentState() != StateHalfOpen {
+		t.Fatalf("Expected a request from before the circuit opened ignored, got %s", b.currentState())
+	}
+	b.record(slow, outcomeIgnored)
+	if _, allowed, _ := b.allow(); allowed {
+		t.Fatal("Expected the trial slot still taken")
+	}
+
+	b.record(trial, outcomeSuccess)
+	if b.currentState() != StateClosed {
+		t.Errorf("Expected closed after the trial succeeded, got %s", b.currentState())
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b, _ := testBreaker(config.BreakerConfig{})
+	for range 100 {
+		admitted, _, _ := b.allow()
+		b.record(admitted, outcomeFailure)
+	}
+	if _, allowed, _ := b.allow(); !allowed || b.currentState() != StateClosed {
+		t.Errorf("Expected a disabled breaker to stay closed, got %s", b.currentState())
+	}
+}
+
+func TestClientFailsFastWhenOpen(t *testing.T) {
+	server, requests := flakyServer(t, 100, http.StatusInternalServerError, nil)
+	cfg := retryConfig
+	cfg.Breaker = config.BreakerConfig{FailureThreshold: 2, OpenTimeout: time.Minute, HalfOpenRequests: 1}
+	client := New("test_service", server.URL, cfg)
+
+	for range 2 {
+		resp, err := client.Get(context.Background(), "/users")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	_, err := client.Get(context.Background(), "/users")
+	var unavailable *domain.UnavailableError
+	if !errors.As(err, &unavailable) || unavailable.Service != "test_service" || unavailable.RetryAfter <= 0 {
+		t.Fatalf("Expected an UnavailableError, got %v", err)
+	}
+	if requests.Load() != 2 || client.CircuitState() != "open" {
+		t.Errorf("Expected the third call not sent and the circuit open, got %d requests and %s", requests.Load(), client.CircuitState())
+	}
+}
+
+func TestClientCancellationDoesNotOpenCircuit(t *testing.T) {
+	cfg := testConfig
+	cfg.Breaker = config.BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute}
+	client := New("test_service", "http://127.0.0.1:1", cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.Get(ctx, "/users"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if client.CircuitState() != "closed" {
+		t.Errorf("Expected the circuit closed after a cancelled call, got %s", client.CircuitState())
+	}
+}
@@ -0,0 +1,261 @@
+// Package downstream is the HTTP client public-api uses to call the services
+// behind it. Every request carries the caller's context, a User-Agent and the
+// request ID of the public-api request it serves. Requests that are safe to
+// repeat are retried on transient failures, and a circuit breaker stops calls
+// to a service that keeps failing.
+package downstream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"public-api/config"
+	"public-api/domain"
+	"public-api/metrics"
+)
+
+// RequestIDHeader carries the correlation ID from public-api to downstream services
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context whose downstream requests carry id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID stored in ctx, or ""
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Client sends requests to one downstream service
+type Client struct {
+	service   string
+	baseURL   string
+	userAgent string
+	retry     config.RetryConfig
+	breaker   *breaker
+	http      *http.Client
+}
+
+// New creates a Client for service at baseURL with its own connection pool.
+// service names the service in logs and metrics.
+func New(service, baseURL string, cfg config.DownstreamConfig) *Client {
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: cfg.KeepAlive,
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConns,
+		MaxConnsPerHost:       cfg.MaxConns,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		TLSHandshakeTimeout:   cfg.DialTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	return NewWithHTTPClient(service, baseURL, cfg, &http.Client{
+		Transport: transport,
+		Timeout:   cfg.Timeout,
+	})
+}
+
+// NewWithHTTPClient creates a Client that sends its requests through
+// httpClient, e.g. one with a test transport. The connection settings in
+// cfg are ignored.
+func NewWithHTTPClient(service, baseURL string, cfg config.DownstreamConfig, httpClient *http.Client) *Client {
+	return &Client{
+		service:   service,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		userAgent: cfg.UserAgent,
+		retry:     cfg.Retry,
+		breaker:   newBreaker(service, cfg.Breaker),
+		http:      httpClient,
+	}
+}
+
+// BaseURL returns the URL of the service the client talks to
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// Service returns the name of the service in logs and metrics
+func (c *Client) Service() string {
+	return c.service
+}
+
+// CloseIdleConnections closes the pooled connections to the service that
+// aren't carrying a request
+func (c *Client) CloseIdleConnections() {
+	c.http.CloseIdleConnections()
+}
+
+// CircuitState returns the state of the service's circuit breaker:
+// "closed", "half-open" or "open"
+func (c *Client) CircuitState() string {
+	return c.breaker.currentState().String()
+}
+
+// Request is one call to a downstream service
+type Request struct {
+	Method string
+	// Path is relative to the base URL and may include a query string
+	Path        string
+	ContentType string
+	Body        []byte
+	// IdempotencyKey is sent as the Idempotency-Key header and makes a
+	// write safe to retry
+	IdempotencyKey string
+}
+
+// Get sends a GET request for path, which may include a query string
+func (c *Client) Get(ctx context.Context, path string) (*http.Response, error) {
+	return c.Do(ctx, Request{Method: http.MethodGet, Path: path})
+}
+
+// PostForm sends data URL-encoded in a POST request to path. The request is
+// only retried if idempotencyKey is set.
+func (c *Client) PostForm(ctx context.Context, path string, data url.Values, idempotencyKey string) (*http.Response, error) {
+	return c.Do(ctx, Request{
+		Method:         http.MethodPost,
+		Path:           path,
+		ContentType:    "application/x-www-form-urlencoded",
+		Body:           []byte(data.Encode()),
+		IdempotencyKey: idempotencyKey,
+	})
+}
+
+// Do sends req. Each attempt is cancelled with ctx and bounded by the
+// service's timeout. Requests that are safe to repeat are retried after
+// connection errors and 429, 502, 503 and 504 responses, backing off
+// exponentially or as long as Retry-After asks, until the attempts or the
+// retry budget run out. The last response or error is returned.
+//
+// While the service's circuit breaker is open no request is sent and Do
+// returns a *domain.UnavailableError.
+func (c *Client) Do(ctx context.Context, req Request) (*http.Response, error) {
+	maxAttempts := 1
+	if canRetry(req) {
+		maxAttempts = max(c.retry.MaxAttempts, 1)
+	}
+	start := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		admitted, allowed, openFor := c.breaker.allow()
+		if !allowed {
+			metrics.DownstreamRejectedTotal.Inc(c.service)
+			return nil, &domain.UnavailableError{Service: c.service, RetryAfter: openFor}
+		}
+
+		resp, err := c.send(ctx, req)
+		c.breaker.record(admitted, classify(ctx, resp, err))
+
+		var reason string
+		var wait time.Duration
+		switch {
+		case err != nil:
+			var urlErr *url.Error
+			if ctx.Err() != nil || !errors.As(err, &urlErr) {
+				// The caller gave up or the request can't be built;
+				// retrying can't help
+				return nil, err
+			}
+			reason = "error"
+		case retryableStatus(resp.StatusCode):
+			reason = strconv.Itoa(resp.StatusCode)
+			wait = retryAfter(resp.Header, time.Now())
+		default:
+			return resp, nil
+		}
+
+		if attempt >= maxAttempts {
+			return resp, err
+		}
+		wait = max(wait, backoff(c.retry, attempt))
+		if time.Since(start)+wait > c.retry.Budget {
+			return resp, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return resp, err
+		}
+
+		if resp != nil {
+			// Drain the body so the connection can be reused
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		slog.Warn("Retrying downstream request",
+			"service", c.service,
+			"method", req.Method,
+			"path", req.Path,
+			"attempt", attempt,
+			"reason", reason,
+			"error", err,
+			"wait", wait.String(),
+		)
+		metrics.DownstreamRetriesTotal.Inc(c.service, reason)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("waiting to retry %s %s: %w", req.Method, req.Path, errors.Join(ctx.Err(), err))
+		case <-timer.C:
+		}
+	}
+}
+
+// classify tells the circuit breaker whether an attempt shows the service
+// failing: it couldn't be reached, timed out or answered with a server error
+func classify(ctx context.Context, resp *http.Response, err error) outcome {
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return outcomeIgnored
+	case err != nil, resp.StatusCode >= http.StatusInternalServerError:
+		return outcomeFailure
+	default:
+		return outcomeSuccess
+	}
+}
+
+// send makes a single attempt at req
+func (c *Client) send(ctx context.Context, req Request) (*http.Response, error) {
+	var body io.Reader
+	if req.Body != nil {
+		body = bytes.NewReader(req.Body)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, c.baseURL+req.Path, body)
+	if err != nil {
+		return nil, fmt.Errorf("build %s %s request: %w", req.Method, req.Path, err)
+	}
+	if req.ContentType != "" {
+		httpReq.Header.Set("Content-Type", req.ContentType)
+	}
+	if req.IdempotencyKey != "" {
+		httpReq.Header.Set(IdempotencyKeyHeader, req.IdempotencyKey)
+	}
+	if c.userAgent != "" {
+		httpReq.Header.Set("User-Agent", c.userAgent)
+	}
+	if id := RequestID(ctx); id != "" {
+		httpReq.Header.Set(RequestIDHeader, id)
+	}
+
+	return c.http.Do(httpReq)
+}
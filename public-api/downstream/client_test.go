@@ -0,0 +1,87 @@
+package downstream
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"public-api/config"
+)
+
+// testConfig is a client configuration with short timeouts
+var testConfig = config.DownstreamConfig{
+	Timeout:         time.Second,
+	DialTimeout:     time.Second,
+	KeepAlive:       time.Second,
+	MaxIdleConns:    2,
+	IdleConnTimeout: time.Second,
+	UserAgent:       "public-api-test",
+}
+
+func TestClientSetsHeaders(t *testing.T) {
+	var got *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		got = r
+	}))
+	defer server.Close()
+
+	client := New("test_service", server.URL+"/", testConfig)
+	ctx := WithRequestID(context.Background(), "req-1")
+
+	resp, err := client.PostForm(ctx, "/users", url.Values{"name": {"Ann"}}, "")
+	if err != nil {
+		t.Fatalf("PostForm failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if got.URL.Path != "/users" || got.PostForm.Get("name") != "Ann" {
+		t.Errorf("Unexpected request %s %s with form %v", got.Method, got.URL.Path, got.PostForm)
+	}
+	if got.UserAgent() != "public-api-test" || got.Header.Get(RequestIDHeader) != "req-1" {
+		t.Errorf("Expected User-Agent and request ID headers, got %v", got.Header)
+	}
+
+	// Without an ID in the context the header is left out
+	resp, err = client.Get(context.Background(), "/users")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	resp.Body.Close()
+	if _, ok := got.Header[RequestIDHeader]; ok {
+		t.Errorf("Expected no request ID header, got %q", got.Header.Get(RequestIDHeader))
+	}
+}
+
+func TestClientTimeoutAndCancellation(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	cfg := testConfig
+	cfg.Timeout = 50 * time.Millisecond
+	client := New("test_service", server.URL, cfg)
+
+	start := time.Now()
+	if _, err := client.Get(context.Background(), "/slow"); err == nil || time.Since(start) > time.Second {
+		t.Errorf("Expected the request to time out, got %v after %s", err, time.Since(start))
+	}
+
+	// The caller's context can end a request before the timeout
+	client = New("test_service", server.URL, testConfig)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := client.Get(ctx, "/slow"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
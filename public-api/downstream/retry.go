@@ -0,0 +1,63 @@
+package downstream
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"public-api/config"
+)
+
+// IdempotencyKeyHeader lets a service deduplicate retried writes
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// retryableStatus reports whether a response status is worth retrying:
+// the service is overloaded or a proxy in front of it failed
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// canRetry reports whether sending req twice is safe. Writes are only
+// retried when they carry an idempotency key.
+func canRetry(req Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return req.IdempotencyKey != ""
+}
+
+// backoff returns the wait before retry number n (starting at 1): the base
+// delay doubled for every earlier retry, capped at policy.MaxDelay, with the
+// upper half randomised so clients that failed together don't retry together
+func backoff(policy config.RetryConfig, n int) time.Duration {
+	d := policy.MaxDelay
+	if shift := n - 1; shift < 30 && policy.BaseDelay<<shift < policy.MaxDelay {
+		d = policy.BaseDelay << shift
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryAfter parses a Retry-After header given either in seconds or as an
+// HTTP date. It returns 0 if the header is missing or invalid.
+func retryAfter(header http.Header, now time.Time) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
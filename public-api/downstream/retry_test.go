@@ -0,0 +1,182 @@
+package downstream
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"public-api/config"
+)
+
+// retryConfig retries quickly so tests don't wait on backoff
+var retryConfig = config.DownstreamConfig{
+	Timeout: time.Second,
+	Retry:   config.RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, Budget: time.Second},
+}
+
+// flakyServer fails the first failures requests with status, then succeeds.
+// It returns the server and a pointer to the number of requests it received.
+func flakyServer(t *testing.T, failures int32, status int, header http.Header) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures {
+			if status == 0 {
+				// Drop the connection without a response
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(status)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestClientRetriesIdempotentRequests(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status int
+	}{
+		{name: "503", status: http.StatusServiceUnavailable},
+		{name: "502", status: http.StatusBadGateway},
+		{name: "connection reset", status: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, requests := flakyServer(t, 2, tc.status, nil)
+			resp, err := New("test_service", server.URL, retryConfig).Get(context.Background(), "/listings")
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || requests.Load() != 3 {
+				t.Errorf("Expected 200 after 3 attempts, got %d after %d", resp.StatusCode, requests.Load())
+			}
+		})
+	}
+}
+
+func TestClientGivesUpAfterMaxAttempts(t *testing.T) {
+	server, requests := flakyServer(t, 10, http.StatusServiceUnavailable, nil)
+	resp, err := New("test_service", server.URL, retryConfig).Get(context.Background(), "/listings")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || requests.Load() != 3 {
+		t.Errorf("Expected the last 503 after 3 attempts, got %d after %d", resp.StatusCode, requests.Load())
+	}
+}
+
+func TestClientDoesNotRetryOtherErrors(t *testing.T) {
+	server, requests := flakyServer(t, 1, http.StatusInternalServerError, nil)
+	resp, err := New("test_service", server.URL, retryConfig).Get(context.Background(), "/listings")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError || requests.Load() != 1 {
+		t.Errorf("Expected the 500 without a retry, got %d after %d attempts", resp.StatusCode, requests.Load())
+	}
+}
+
+func TestClientRetriesWritesOnlyWithIdempotencyKey(t *testing.T) {
+	var keys []string
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		keys = append(keys, r.Header.Get(IdempotencyKeyHeader)+":"+r.PostForm.Get("name"))
+		if requests.Add(1)%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	client := New("test_service", server.URL, retryConfig)
+
+	resp, err := client.PostForm(context.Background(), "/users", url.Values{"name": {"Ann"}}, "")
+	if err != nil {
+		t.Fatalf("PostForm failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || len(keys) != 1 {
+		t.Errorf("Expected the write without a key not retried, got %d after %d attempts", resp.StatusCode, len(keys))
+	}
+
+	keys = nil
+	requests.Store(0)
+	resp, err = client.PostForm(context.Background(), "/users", url.Values{"name": {"Bob"}}, "create-bob")
+	if err != nil {
+		t.Fatalf("PostForm failed: %v", err)
+	}
+	resp.Body.Close()
+	// The retry resends the same body and key
+	if resp.StatusCode != http.StatusCreated || len(keys) != 2 || keys[0] != "create-bob:Bob" || keys[1] != keys[0] {
+		t.Errorf("Expected the keyed write retried with the same body, got %d after %v", resp.StatusCode, keys)
+	}
+}
+
+func TestClientRespectsRetryAfterAndBudget(t *testing.T) {
+	// Retry-After beyond the budget ends the retries at once
+	server, requests := flakyServer(t, 1, http.StatusServiceUnavailable, http.Header{"Retry-After": {"5"}})
+	start := time.Now()
+	resp, err := New("test_service", server.URL, retryConfig).Get(context.Background(), "/listings")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || requests.Load() != 1 || time.Since(start) > time.Second {
+		t.Errorf("Expected the 503 returned without waiting, got %d after %d attempts in %s", resp.StatusCode, requests.Load(), time.Since(start))
+	}
+
+	// Retry-After within the budget is waited out
+	server, requests = flakyServer(t, 1, http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}})
+	cfg := retryConfig
+	cfg.Retry.Budget = 2 * time.Second
+	start = time.Now()
+	resp, err = New("test_service", server.URL, cfg).Get(context.Background(), "/listings")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || requests.Load() != 2 || time.Since(start) < time.Second {
+		t.Errorf("Expected a retry after 1s, got %d after %d attempts in %s", resp.StatusCode, requests.Load(), time.Since(start))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := config.RetryConfig{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for n, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 5: time.Second, 64: time.Second} {
+		for range 20 {
+			if got := backoff(policy, n); got < want/2 || got > want {
+				t.Errorf("backoff(%d) = %s, want between %s and %s", n, got, want/2, want)
+			}
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for value, want := range map[string]time.Duration{
+		"":                              0,
+		"3":                             3 * time.Second,
+		"-1":                            0,
+		"soon":                          0,
+		"Mon, 01 Jan 2024 12:00:30 GMT": 30 * time.Second,
+		"Mon, 01 Jan 2024 11:00:00 GMT": 0,
+	} {
+		if got := retryAfter(http.Header{"Retry-After": {value}}, now); got != want {
+			t.Errorf("retryAfter(%q) = %s, want %s", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"public-api/domain"
+)
+
+// AdminHandler serves the operator endpoints under /admin
+type AdminHandler struct {
+	userCache domain.UserCache
+	token     string
+}
+
+// NewAdminHandler creates a handler whose endpoints require token as a
+// bearer token. With an empty token the endpoints are disabled.
+func NewAdminHandler(userCache domain.UserCache, token string) *AdminHandler {
+	return &AdminHandler{
+		userCache: userCache,
+		token:     token,
+	}
+}
+
+// FlushUserCache handles DELETE /admin/cache/users
+func (h *AdminHandler) FlushUserCache(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r) {
+		return
+	}
+
+	evicted := h.userCache.Flush()
+	slog.Info("User cache flushed", "evicted", evicted)
+	writeEvicted(w, evicted)
+}
+
+// EvictCachedUser handles DELETE /admin/cache/users/{id}
+func (h *AdminHandler) EvictCachedUser(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r) {
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		domain.RespondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	evicted := 0
+	if h.userCache.Evict(id) {
+		evicted = 1
+	}
+	slog.Info("User evicted from cache", "user_id", id, "evicted", evicted)
+	writeEvicted(w, evicted)
+}
+
+// authorize checks the bearer token, writing an error response if it's
+// missing or wrong. Disabled endpoints answer 404 as if they didn't exist.
+func (h *AdminHandler) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if h.token == "" {
+		domain.RespondWithError(w, http.StatusNotFound, "Not found", nil)
+		return false
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		domain.RespondWithError(w, http.StatusUnauthorized, "Invalid admin token", nil)
+		return false
+	}
+	return true
+}
+
+// writeEvicted reports how many cache entries were removed
+func writeEvicted(w http.ResponseWriter, evicted int) {
+	response := struct {
+		Result  bool `json:"result"`
+		Evicted int  `json:"evicted"`
+	}{
+		Result:  true,
+		Evicted: evicted,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeUserCache records which users are evicted
+type fakeUserCache struct {
+	cached  map[int]bool
+	flushed bool
+}
+
+func (c *fakeUserCache) Evict(id int) bool {
+	ok := c.cached[id]
+	delete(c.cached, id)
+	return ok
+}
+
+func (c *fakeUserCache) Flush() int {
+	c.flushed = true
+	n := len(c.cached)
+	c.cached = map[int]bool{}
+	return n
+}
+
+func TestAdminUserCache(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string
+		auth       string
+		id         string
+		wantStatus int
+		golden     string
+	}{
+		{name: "flush", token: "secret", auth: "Bearer secret",
+			wantStatus: http.StatusOK, golden: "admin_flush_user_cache.golden"},
+		{name: "evict", token: "secret", auth: "Bearer secret", id: "1",
+			wantStatus: http.StatusOK, golden: "admin_evict_cached_user.golden"},
+		{name: "evict uncached", token: "secret", auth: "Bearer secret", id: "5",
+			wantStatus: http.StatusOK, golden: "admin_evict_uncached_user.golden"},
+		{name: "invalid id", token: "secret", auth: "Bearer secret", id: "abc",
+			wantStatus: http.StatusBadRequest, golden: "error_invalid_cached_user_id.golden"},
+		{name: "wrong token", token: "secret", auth: "Bearer guess",
+			wantStatus: http.StatusUnauthorized, golden: "error_invalid_admin_token.golden"},
+		{name: "disabled", token: "", auth: "Bearer ",
+			wantStatus: http.StatusNotFound, golden: "error_admin_disabled.golden"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cache := &fakeUserCache{cached: map[int]bool{1: true, 2: true}}
+			handler := NewAdminHandler(cache, tc.token)
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("DELETE /admin/cache/users", handler.FlushUserCache)
+			mux.HandleFunc("DELETE /admin/cache/users/{id}", handler.EvictCachedUser)
+
+			path := "/admin/cache/users"
+			if tc.id != "" {
+				path += "/" + tc.id
+			}
+			req := httptest.NewRequest(http.MethodDelete, path, nil)
+			req.Header.Set("Authorization", tc.auth)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("Expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+			if authorized := tc.wantStatus != http.StatusUnauthorized && tc.wantStatus != http.StatusNotFound; !authorized && (cache.flushed || len(cache.cached) != 2) {
+				t.Error("Expected the cache untouched without authorization")
+			}
+			assertGolden(t, tc.golden, rec.Body.Bytes())
+		})
+	}
+}
@@ -0,0 +1,69 @@
+// handlers/fakes_test.go
+package handlers
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"public-api/domain"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// fakeUserUseCase implements domain.UserUseCase with replaceable functions
+type fakeUserUseCase struct {
+	getUserByIDFn func(id int) (*domain.User, error)
+	getUsersFn    func(pageNum, pageSize int) ([]*domain.User, error)
+	createUserFn  func(name, idempotencyKey string) (*domain.User, error)
+}
+
+func (f *fakeUserUseCase) GetUserByID(ctx context.Context, id int) (*domain.User, error) {
+	return f.getUserByIDFn(id)
+}
+
+func (f *fakeUserUseCase) GetUsers(ctx context.Context, pageNum, pageSize int) ([]*domain.User, error) {
+	return f.getUsersFn(pageNum, pageSize)
+}
+
+func (f *fakeUserUseCase) CreateUser(ctx context.Context, name, idempotencyKey string) (*domain.User, error) {
+	return f.createUserFn(name, idempotencyKey)
+}
+
+// fakeListingUseCase implements domain.ListingUseCase with replaceable functions
+type fakeListingUseCase struct {
+	getListingsFn   func(pageNum, pageSize int, userID *int) ([]*domain.ListingWithUser, error)
+	createListingFn func(userID int, listingType string, price int) (*domain.Listing, error)
+}
+
+func (f *fakeListingUseCase) GetListings(ctx context.Context, pageNum, pageSize int, userID *int) ([]*domain.ListingWithUser, error) {
+	return f.getListingsFn(pageNum, pageSize, userID)
+}
+
+func (f *fakeListingUseCase) CreateListing(ctx context.Context, userID int, listingType string, price int) (*domain.Listing, error) {
+	return f.createListingFn(userID, listingType, price)
+}
+
+// assertGolden compares got with testdata/<name>. Run `go test ./handlers -update`
+// to rewrite golden files after an intended change.
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("Failed to write golden file: %v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read golden file (run `go test ./handlers -update` to create it): %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("Response body doesn't match %s\ngot:  %s\nwant: %s", path, got, want)
+	}
+}
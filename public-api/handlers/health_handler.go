@@ -0,0 +1,134 @@
+// handlers/health_handler.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HealthCheck is the result of checking a single dependency
+type HealthCheck struct {
+	Status     string  `json:"status"`
+	LatencyMS  float64 `json:"latency_ms"`
+	URL        string  `json:"url,omitempty"`
+	StatusCode int     `json:"status_code,omitempty"`
+	Circuit    string  `json:"circuit,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// HealthResponse is the response format for GET /healthz and GET /readyz
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+// CircuitReporter reports the circuit breaker state of a dependency:
+// "closed", "half-open" or "open"
+type CircuitReporter interface {
+	CircuitState() string
+}
+
+type HealthHandler struct {
+	client       *http.Client
+	dependencies map[string]string
+	circuits     map[string]CircuitReporter
+}
+
+// NewHealthHandler creates a handler that checks the given downstream URLs,
+// keyed by dependency name. circuits holds the circuit breakers of the
+// dependencies that have one, under the same names.
+func NewHealthHandler(dependencies map[string]string, circuits map[string]CircuitReporter, timeout time.Duration) *HealthHandler {
+	return &HealthHandler{
+		client:       &http.Client{Timeout: timeout},
+		dependencies: dependencies,
+		circuits:     circuits,
+	}
+}
+
+// Liveness handles GET /healthz. It only reports that the process is up and serving.
+func (h *HealthHandler) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, HealthResponse{Status: "ok"})
+}
+
+// Readiness handles GET /readyz. The API is ready when every downstream
+// service answers and no circuit breaker is open; all dependencies are
+// checked concurrently.
+func (h *HealthHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+	checks := make(map[string]HealthCheck, len(h.dependencies))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for name, url := range h.dependencies {
+		wg.Add(1)
+		go func(name, url string) {
+			defer wg.Done()
+
+			check := h.checkURL(r.Context(), url)
+			if circuit, ok := h.circuits[name]; ok {
+				check.Circuit = circuit.CircuitState()
+				if check.Circuit == "open" && check.Status == "ok" {
+					// Requests to the dependency fail fast until the breaker half-opens
+					check.Status = "fail"
+					check.Error = "circuit breaker open"
+				}
+			}
+
+			mu.Lock()
+			checks[name] = check
+			mu.Unlock()
+		}(name, url)
+	}
+	wg.Wait()
+
+	status := "ok"
+	for _, check := range checks {
+		if check.Status != "ok" {
+			status = "fail"
+		}
+	}
+
+	writeHealth(w, HealthResponse{Status: status, Checks: checks})
+}
+
+// checkURL reports a dependency as reachable if it answers without a server error
+func (h *HealthHandler) checkURL(ctx context.Context, url string) HealthCheck {
+	start := time.Now()
+	check := HealthCheck{Status: "ok", URL: url}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err == nil {
+		var resp *http.Response
+		resp, err = h.client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			check.StatusCode = resp.StatusCode
+			if resp.StatusCode >= http.StatusInternalServerError {
+				err = fmt.Errorf("unhealthy status: %d", resp.StatusCode)
+			}
+		}
+	}
+
+	check.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		check.Status = "fail"
+		check.Error = err.Error()
+	}
+	return check
+}
+
+// writeHealth sends a health response, using 503 when the status isn't ok
+func writeHealth(w http.ResponseWriter, response HealthResponse) {
+	status := http.StatusOK
+	if response.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fixedCircuit reports a constant circuit breaker state
+type fixedCircuit string
+
+func (c fixedCircuit) CircuitState() string {
+	return string(c)
+}
+
+func TestReadinessReportsCircuitState(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	tests := []struct {
+		circuit    string
+		wantStatus int
+		wantCheck  string
+	}{
+		{circuit: "closed", wantStatus: http.StatusOK, wantCheck: "ok"},
+		{circuit: "half-open", wantStatus: http.StatusOK, wantCheck: "ok"},
+		{circuit: "open", wantStatus: http.StatusServiceUnavailable, wantCheck: "fail"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.circuit, func(t *testing.T) {
+			handler := NewHealthHandler(map[string]string{"user_service": server.URL},
+				map[string]CircuitReporter{"user_service": fixedCircuit(tc.circuit)}, time.Second)
+
+			rec := httptest.NewRecorder()
+			handler.Readiness(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			var body HealthResponse
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("Expected a JSON body: %v", err)
+			}
+			check := body.Checks["user_service"]
+			if rec.Code != tc.wantStatus || check.Status != tc.wantCheck || check.Circuit != tc.circuit {
+				t.Errorf("Expected %d with check %s, got %d with %+v", tc.wantStatus, tc.wantCheck, rec.Code, check)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"public-api/domain"
@@ -22,7 +23,7 @@ func NewListingHandler(listingUseCase domain.ListingUseCase) *ListingHandler {
 func (h *ListingHandler) GetListings(w http.ResponseWriter, r *http.Request) {
 	// Parse query parameters
 	query := r.URL.Query()
-	
+
 	// Parse page_num
 	pageNum := 1
 	if pageNumStr := query.Get("page_num"); pageNumStr != "" {
@@ -33,7 +34,7 @@ func (h *ListingHandler) GetListings(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	
+
 	// Parse page_size
 	pageSize := 10
 	if pageSizeStr := query.Get("page_size"); pageSizeStr != "" {
@@ -44,7 +45,7 @@ func (h *ListingHandler) GetListings(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	
+
 	// Parse user_id
 	var userID *int
 	if userIDStr := query.Get("user_id"); userIDStr != "" {
@@ -55,33 +56,33 @@ func (h *ListingHandler) GetListings(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	
+
 	// Log request parameters
-	slog.Info("Fetching listings", 
-		"page_num", pageNum, 
-		"page_size", pageSize, 
+	slog.Info("Fetching listings",
+		"page_num", pageNum,
+		"page_size", pageSize,
 		"user_id", userID,
 	)
-	
+
 	// Get listings
-	listings, err := h.listingUseCase.GetListings(pageNum, pageSize, userID)
+	listings, err := h.listingUseCase.GetListings(r.Context(), pageNum, pageSize, userID)
 	if err != nil {
-		domain.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch listings", err)
+		domain.RespondWithServiceError(w, "Failed to fetch listings", err)
 		return
 	}
-	
+
 	// Log success
 	slog.Info("Listings fetched successfully", "count", len(listings))
-	
+
 	// Prepare response
 	response := struct {
-		Result   bool                    `json:"result"`
+		Result   bool                      `json:"result"`
 		Listings []*domain.ListingWithUser `json:"listings"`
 	}{
 		Result:   true,
 		Listings: listings,
 	}
-	
+
 	// Return response
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -116,16 +117,23 @@ func (h *ListingHandler) CreateListing(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Log request
-	slog.Info("Creating listing", 
-		"user_id", request.UserID, 
-		"listing_type", request.ListingType, 
+	slog.Info("Creating listing",
+		"user_id", request.UserID,
+		"listing_type", request.ListingType,
 		"price", request.Price,
 	)
 
 	// Create listing
-	listing, err := h.listingUseCase.CreateListing(request.UserID, request.ListingType, request.Price)
-	if err != nil {
-		domain.RespondWithError(w, http.StatusInternalServerError, "Failed to create listing", err)
+	listing, err := h.listingUseCase.CreateListing(r.Context(), request.UserID, request.ListingType, request.Price)
+	switch {
+	case errors.Is(err, domain.ErrUserDeleted):
+		domain.RespondWithError(w, http.StatusBadRequest, "user_id refers to a deleted user", err)
+		return
+	case errors.Is(err, domain.ErrUserNotFound):
+		domain.RespondWithError(w, http.StatusBadRequest, "user_id does not refer to an existing user", err)
+		return
+	case err != nil:
+		domain.RespondWithServiceError(w, "Failed to create listing", err)
 		return
 	}
 
@@ -143,4 +151,4 @@ func (h *ListingHandler) CreateListing(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(response)
-}
\ No newline at end of file
+}
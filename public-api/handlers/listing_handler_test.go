@@ -0,0 +1,174 @@
+// handlers/listing_handler_test.go
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"public-api/domain"
+)
+
+// testListings returns a fixed page of listings with their users
+func testListings() []*domain.ListingWithUser {
+	return []*domain.ListingWithUser{
+		{
+			Listing: domain.Listing{ID: 2, UserID: 1, ListingType: "sale", Price: 5000, CreatedAt: 2000, UpdatedAt: 2000},
+			User:    &domain.User{ID: 1, Name: "Alice", CreatedAt: 1000, UpdatedAt: 1000},
+		},
+		{
+			Listing: domain.Listing{ID: 1, UserID: 1, ListingType: "rent", Price: 300, CreatedAt: 1500, UpdatedAt: 1500},
+			User:    &domain.User{ID: 1, Name: "Alice", CreatedAt: 1000, UpdatedAt: 1000},
+		},
+		{
+			// The user service doesn't know user 9
+			Listing: domain.Listing{ID: 3, UserID: 9, ListingType: "rent", Price: 800, CreatedAt: 1200, UpdatedAt: 1200},
+		},
+	}
+}
+
+func TestGetListings(t *testing.T) {
+	type call struct {
+		pageNum  int
+		pageSize int
+		userID   *int
+	}
+	userID := func(id int) *int { return &id }
+
+	tests := []struct {
+		name           string
+		query          string
+		useCaseErr     error
+		wantCall       *call
+		wantStatus     int
+		wantRetryAfter string
+		golden         string
+	}{
+		{name: "defaults", query: "", wantCall: &call{1, 10, nil},
+			wantStatus: http.StatusOK, golden: "get_listings.golden"},
+		{name: "all parameters", query: "?page_num=2&page_size=5&user_id=1", wantCall: &call{2, 5, userID(1)},
+			wantStatus: http.StatusOK, golden: "get_listings.golden"},
+		{name: "zero page_num falls back to default", query: "?page_num=0", wantCall: &call{1, 10, nil},
+			wantStatus: http.StatusOK, golden: "get_listings.golden"},
+		{name: "negative page_size falls back to default", query: "?page_size=-5", wantCall: &call{1, 10, nil},
+			wantStatus: http.StatusOK, golden: "get_listings.golden"},
+		{name: "non-numeric page_num", query: "?page_num=abc",
+			wantStatus: http.StatusBadRequest, golden: "error_invalid_page_num.golden"},
+		{name: "non-numeric page_size", query: "?page_size=ten",
+			wantStatus: http.StatusBadRequest, golden: "error_invalid_page_size.golden"},
+		{name: "non-numeric user_id", query: "?user_id=1.5",
+			wantStatus: http.StatusBadRequest, golden: "error_invalid_user_id_param.golden"},
+		{name: "use case error", query: "?user_id=7", useCaseErr: errors.New("listing service returned non-200 status: 502"),
+			wantCall: &call{1, 10, userID(7)}, wantStatus: http.StatusInternalServerError, golden: "error_fetch_listings.golden"},
+		{name: "service unavailable", query: "", useCaseErr: fmt.Errorf("error making request to listing service: %w",
+			&domain.UnavailableError{Service: "listing_service", RetryAfter: 1500 * time.Millisecond}),
+			wantCall: &call{1, 10, nil}, wantStatus: http.StatusServiceUnavailable, wantRetryAfter: "2", golden: "error_fetch_listings_unavailable.golden"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got *call
+			handler := NewListingHandler(&fakeListingUseCase{
+				getListingsFn: func(pageNum, pageSize int, userID *int) ([]*domain.ListingWithUser, error) {
+					got = &call{pageNum, pageSize, userID}
+					if tc.useCaseErr != nil {
+						return nil, tc.useCaseErr
+					}
+					return testListings(), nil
+				},
+			})
+
+			rec := httptest.NewRecorder()
+			handler.GetListings(rec, httptest.NewRequest(http.MethodGet, "/public-api/listings"+tc.query, nil))
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("Expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Expected Content-Type application/json, got %q", ct)
+			}
+			if ra := rec.Header().Get("Retry-After"); ra != tc.wantRetryAfter {
+				t.Errorf("Expected Retry-After %q, got %q", tc.wantRetryAfter, ra)
+			}
+			assertGolden(t, tc.golden, rec.Body.Bytes())
+
+			switch {
+			case tc.wantCall == nil && got != nil:
+				t.Errorf("Expected the use case not to be called, got %+v", *got)
+			case tc.wantCall != nil && got == nil:
+				t.Errorf("Expected the use case to be called with %+v", *tc.wantCall)
+			case tc.wantCall != nil:
+				if got.pageNum != tc.wantCall.pageNum || got.pageSize != tc.wantCall.pageSize ||
+					(got.userID == nil) != (tc.wantCall.userID == nil) ||
+					(got.userID != nil && *got.userID != *tc.wantCall.userID) {
+					t.Errorf("Expected call %+v, got %+v", *tc.wantCall, *got)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateListing(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		useCaseErr error
+		wantCalled bool
+		wantStatus int
+		golden     string
+	}{
+		{name: "created", body: `{"user_id":1,"listing_type":"rent","price":4000}`, wantCalled: true,
+			wantStatus: http.StatusCreated, golden: "create_listing.golden"},
+		{name: "malformed json", body: `{"user_id":1,`,
+			wantStatus: http.StatusBadRequest, golden: "error_invalid_body.golden"},
+		{name: "wrong field type", body: `{"user_id":"one","listing_type":"rent","price":4000}`,
+			wantStatus: http.StatusBadRequest, golden: "error_invalid_body.golden"},
+		{name: "empty body", body: "",
+			wantStatus: http.StatusBadRequest, golden: "error_invalid_body.golden"},
+		{name: "missing user_id", body: `{"listing_type":"rent","price":4000}`,
+			wantStatus: http.StatusBadRequest, golden: "error_invalid_user_id.golden"},
+		{name: "missing listing_type", body: `{"user_id":1,"price":4000}`,
+			wantStatus: http.StatusBadRequest, golden: "error_listing_type_required.golden"},
+		{name: "non-positive price", body: `{"user_id":1,"listing_type":"rent","price":0}`,
+			wantStatus: http.StatusBadRequest, golden: "error_invalid_price.golden"},
+		{name: "unknown user", body: `{"user_id":99,"listing_type":"rent","price":4000}`,
+			useCaseErr: fmt.Errorf("invalid user_id: user 99: %w", domain.ErrUserNotFound), wantCalled: true,
+			wantStatus: http.StatusBadRequest, golden: "error_user_not_found.golden"},
+		{name: "deleted user", body: `{"user_id":3,"listing_type":"rent","price":4000}`,
+			useCaseErr: fmt.Errorf("invalid user_id: user 3: %w", domain.ErrUserDeleted), wantCalled: true,
+			wantStatus: http.StatusBadRequest, golden: "error_user_deleted.golden"},
+		{name: "use case error", body: `{"user_id":99,"listing_type":"rent","price":4000}`,
+			useCaseErr: errors.New("listing service returned status: 502"), wantCalled: true,
+			wantStatus: http.StatusInternalServerError, golden: "error_create_listing.golden"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			called := false
+			handler := NewListingHandler(&fakeListingUseCase{
+				createListingFn: func(userID int, listingType string, price int) (*domain.Listing, error) {
+					called = true
+					if tc.useCaseErr != nil {
+						return nil, tc.useCaseErr
+					}
+					return &domain.Listing{ID: 3, UserID: userID, ListingType: listingType, Price: price, CreatedAt: 3000, UpdatedAt: 3000}, nil
+				},
+			})
+
+			rec := httptest.NewRecorder()
+			handler.CreateListing(rec, httptest.NewRequest(http.MethodPost, "/public-api/listings", strings.NewReader(tc.body)))
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("Expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+			if called != tc.wantCalled {
+				t.Errorf("Expected use case called = %v, got %v", tc.wantCalled, called)
+			}
+			assertGolden(t, tc.golden, rec.Body.Bytes())
+		})
+	}
+}
@@ -35,10 +35,15 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// An Idempotency-Key is passed on to the user service so the request
+	// can be retried without creating the user twice. The user service
+	// validates it, and its rejections reach the client unchanged.
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+
 	// Create user
-	user, err := h.userUseCase.CreateUser(request.Name)
+	user, err := h.userUseCase.CreateUser(r.Context(), request.Name, idempotencyKey)
 	if err != nil {
-		domain.RespondWithError(w, http.StatusInternalServerError, "Failed to create user", err)
+		domain.RespondWithServiceError(w, "Failed to create user", err)
 		return
 	}
 
@@ -56,4 +61,4 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(response)
-}
\ No newline at end of file
+}
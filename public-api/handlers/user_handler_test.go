@@ -0,0 +1,90 @@
+// handlers/user_handler_test.go
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"public-api/domain"
+)
+
+func TestCreateUser(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		idempotencyKey string
+		useCaseErr     error
+		wantName       string
+		wantStatus     int
+		wantRetryAfter string
+		golden         string
+	}{
+		{name: "created", body: `{"name":"Alice"}`, wantName: "Alice",
+			wantStatus: http.StatusCreated, golden: "create_user.golden"},
+		{name: "malformed json", body: `{"name":`,
+			wantStatus: http.StatusBadRequest, golden: "error_invalid_body.golden"},
+		{name: "wrong field type", body: `{"name":1}`,
+			wantStatus: http.StatusBadRequest, golden: "error_invalid_body.golden"},
+		{name: "missing name", body: `{}`,
+			wantStatus: http.StatusBadRequest, golden: "error_name_required.golden"},
+		{name: "idempotency key", body: `{"name":"Alice"}`, idempotencyKey: "create-alice", wantName: "Alice",
+			wantStatus: http.StatusCreated, golden: "create_user.golden"},
+		{name: "idempotency key rejected", body: `{"name":"Alice"}`, idempotencyKey: strings.Repeat("k", 256), wantName: "Alice",
+			useCaseErr: &domain.UpstreamError{Service: "user service", StatusCode: http.StatusBadRequest, Code: "invalid_argument",
+				Message: "Invalid request"},
+			wantStatus: http.StatusBadRequest, golden: "error_invalid_idempotency_key.golden"},
+		{name: "idempotency key reused", body: `{"name":"Alice"}`, idempotencyKey: "create-alice", wantName: "Alice",
+			useCaseErr: &domain.UpstreamError{Service: "user service", StatusCode: http.StatusUnprocessableEntity, Code: "idempotency_key_reused",
+				Message: "Idempotency-Key was already used for a different request"},
+			wantStatus: http.StatusUnprocessableEntity, golden: "error_idempotency_key_reused.golden"},
+		{name: "idempotency key in use", body: `{"name":"Alice"}`, idempotencyKey: "create-alice", wantName: "Alice",
+			useCaseErr: &domain.UpstreamError{Service: "user service", StatusCode: http.StatusConflict, Code: "idempotency_key_in_use",
+				Message: "A request with this Idempotency-Key is still being processed", RetryAfter: "1"},
+			wantStatus: http.StatusConflict, wantRetryAfter: "1", golden: "error_idempotency_key_in_use.golden"},
+		{name: "use case error", body: `{"name":"Alice"}`, wantName: "Alice",
+			useCaseErr: errors.New("user service returned status: 500"),
+			wantStatus: http.StatusInternalServerError, golden: "error_create_user.golden"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			gotName, gotKey := "", ""
+			handler := NewUserHandler(&fakeUserUseCase{
+				createUserFn: func(name, idempotencyKey string) (*domain.User, error) {
+					gotName, gotKey = name, idempotencyKey
+					if tc.useCaseErr != nil {
+						return nil, tc.useCaseErr
+					}
+					return &domain.User{ID: 1, Name: name, CreatedAt: 1000, UpdatedAt: 1000}, nil
+				},
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/public-api/users", strings.NewReader(tc.body))
+			if tc.idempotencyKey != "" {
+				req.Header.Set("Idempotency-Key", tc.idempotencyKey)
+			}
+			rec := httptest.NewRecorder()
+			handler.CreateUser(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("Expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+			if gotName != tc.wantName {
+				t.Errorf("Expected use case to be called with %q, got %q", tc.wantName, gotName)
+			}
+			if gotName != "" && gotKey != tc.idempotencyKey {
+				t.Errorf("Expected idempotency key %q passed on, got %q", tc.idempotencyKey, gotKey)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tc.wantRetryAfter {
+				t.Errorf("Expected Retry-After %q, got %q", tc.wantRetryAfter, got)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Expected Content-Type application/json, got %q", ct)
+			}
+			assertGolden(t, tc.golden, rec.Body.Bytes())
+		})
+	}
+}
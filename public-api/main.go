@@ -1,17 +1,27 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 
 	"public-api/config"
 	"public-api/domain"
+	"public-api/downstream"
 	"public-api/handlers"
 	"public-api/logger"
+	"public-api/metrics"
 	"public-api/repository"
 	"public-api/usecase"
 )
@@ -28,32 +38,68 @@ func main() {
 	// Initialize configuration
 	cfg := config.New()
 
+	// Initialize downstream clients, one connection pool per service
+	userClient := downstream.New("user_service", cfg.UserServiceURL, cfg.UserService)
+	listingClient := downstream.New("listing_service", cfg.ListingServiceURL, cfg.ListingService)
+
 	// Initialize repositories
-	userRepo := repository.NewUserRepository(cfg.UserServiceURL)
-	listingRepo := repository.NewListingRepository(cfg.ListingServiceURL)
+	userRepo := repository.NewInstrumentedUserRepository(repository.NewUserRepository(userClient))
+	listingRepo := repository.NewInstrumentedListingRepository(repository.NewListingRepository(listingClient))
 
 	// Initialize use cases
 	userUseCase := usecase.NewUserUseCase(userRepo)
-	listingUseCase := usecase.NewListingUseCase(listingRepo, userRepo)
+	userCache := usecase.NewUserCache(cfg.UserCache)
+	listingUseCase := usecase.NewListingUseCase(listingRepo, userRepo, userCache)
 
 	// Initialize handlers
 	userHandler := handlers.NewUserHandler(userUseCase)
 	listingHandler := handlers.NewListingHandler(listingUseCase)
+	adminHandler := handlers.NewAdminHandler(userCache, cfg.AdminToken)
+	healthHandler := handlers.NewHealthHandler(map[string]string{
+		"user_service":    cfg.UserServiceURL + cfg.UserServiceHealthPath,
+		"listing_service": cfg.ListingServiceURL + cfg.ListingServiceHealthPath,
+	}, map[string]handlers.CircuitReporter{
+		"user_service":    userClient,
+		"listing_service": listingClient,
+	}, cfg.HealthCheckTimeout)
 
 	// Setup router using standard http.ServeMux
+	mux := newRouter(userHandler, listingHandler, healthHandler, adminHandler)
+
+	// Wrap the router with request IDs, metrics and panic recovery
+	handler := withMiddleware(mux)
+
+	// Serve until SIGINT/SIGTERM, then drain in-flight requests
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := runServer(ctx, cfg, handler, userClient, listingClient); err != nil {
+		slog.Error("Server failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// newRouter registers the public API routes
+func newRouter(userHandler *handlers.UserHandler, listingHandler *handlers.ListingHandler, healthHandler *handlers.HealthHandler, adminHandler *handlers.AdminHandler) *http.ServeMux {
 	mux := http.NewServeMux()
-	
+
 	// Register routes
+	mux.HandleFunc("GET /healthz", healthHandler.Liveness)
+	mux.HandleFunc("GET /readyz", healthHandler.Readiness)
+	mux.HandleFunc("GET /metrics", metrics.Handler)
+	mux.HandleFunc("DELETE /admin/cache/users", adminHandler.FlushUserCache)
+	mux.HandleFunc("DELETE /admin/cache/users/{id}", adminHandler.EvictCachedUser)
+
 	mux.HandleFunc("/public-api/users", func(w http.ResponseWriter, r *http.Request) {
 		startTime := time.Now()
-		
+
 		if r.Method == http.MethodPost {
 			slog.Info("Request received",
 				"method", r.Method,
 				"path", r.URL.Path,
 				"remote_addr", r.RemoteAddr,
 			)
-			
+
 			userHandler.CreateUser(w, r)
 		} else {
 			slog.Info("Method not allowed",
@@ -61,10 +107,10 @@ func main() {
 				"path", r.URL.Path,
 				"remote_addr", r.RemoteAddr,
 			)
-			
+
 			domain.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
 		}
-		
+
 		slog.Info("Request completed",
 			"method", r.Method,
 			"path", r.URL.Path,
@@ -74,13 +120,13 @@ func main() {
 
 	mux.HandleFunc("/public-api/listings", func(w http.ResponseWriter, r *http.Request) {
 		startTime := time.Now()
-		
+
 		slog.Info("Request received",
 			"method", r.Method,
 			"path", r.URL.Path,
 			"remote_addr", r.RemoteAddr,
 		)
-		
+
 		switch r.Method {
 		case http.MethodGet:
 			listingHandler.GetListings(w, r)
@@ -89,7 +135,7 @@ func main() {
 		default:
 			domain.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
 		}
-		
+
 		slog.Info("Request completed",
 			"method", r.Method,
 			"path", r.URL.Path,
@@ -97,17 +143,80 @@ func main() {
 		)
 	})
 
-	// Create middleware for logging unhandled errors
-	handler := logMiddleware(mux)
+	return mux
+}
 
-	// Start server
-	port := cfg.ServerPort
-	slog.Info("Server starting", "port", port)
-	
-	if err := http.ListenAndServe(":"+port, handler); err != nil {
-		slog.Error("Server failed to start", "error", err)
-		os.Exit(1)
+// runServer serves the public API until ctx is cancelled, then stops
+// accepting connections and waits up to cfg.ShutdownTimeout for in-flight
+// requests. A request still running after that has its context cancelled,
+// so its calls to the user and listing services (retries included) stop
+// rather than outliving the server. The idle connections of clients are
+// closed on the way out.
+func runServer(ctx context.Context, cfg *config.Config, handler http.Handler, clients ...*downstream.Client) error {
+	started := time.Now()
+
+	// Request contexts derive from base; cancelling it aborts every
+	// downstream call still in progress
+	base, abort := context.WithCancel(context.Background())
+	defer abort()
+
+	server := &http.Server{
+		Addr:              ":" + cfg.ServerPort,
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		BaseContext:       func(net.Listener) context.Context { return base },
 	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("Server starting", "port", cfg.ServerPort)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutdown signal received, draining requests", "timeout", cfg.ShutdownTimeout.String())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	err := server.Shutdown(shutdownCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		abort()
+		server.Close()
+	}
+
+	// The summary reports where each downstream circuit was left, which
+	// tells whether the shutdown coincided with a failing service
+	summary := []any{
+		"uptime", time.Since(started).Round(time.Second).String(),
+		"drained", err == nil,
+	}
+	for _, c := range clients {
+		c.CloseIdleConnections()
+		summary = append(summary, c.Service()+"_circuit", c.CircuitState())
+	}
+	slog.Info("Server stopped", summary...)
+
+	if err != nil {
+		return fmt.Errorf("graceful shutdown failed: %w", err)
+	}
+	return nil
+}
+
+// withMiddleware wraps the router in the middleware every request passes
+// through. requestIDMiddleware must stay outermost: it hands a copy of the
+// request down the chain, and metrics.Middleware reads the route pattern
+// ServeMux sets on the request it was given.
+func withMiddleware(mux *http.ServeMux) http.Handler {
+	return requestIDMiddleware(metrics.Middleware(logMiddleware(mux)))
 }
 
 // logMiddleware logs all requests and recovers from panics
@@ -115,7 +224,7 @@ func logMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				slog.Error("Recovered from panic", 
+				slog.Error("Recovered from panic",
 					"error", err,
 					"path", r.URL.Path,
 					"method", r.Method,
@@ -123,7 +232,24 @@ func logMiddleware(next http.Handler) http.Handler {
 				domain.RespondWithError(w, http.StatusInternalServerError, "Internal server error", nil)
 			}
 		}()
-		
+
 		next.ServeHTTP(w, r)
 	})
-}
\ No newline at end of file
+}
+
+// requestIDMiddleware tags each request with an ID that is passed on to
+// downstream services. A caller-supplied X-Request-ID is reused; otherwise a
+// random ID is generated. The ID is echoed in the response header.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(downstream.RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set(downstream.RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(downstream.WithRequestID(r.Context(), id)))
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"public-api/domain"
+	"public-api/downstream"
+	"public-api/handlers"
+)
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	router := newRouter(
+		handlers.NewUserHandler(nil),
+		handlers.NewListingHandler(nil),
+		handlers.NewHealthHandler(nil, nil, time.Second),
+		handlers.NewAdminHandler(nil, ""),
+	)
+
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{method: http.MethodGet, path: "/public-api/users"},
+		{method: http.MethodDelete, path: "/public-api/users"},
+		{method: http.MethodPut, path: "/public-api/listings"},
+		{method: http.MethodDelete, path: "/public-api/listings"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Fatalf("Expected status 405, got %d", rec.Code)
+			}
+
+			var body domain.ErrorResponse
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("Expected a JSON error body: %v", err)
+			}
+			if body.Code != http.StatusMethodNotAllowed || body.Message != "Method not allowed" {
+				t.Errorf("Unexpected error body: %+v", body)
+			}
+		})
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var forwarded string
+	handler := requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = downstream.RequestID(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/public-api/listings", nil)
+	req.Header.Set("X-Request-ID", "caller-id")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if forwarded != "caller-id" || rec.Header().Get("X-Request-ID") != "caller-id" {
+		t.Errorf("Expected the caller's ID reused, got %q and %q", forwarded, rec.Header().Get("X-Request-ID"))
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/public-api/listings", nil))
+	if forwarded == "" || forwarded != rec.Header().Get("X-Request-ID") {
+		t.Errorf("Expected a generated ID forwarded and echoed, got %q and %q", forwarded, rec.Header().Get("X-Request-ID"))
+	}
+}
+
+func TestMiddlewareRecordsRoute(t *testing.T) {
+	handler := withMiddleware(newRouter(
+		handlers.NewUserHandler(nil),
+		handlers.NewListingHandler(nil),
+		handlers.NewHealthHandler(nil, nil, time.Second),
+		handlers.NewAdminHandler(nil, ""),
+	))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/public-api/listings", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("X-Request-ID") == "" {
+		t.Fatalf("Expected 405 with a request ID, got %d and %v", rec.Code, rec.Header())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	want := `http_requests_total{method="DELETE",route="/public-api/listings",status="405"} `
+	if !strings.Contains(string(body), want) {
+		t.Errorf("Expected metrics to contain %q, got:\n%s", want, body)
+	}
+}
@@ -0,0 +1,279 @@
+// metrics/metrics.go
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics exposed on GET /metrics in the Prometheus text format
+var (
+	HTTPRequestsTotal = newCounterVec("http_requests_total",
+		"Total HTTP requests by method, route and status code.", "method", "route", "status")
+	HTTPRequestDuration = newHistogramVec("http_request_duration_seconds",
+		"HTTP request latency by method and route.", "method", "route")
+	DownstreamRequestDuration = newHistogramVec("downstream_request_duration_seconds",
+		"Latency of calls to downstream services by service, operation and outcome.", "service", "operation", "outcome")
+	DownstreamRetriesTotal = newCounterVec("downstream_retries_total",
+		"Retried calls to downstream services by service and reason (status code or error).", "service", "reason")
+	DownstreamCircuitState = newGaugeVec("downstream_circuit_state",
+		"Circuit breaker state per downstream service (0 closed, 1 half-open, 2 open).", "service")
+	DownstreamRejectedTotal = newCounterVec("downstream_requests_rejected_total",
+		"Calls to downstream services failed fast by an open circuit breaker.", "service")
+	UserCacheLookupsTotal = newCounterVec("user_cache_lookups_total",
+		"Listing enrichment user cache lookups by result (hit, stale, negative or miss).", "result")
+	UserCacheEntries = newGaugeVec("user_cache_entries",
+		"Users held in the listing enrichment cache.")
+)
+
+// exposed lists the metrics above in the order Handler writes them. Nothing
+// registers metrics at runtime, so the list is fixed.
+var exposed = []collector{
+	HTTPRequestsTotal,
+	HTTPRequestDuration,
+	DownstreamRequestDuration,
+	DownstreamRetriesTotal,
+	DownstreamCircuitState,
+	DownstreamRejectedTotal,
+	UserCacheLookupsTotal,
+	UserCacheEntries,
+}
+
+// latencyBuckets, in seconds, reach from a listings page served from the
+// user cache to a downstream call that used up its timeout and retries
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector writes one metric family in the Prometheus text format
+type collector interface {
+	collect(w io.Writer)
+}
+
+// Handler serves GET /metrics
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, c := range exposed {
+		c.collect(w)
+	}
+}
+
+// CounterVec is a counter partitioned by label values
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+// Inc increments the counter for the given label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	key := formatLabels(c.labels, labelValues)
+
+	c.mu.Lock()
+	c.values[key]++
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) collect(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, key, formatFloat(c.values[key]))
+	}
+}
+
+// GaugeVec is a gauge partitioned by label values
+type GaugeVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+// Set sets the gauge for the given label values
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	key := formatLabels(g.labels, labelValues)
+
+	g.mu.Lock()
+	g.values[key] = value
+	g.mu.Unlock()
+}
+
+func (g *GaugeVec) collect(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	for _, key := range sortedKeys(g.values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, key, formatFloat(g.values[key]))
+	}
+}
+
+// HistogramVec is a histogram over latencyBuckets partitioned by label values
+type HistogramVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+// histogram holds per-bucket (non-cumulative) counts for one label set
+type histogram struct {
+	labelValues []string
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+func newHistogramVec(name, help string, labels ...string) *HistogramVec {
+	return &HistogramVec{name: name, help: help, labels: labels, series: make(map[string]*histogram)}
+}
+
+// Observe records a duration for the given label values
+func (h *HistogramVec) Observe(d time.Duration, labelValues ...string) {
+	value := d.Seconds()
+	key := formatLabels(h.labels, labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{labelValues: labelValues, counts: make([]uint64, len(latencyBuckets))}
+		h.series[key] = s
+	}
+
+	for i, bound := range latencyBuckets {
+		if value <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *HistogramVec) collect(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		bucketLabels := append(append([]string(nil), h.labels...), "le")
+
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += s.counts[i]
+			le := formatLabels(bucketLabels, append(append([]string(nil), s.labelValues...), formatFloat(bound)))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, le, cumulative)
+		}
+		le := formatLabels(bucketLabels, append(append([]string(nil), s.labelValues...), "+Inf"))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, le, s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, key, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, s.count)
+	}
+}
+
+// Middleware records request counts and latency per route. The route is the
+// path of the ServeMux pattern that matched; the /public-api routes are
+// registered without a method, so their pattern is already just the path.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r)
+
+		route := r.Pattern
+		if _, path, ok := strings.Cut(route, " "); ok {
+			route = path
+		}
+		if route == "" {
+			route = "unmatched"
+		}
+
+		HTTPRequestsTotal.Inc(r.Method, route, strconv.Itoa(recorder.status))
+		HTTPRequestDuration.Observe(time.Since(start), r.Method, route)
+	})
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// formatLabels renders label pairs as {a="x",b="y"}, escaping values
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatFloat renders a sample value in its shortest form. Nothing here
+// observes infinities, so no special spelling is needed.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sortedKeys returns map keys in a stable order for deterministic output
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
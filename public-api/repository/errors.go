@@ -0,0 +1,32 @@
+// repository/errors.go
+package repository
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"public-api/domain"
+)
+
+// decodeUpstreamError reads the error envelope of a non-success response.
+// Bodies that aren't in the envelope format still produce an UpstreamError
+// carrying the status code.
+func decodeUpstreamError(service string, resp *http.Response) *domain.UpstreamError {
+	var body struct {
+		Error struct {
+			Code          string `json:"code"`
+			Message       string `json:"message"`
+			CorrelationID string `json:"correlation_id"`
+		} `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+
+	return &domain.UpstreamError{
+		Service:       service,
+		StatusCode:    resp.StatusCode,
+		Code:          body.Error.Code,
+		Message:       body.Error.Message,
+		CorrelationID: body.Error.CorrelationID,
+		RetryAfter:    resp.Header.Get("Retry-After"),
+	}
+}
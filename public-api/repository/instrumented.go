@@ -0,0 +1,73 @@
+// repository/instrumented.go
+package repository
+
+import (
+	"context"
+	"time"
+
+	"public-api/domain"
+	"public-api/metrics"
+)
+
+// Downstream service names used as the "service" metric label
+const (
+	userServiceName    = "user_service"
+	listingServiceName = "listing_service"
+)
+
+// observe records the latency and outcome of one downstream call
+func observe(service, operation string, start time.Time, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	metrics.DownstreamRequestDuration.Observe(time.Since(start), service, operation, outcome)
+}
+
+// InstrumentedUserRepository records call latency for a domain.UserRepository
+type InstrumentedUserRepository struct {
+	next domain.UserRepository
+}
+
+func NewInstrumentedUserRepository(next domain.UserRepository) *InstrumentedUserRepository {
+	return &InstrumentedUserRepository{next: next}
+}
+
+func (r *InstrumentedUserRepository) GetUserByID(ctx context.Context, id int) (user *domain.User, err error) {
+	defer func(start time.Time) { observe(userServiceName, "GetUserByID", start, err) }(time.Now())
+	return r.next.GetUserByID(ctx, id)
+}
+
+func (r *InstrumentedUserRepository) GetUsersByIDs(ctx context.Context, ids []int) (users []*domain.User, missing []int, err error) {
+	defer func(start time.Time) { observe(userServiceName, "GetUsersByIDs", start, err) }(time.Now())
+	return r.next.GetUsersByIDs(ctx, ids)
+}
+
+func (r *InstrumentedUserRepository) GetUsers(ctx context.Context, pageNum, pageSize int) (users []*domain.User, err error) {
+	defer func(start time.Time) { observe(userServiceName, "GetUsers", start, err) }(time.Now())
+	return r.next.GetUsers(ctx, pageNum, pageSize)
+}
+
+func (r *InstrumentedUserRepository) CreateUser(ctx context.Context, name, idempotencyKey string) (user *domain.User, err error) {
+	defer func(start time.Time) { observe(userServiceName, "CreateUser", start, err) }(time.Now())
+	return r.next.CreateUser(ctx, name, idempotencyKey)
+}
+
+// InstrumentedListingRepository records call latency for a domain.ListingRepository
+type InstrumentedListingRepository struct {
+	next domain.ListingRepository
+}
+
+func NewInstrumentedListingRepository(next domain.ListingRepository) *InstrumentedListingRepository {
+	return &InstrumentedListingRepository{next: next}
+}
+
+func (r *InstrumentedListingRepository) GetListings(ctx context.Context, pageNum, pageSize int, userID *int) (listings []*domain.Listing, err error) {
+	defer func(start time.Time) { observe(listingServiceName, "GetListings", start, err) }(time.Now())
+	return r.next.GetListings(ctx, pageNum, pageSize, userID)
+}
+
+func (r *InstrumentedListingRepository) CreateListing(ctx context.Context, userID int, listingType string, price int) (listing *domain.Listing, err error) {
+	defer func(start time.Time) { observe(listingServiceName, "CreateListing", start, err) }(time.Now())
+	return r.next.CreateListing(ctx, userID, listingType, price)
+}
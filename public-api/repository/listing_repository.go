@@ -1,42 +1,43 @@
 package repository
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"public-api/domain"
+	"public-api/downstream"
 	"strconv"
-	"strings"
 )
 
 type ListingRepository struct {
-	baseURL string
+	client *downstream.Client
 }
 
-func NewListingRepository(baseURL string) *ListingRepository {
+func NewListingRepository(client *downstream.Client) *ListingRepository {
 	return &ListingRepository{
-		baseURL: baseURL,
+		client: client,
 	}
 }
 
-func (r *ListingRepository) GetListings(pageNum, pageSize int, userID *int) ([]*domain.Listing, error) {
+func (r *ListingRepository) GetListings(ctx context.Context, pageNum, pageSize int, userID *int) ([]*domain.Listing, error) {
 	// Build URL with query parameters
-	reqURL := fmt.Sprintf("%s/listings?page_num=%d&page_size=%d", r.baseURL, pageNum, pageSize)
+	path := fmt.Sprintf("/listings?page_num=%d&page_size=%d", pageNum, pageSize)
 	if userID != nil {
-		reqURL = fmt.Sprintf("%s&user_id=%d", reqURL, *userID)
+		path = fmt.Sprintf("%s&user_id=%d", path, *userID)
 	}
 
 	slog.Debug("Fetching listings",
 		"page_num", pageNum,
 		"page_size", pageSize,
 		"user_id", userID,
-		"url", reqURL,
+		"path", path,
 	)
 
 	// Make HTTP request
-	resp, err := http.Get(reqURL)
+	resp, err := r.client.Get(ctx, path)
 	if err != nil {
 		slog.Error("Error making request to listing service", "error", err)
 		return nil, fmt.Errorf("error making request to listing service: %w", err)
@@ -84,27 +85,21 @@ func (r *ListingRepository) GetListings(pageNum, pageSize int, userID *int) ([]*
 	return listings, nil
 }
 
-func (r *ListingRepository) CreateListing(userID int, listingType string, price int) (*domain.Listing, error) {
+func (r *ListingRepository) CreateListing(ctx context.Context, userID int, listingType string, price int) (*domain.Listing, error) {
 	// Prepare form data
 	data := url.Values{}
 	data.Set("user_id", strconv.Itoa(userID))
 	data.Set("listing_type", listingType)
 	data.Set("price", strconv.Itoa(price))
 
-	reqURL := fmt.Sprintf("%s/listings", r.baseURL)
 	slog.Debug("Creating listing",
 		"user_id", userID,
 		"listing_type", listingType,
 		"price", price,
-		"url", reqURL,
 	)
 
 	// Make HTTP request
-	resp, err := http.Post(
-		reqURL,
-		"application/x-www-form-urlencoded",
-		strings.NewReader(data.Encode()),
-	)
+	resp, err := r.client.PostForm(ctx, "/listings", data, "")
 	if err != nil {
 		slog.Error("Error making request to listing service", "error", err)
 		return nil, fmt.Errorf("error making request to listing service: %w", err)
@@ -2,55 +2,180 @@
 package repository
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"public-api/domain"
+	"public-api/downstream"
+	"strconv"
 	"strings"
 )
 
 type UserRepository struct {
-	baseURL string
+	client *downstream.Client
 }
 
-func NewUserRepository(baseURL string) *UserRepository {
+func NewUserRepository(client *downstream.Client) *UserRepository {
 	return &UserRepository{
-		baseURL: baseURL,
+		client: client,
 	}
 }
 
-func (r *UserRepository) GetUserByID(id int) (*domain.User, error) {
+// maxUsersPerBatch is the most IDs the user service accepts in one GET /users?ids= request
+const maxUsersPerBatch = 100
+
+// userPayload is a user as the user service encodes it
+type userPayload struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+	DeletedAt *int64 `json:"deleted_at"`
+}
+
+func (u userPayload) toDomain() *domain.User {
+	return &domain.User{
+		ID:        u.ID,
+		Name:      u.Name,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+		DeletedAt: u.DeletedAt,
+	}
+}
+
+// GetUserByID fetches one user. A missing user is reported as
+// domain.ErrUserNotFound and a soft-deleted one as domain.ErrUserDeleted.
+func (r *UserRepository) GetUserByID(ctx context.Context, id int) (*domain.User, error) {
 	slog.Debug("Fetching user by ID", "user_id", id)
 
-	// Fetch users and filter by ID
-	users, err := r.GetUsers(1, 100)
+	// Make HTTP request
+	resp, err := r.client.Get(ctx, fmt.Sprintf("/users/%d", id))
 	if err != nil {
-		slog.Error("Failed to fetch users", "error", err)
-		return nil, err
+		slog.Error("Error making request to user service", "error", err)
+		return nil, fmt.Errorf("error making request to user service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		slog.Debug("User not found", "user_id", id)
+		return nil, fmt.Errorf("user %d: %w", id, domain.ErrUserNotFound)
+	case http.StatusGone:
+		slog.Debug("User has been deleted", "user_id", id)
+		return nil, fmt.Errorf("user %d: %w", id, domain.ErrUserDeleted)
+	default:
+		upstreamErr := decodeUpstreamError("user service", resp)
+		slog.Error("User service returned non-200 status",
+			"status", resp.StatusCode,
+			"code", upstreamErr.Code,
+			"correlation_id", upstreamErr.CorrelationID,
+		)
+		return nil, upstreamErr
+	}
+
+	// Parse response
+	var response struct {
+		Result bool        `json:"result"`
+		User   userPayload `json:"user"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		slog.Error("Error decoding response from user service", "error", err)
+		return nil, fmt.Errorf("error decoding response from user service: %w", err)
+	}
+
+	return response.User.toDomain(), nil
+}
+
+// GetUsersByIDs fetches users in batches of up to maxUsersPerBatch IDs.
+// Users come back in the order requested, duplicates removed.
+func (r *UserRepository) GetUsersByIDs(ctx context.Context, ids []int) ([]*domain.User, []int, error) {
+	// Remove duplicates so none is fetched twice across batches
+	seen := make(map[int]bool, len(ids))
+	unique := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
 	}
+	ids = unique
 
-	for _, user := range users {
-		if user.ID == id {
-			return user, nil
+	users := make([]*domain.User, 0, len(ids))
+	missing := make([]int, 0)
+
+	for start := 0; start < len(ids); start += maxUsersPerBatch {
+		batch := ids[start:min(start+maxUsersPerBatch, len(ids))]
+		found, notFound, err := r.getUsersBatch(ctx, batch)
+		if err != nil {
+			return nil, nil, err
 		}
+		users = append(users, found...)
+		missing = append(missing, notFound...)
+	}
+
+	return users, missing, nil
+}
+
+// getUsersBatch makes a single GET /users?ids= request
+func (r *UserRepository) getUsersBatch(ctx context.Context, ids []int) ([]*domain.User, []int, error) {
+	idStrs := make([]string, len(ids))
+	for i, id := range ids {
+		idStrs[i] = strconv.Itoa(id)
+	}
+
+	// Make HTTP request
+	path := "/users?ids=" + strings.Join(idStrs, ",")
+	slog.Debug("Fetching users by IDs", "count", len(ids), "path", path)
+	resp, err := r.client.Get(ctx, path)
+	if err != nil {
+		slog.Error("Error making request to user service", "error", err)
+		return nil, nil, fmt.Errorf("error making request to user service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	if resp.StatusCode != http.StatusOK {
+		upstreamErr := decodeUpstreamError("user service", resp)
+		slog.Error("User service returned non-200 status",
+			"status", resp.StatusCode,
+			"code", upstreamErr.Code,
+			"correlation_id", upstreamErr.CorrelationID,
+		)
+		return nil, nil, upstreamErr
 	}
 
-	slog.Warn("User not found", "user_id", id)
-	return nil, errors.New("user not found")
+	// Parse response
+	var response struct {
+		Result     bool          `json:"result"`
+		Users      []userPayload `json:"users"`
+		MissingIDs []int         `json:"missing_ids"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		slog.Error("Error decoding response from user service", "error", err)
+		return nil, nil, fmt.Errorf("error decoding response from user service: %w", err)
+	}
+
+	users := make([]*domain.User, 0, len(response.Users))
+	for _, u := range response.Users {
+		users = append(users, u.toDomain())
+	}
+	return users, response.MissingIDs, nil
 }
 
-func (r *UserRepository) GetUsers(pageNum, pageSize int) ([]*domain.User, error) {
+func (r *UserRepository) GetUsers(ctx context.Context, pageNum, pageSize int) ([]*domain.User, error) {
 	slog.Debug("Fetching users", "page_num", pageNum, "page_size", pageSize)
 
 	// Build URL with query parameters
-	reqURL := fmt.Sprintf("%s/users?page_num=%d&page_size=%d", r.baseURL, pageNum, pageSize)
+	path := fmt.Sprintf("/users?page_num=%d&page_size=%d", pageNum, pageSize)
 
 	// Make HTTP request
-	slog.Debug("Making request to user service", "url", reqURL)
-	resp, err := http.Get(reqURL)
+	slog.Debug("Making request to user service", "path", path)
+	resp, err := r.client.Get(ctx, path)
 	if err != nil {
 		slog.Error("Error making request to user service", "error", err)
 		return nil, fmt.Errorf("error making request to user service: %w", err)
@@ -59,8 +184,13 @@ func (r *UserRepository) GetUsers(pageNum, pageSize int) ([]*domain.User, error)
 
 	// Check response status
 	if resp.StatusCode != http.StatusOK {
-		slog.Error("User service returned non-200 status", "status", resp.StatusCode)
-		return nil, fmt.Errorf("user service returned non-200 status: %d", resp.StatusCode)
+		upstreamErr := decodeUpstreamError("user service", resp)
+		slog.Error("User service returned non-200 status",
+			"status", resp.StatusCode,
+			"code", upstreamErr.Code,
+			"correlation_id", upstreamErr.CorrelationID,
+		)
+		return nil, upstreamErr
 	}
 
 	// Parse response
@@ -94,7 +224,10 @@ func (r *UserRepository) GetUsers(pageNum, pageSize int) ([]*domain.User, error)
 	return users, nil
 }
 
-func (r *UserRepository) CreateUser(name string) (*domain.User, error) {
+// CreateUser creates a user. With an idempotency key the request is safe to
+// retry; the user service replays the first response instead of creating
+// another user.
+func (r *UserRepository) CreateUser(ctx context.Context, name, idempotencyKey string) (*domain.User, error) {
 	slog.Debug("Creating user", "name", name)
 
 	// Prepare form data
@@ -102,14 +235,9 @@ func (r *UserRepository) CreateUser(name string) (*domain.User, error) {
 	data.Set("name", name)
 
 	// Make HTTP request
-	reqURL := fmt.Sprintf("%s/users", r.baseURL)
-	slog.Debug("Making request to user service", "url", reqURL)
-
-	resp, err := http.Post(
-		reqURL,
-		"application/x-www-form-urlencoded",
-		strings.NewReader(data.Encode()),
-	)
+	slog.Debug("Making request to user service", "path", "/users")
+
+	resp, err := r.client.PostForm(ctx, "/users", data, idempotencyKey)
 	if err != nil {
 		slog.Error("Error making request to user service", "error", err)
 		return nil, fmt.Errorf("error making request to user service: %w", err)
@@ -118,8 +246,13 @@ func (r *UserRepository) CreateUser(name string) (*domain.User, error) {
 
 	// Check response status
 	if resp.StatusCode != http.StatusCreated {
-		slog.Error("User service returned status", "status", resp.StatusCode)
-		return nil, fmt.Errorf("user service returned status: %d", resp.StatusCode)
+		upstreamErr := decodeUpstreamError("user service", resp)
+		slog.Error("User service returned status",
+			"status", resp.StatusCode,
+			"code", upstreamErr.Code,
+			"correlation_id", upstreamErr.CorrelationID,
+		)
+		return nil, upstreamErr
 	}
 
 	// Parse response
@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"public-api/config"
+	"public-api/domain"
+	"public-api/downstream"
+)
+
+// newTestClient returns a downstream client for a test server
+func newTestClient(baseURL string) *downstream.Client {
+	return downstream.NewWithHTTPClient("user_service", baseURL, config.DownstreamConfig{
+		UserAgent: "public-api-test",
+		Retry:     config.RetryConfig{MaxAttempts: 1},
+	}, &http.Client{Timeout: 5 * time.Second})
+}
+
+func TestUserRepositoryGetUserByID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/users/1":
+			fmt.Fprint(w, `{"result":true,"user":{"id":1,"name":"Alice","created_at":1000,"updated_at":1500}}`)
+		case "/users/2":
+			w.WriteHeader(http.StatusGone)
+			fmt.Fprint(w, `{"result":false,"error":{"code":"gone","message":"User has been deleted"},"user":{"id":2,"name":"Bob","created_at":1,"updated_at":2,"deleted_at":2}}`)
+		case "/users/500":
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"result":false,"error":{"code":"internal","message":"Failed to fetch user","correlation_id":"abc"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"result":false,"error":{"code":"not_found","message":"User not found"}}`)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	repo := NewUserRepository(newTestClient(server.URL))
+
+	user, err := repo.GetUserByID(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetUserByID(1) failed: %v", err)
+	}
+	if *user != (domain.User{ID: 1, Name: "Alice", CreatedAt: 1000, UpdatedAt: 1500}) {
+		t.Errorf("Unexpected user %+v", *user)
+	}
+
+	if _, err := repo.GetUserByID(ctx, 404); !errors.Is(err, domain.ErrUserNotFound) || errors.Is(err, domain.ErrUserDeleted) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+	if _, err := repo.GetUserByID(ctx, 2); !errors.Is(err, domain.ErrUserDeleted) || !errors.Is(err, domain.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserDeleted wrapping ErrUserNotFound, got %v", err)
+	}
+
+	var upstreamErr *domain.UpstreamError
+	if _, err := repo.GetUserByID(ctx, 500); !errors.As(err, &upstreamErr) || upstreamErr.CorrelationID != "abc" {
+		t.Errorf("Expected an UpstreamError with the correlation ID, got %v", err)
+	}
+}
+
+func TestUserRepositoryCreateUserInProgress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Idempotency-Key") != "create-alice" {
+			t.Errorf("Expected the idempotency key passed on, got %q", r.Header.Get("Idempotency-Key"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, `{"result":false,"error":{"code":"idempotency_key_in_use","message":"A request with this Idempotency-Key is still being processed"}}`)
+	}))
+	defer server.Close()
+
+	repo := NewUserRepository(newTestClient(server.URL))
+	_, err := repo.CreateUser(context.Background(), "Alice", "create-alice")
+
+	var upstreamErr *domain.UpstreamError
+	if !errors.As(err, &upstreamErr) || upstreamErr.StatusCode != http.StatusConflict ||
+		upstreamErr.Code != "idempotency_key_in_use" || upstreamErr.RetryAfter != "1" {
+		t.Errorf("Expected a 409 UpstreamError with Retry-After, got %v", err)
+	}
+}
+
+func TestUserRepositoryGetUsersByIDs(t *testing.T) {
+	var requests [][]int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ids []int
+		for _, s := range strings.Split(r.URL.Query().Get("ids"), ",") {
+			id, _ := strconv.Atoi(s)
+			ids = append(ids, id)
+		}
+		requests = append(requests, ids)
+
+		// Even IDs exist, odd ones don't
+		var users, missing []string
+		for _, id := range ids {
+			if id%2 == 0 {
+				users = append(users, fmt.Sprintf(`{"id":%d,"name":"User %d","created_at":1,"updated_at":1}`, id, id))
+			} else {
+				missing = append(missing, strconv.Itoa(id))
+			}
+		}
+		fmt.Fprintf(w, `{"result":true,"users":[%s],"missing_ids":[%s]}`, strings.Join(users, ","), strings.Join(missing, ","))
+	}))
+	defer server.Close()
+
+	ids := []int{4}
+	for id := 1; id <= 150; id++ {
+		ids = append(ids, id)
+	}
+
+	users, missing, err := NewUserRepository(newTestClient(server.URL)).GetUsersByIDs(context.Background(), ids)
+	if err != nil {
+		t.Fatalf("GetUsersByIDs failed: %v", err)
+	}
+
+	// 150 unique IDs need two requests, the duplicate 4 isn't sent twice
+	if len(requests) != 2 || len(requests[0]) != maxUsersPerBatch || len(requests[1]) != 50 {
+		t.Errorf("Expected batches of 100 and 50 IDs, got %d requests", len(requests))
+	}
+	if len(users) != 75 || users[0].ID != 4 || users[1].ID != 2 {
+		t.Errorf("Expected 75 users in request order starting 4, 2, got %d", len(users))
+	}
+	if len(missing) != 75 || !slices.IsSorted(missing) || missing[0] != 1 {
+		t.Errorf("Expected the 75 odd IDs missing, got %v", missing)
+	}
+}
@@ -1,79 +1,91 @@
 package usecase
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"public-api/domain"
-	"sync"
+	"public-api/metrics"
 )
 
 type ListingUseCase struct {
 	listingRepo domain.ListingRepository
 	userRepo    domain.UserRepository
-	userCache   sync.Map // For caching users
+	userCache   *UserCache
 }
 
-func NewListingUseCase(listingRepo domain.ListingRepository, userRepo domain.UserRepository) *ListingUseCase {
+func NewListingUseCase(listingRepo domain.ListingRepository, userRepo domain.UserRepository, userCache *UserCache) *ListingUseCase {
 	return &ListingUseCase{
 		listingRepo: listingRepo,
 		userRepo:    userRepo,
-		userCache:   sync.Map{}, // Initialize the cache
+		userCache:   userCache,
 	}
 }
 
-func (u *ListingUseCase) GetListings(pageNum, pageSize int, userID *int) ([]*domain.ListingWithUser, error) {
+func (u *ListingUseCase) GetListings(ctx context.Context, pageNum, pageSize int, userID *int) ([]*domain.ListingWithUser, error) {
 	// Get listings
-	listings, err := u.listingRepo.GetListings(pageNum, pageSize, userID)
+	listings, err := u.listingRepo.GetListings(ctx, pageNum, pageSize, userID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Create a map to store unique user IDs
-	userIDs := make(map[int]bool)
+	// Collect the unique user IDs, in listing order
+	var userIDs []int
+	seen := make(map[int]bool)
 	for _, listing := range listings {
-		userIDs[listing.UserID] = true
+		if !seen[listing.UserID] {
+			seen[listing.UserID] = true
+			userIDs = append(userIDs, listing.UserID)
+		}
 	}
 
-	// Concurrent user fetching with caching
-	users := make(map[int]*domain.User)
-	var wg sync.WaitGroup
-	var mu sync.Mutex // Mutex to protect the users map
-
-	errChan := make(chan error, len(userIDs)) // Buffered channel for errors
-
-	for userID := range userIDs {
-		wg.Add(1)
-		go func(userID int) {
-			defer wg.Done()
-
-			// Check cache first
-			if cachedUser, ok := u.userCache.Load(userID); ok {
-				mu.Lock()
-				users[userID] = cachedUser.(*domain.User)
-				mu.Unlock()
-				return
-			}
-
-			user, err := u.userRepo.GetUserByID(userID)
-			if err != nil {
-				errChan <- fmt.Errorf("error fetching user data for listing: %w", err) // Send error to channel
-				return
-			}
-
-			// Store in cache
-			u.userCache.Store(userID, user)
+	// Take what we can from the cache and fetch the rest in one batch.
+	// Stale users are served as they are and refreshed in the background.
+	// Users the user service doesn't know stay nil in users, and their
+	// listings are returned without a user.
+	users := make(map[int]*domain.User, len(userIDs))
+	var uncached, refresh []int
+	gen := u.userCache.generation()
+	for _, userID := range userIDs {
+		user, status, needsRefresh := u.userCache.get(userID)
+		switch {
+		case status == cacheMiss:
+			metrics.UserCacheLookupsTotal.Inc("miss")
+			uncached = append(uncached, userID)
+			continue
+		case user == nil:
+			metrics.UserCacheLookupsTotal.Inc("negative")
+		case status == cacheStale:
+			metrics.UserCacheLookupsTotal.Inc("stale")
+		default:
+			metrics.UserCacheLookupsTotal.Inc("hit")
+		}
+		users[userID] = user
+		if needsRefresh {
+			refresh = append(refresh, userID)
+		}
+	}
 
-			mu.Lock()
-			users[userID] = user
-			mu.Unlock()
-		}(userID)
+	if len(refresh) > 0 {
+		// The refresh outlives the request but keeps its request ID
+		go u.refreshUsers(context.WithoutCancel(ctx), gen, refresh)
 	}
 
-	wg.Wait()
-	close(errChan) // Close the error channel
+	if len(uncached) > 0 {
+		fetched, missing, err := u.userRepo.GetUsersByIDs(ctx, uncached)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching user data for listings: %w", err)
+		}
+		for _, id := range missing {
+			u.userCache.store(gen, id, nil)
+			users[id] = nil
+		}
 
-	// Check for errors from goroutines
-	for err := range errChan {
-		return nil, err // Return the first error encountered
+		for _, user := range fetched {
+			// Store in cache
+			u.userCache.store(gen, user.ID, user)
+			users[user.ID] = user
+		}
 	}
 
 	// Combine listings with user data using the cached users
@@ -85,7 +97,7 @@ func (u *ListingUseCase) GetListings(pageNum, pageSize int, userID *int) ([]*dom
 		}
 		listingWithUser := &domain.ListingWithUser{
 			Listing: *listing,
-			User:    *user,
+			User:    user,
 		}
 		listingsWithUsers = append(listingsWithUsers, listingWithUser)
 	}
@@ -93,13 +105,32 @@ func (u *ListingUseCase) GetListings(pageNum, pageSize int, userID *int) ([]*dom
 	return listingsWithUsers, nil
 }
 
-func (u *ListingUseCase) CreateListing(userID int, listingType string, price int) (*domain.Listing, error) {
-	// Check if user exists
-	_, err := u.userRepo.GetUserByID(userID)
+// refreshUsers fetches stale cached users again. If the fetch fails the
+// stale entries are kept and the next lookup tries again. gen is the cache
+// generation the entries were read in.
+func (u *ListingUseCase) refreshUsers(ctx context.Context, gen uint64, ids []int) {
+	fetched, missing, err := u.userRepo.GetUsersByIDs(ctx, ids)
+	if err != nil {
+		slog.Warn("Failed to refresh cached users", "count", len(ids), "error", err)
+		u.userCache.abortRefresh(ids)
+		return
+	}
+
+	for _, user := range fetched {
+		u.userCache.store(gen, user.ID, user)
+	}
+	for _, id := range missing {
+		u.userCache.store(gen, id, nil)
+	}
+}
+
+func (u *ListingUseCase) CreateListing(ctx context.Context, userID int, listingType string, price int) (*domain.Listing, error) {
+	// Check if user exists; deleted users can't get new listings
+	_, err := u.userRepo.GetUserByID(ctx, userID)
 	if err != nil {
 		return nil, fmt.Errorf("invalid user_id: %w", err)
 	}
 
 	// Create listing
-	return u.listingRepo.CreateListing(userID, listingType, price)
+	return u.listingRepo.CreateListing(ctx, userID, listingType, price)
 }
@@ -0,0 +1,158 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"public-api/config"
+	"public-api/domain"
+)
+
+// fakeListingRepository returns a fixed set of listings
+type fakeListingRepository struct {
+	listings []*domain.Listing
+}
+
+func (r *fakeListingRepository) GetListings(ctx context.Context, pageNum, pageSize int, userID *int) ([]*domain.Listing, error) {
+	return r.listings, nil
+}
+
+func (r *fakeListingRepository) CreateListing(ctx context.Context, userID int, listingType string, price int) (*domain.Listing, error) {
+	return nil, errors.New("not implemented")
+}
+
+// fakeUserRepository serves users by ID and records every batch lookup
+type fakeUserRepository struct {
+	mu      sync.Mutex
+	users   map[int]*domain.User
+	batches [][]int
+	fetched chan []int
+}
+
+func (r *fakeUserRepository) GetUsersByIDs(ctx context.Context, ids []int) ([]*domain.User, []int, error) {
+	r.mu.Lock()
+	r.batches = append(r.batches, ids)
+	var users []*domain.User
+	var missing []int
+	for _, id := range ids {
+		if user, ok := r.users[id]; ok {
+			copied := *user
+			users = append(users, &copied)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	r.mu.Unlock()
+
+	if r.fetched != nil {
+		r.fetched <- ids
+	}
+	return users, missing, nil
+}
+
+func (r *fakeUserRepository) setName(id int, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users[id].Name = name
+}
+
+func (r *fakeUserRepository) batchCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.batches)
+}
+
+func (r *fakeUserRepository) GetUserByID(ctx context.Context, id int) (*domain.User, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (r *fakeUserRepository) GetUsers(ctx context.Context, pageNum, pageSize int) ([]*domain.User, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (r *fakeUserRepository) CreateUser(ctx context.Context, name, idempotencyKey string) (*domain.User, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestGetListingsServesStaleUsersWhileRefreshing(t *testing.T) {
+	listings := &fakeListingRepository{listings: []*domain.Listing{{ID: 1, UserID: 1}, {ID: 2, UserID: 2}, {ID: 3, UserID: 1}}}
+	users := &fakeUserRepository{users: map[int]*domain.User{1: {ID: 1, Name: "Ann"}, 2: {ID: 2, Name: "Bob"}}}
+	cache, now := testUserCache(config.UserCacheConfig{Size: 10, TTL: time.Minute, StaleTTL: time.Minute})
+	u := NewListingUseCase(listings, users, cache)
+	ctx := context.Background()
+
+	// Both users are fetched in one batch, then served from the cache
+	for range 2 {
+		if _, err := u.GetListings(ctx, 1, 10, nil); err != nil {
+			t.Fatalf("GetListings failed: %v", err)
+		}
+	}
+	if users.batchCount() != 1 || !slices.Equal(users.batches[0], []int{1, 2}) {
+		t.Fatalf("Expected one batch for users 1 and 2, got %v", users.batches)
+	}
+
+	// Once stale, the old name is served and a refresh picks up the new one
+	users.setName(1, "Anna")
+	users.fetched = make(chan []int, 1)
+	*now = now.Add(90 * time.Second)
+
+	result, err := u.GetListings(ctx, 1, 10, nil)
+	if err != nil {
+		t.Fatalf("GetListings failed: %v", err)
+	}
+	if result[0].User.Name != "Ann" {
+		t.Errorf("Expected the stale name served, got %q", result[0].User.Name)
+	}
+	select {
+	case ids := <-users.fetched:
+		if !slices.Equal(ids, []int{1, 2}) {
+			t.Errorf("Expected users 1 and 2 refreshed, got %v", ids)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a background refresh")
+	}
+
+	// store runs right after the fetch returns
+	deadline := time.Now().Add(time.Second)
+	for {
+		if user, status, _ := cache.get(1); status == cacheFresh && user.Name == "Anna" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the refreshed user cached")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGetListingsCachesMissingUsers(t *testing.T) {
+	listings := &fakeListingRepository{listings: []*domain.Listing{{ID: 1, UserID: 9}, {ID: 2, UserID: 1}}}
+	users := &fakeUserRepository{users: map[int]*domain.User{1: {ID: 1, Name: "Ann"}}}
+	cache, now := testUserCache(config.UserCacheConfig{Size: 10, TTL: time.Minute, NegativeTTL: 10 * time.Second})
+	u := NewListingUseCase(listings, users, cache)
+
+	// The unknown user only costs its listing the user, whether it was just
+	// looked up or is remembered as missing
+	for range 2 {
+		result, err := u.GetListings(context.Background(), 1, 10, nil)
+		if err != nil {
+			t.Fatalf("GetListings failed: %v", err)
+		}
+		if len(result) != 2 || result[0].User != nil || result[1].User == nil || result[1].User.Name != "Ann" {
+			t.Fatalf("Expected listing 1 without a user and listing 2 with Ann, got %+v", result)
+		}
+	}
+	if users.batchCount() != 1 {
+		t.Errorf("Expected the missing user looked up once, got %d lookups", users.batchCount())
+	}
+
+	*now = now.Add(10 * time.Second)
+	u.GetListings(context.Background(), 1, 10, nil)
+	if users.batchCount() != 2 {
+		t.Errorf("Expected the missing user looked up again after the negative TTL, got %d lookups", users.batchCount())
+	}
+}
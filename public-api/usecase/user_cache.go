@@ -0,0 +1,184 @@
+package usecase
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"public-api/config"
+	"public-api/domain"
+	"public-api/metrics"
+)
+
+// cacheStatus is the result of a user cache lookup
+type cacheStatus int
+
+const (
+	cacheMiss cacheStatus = iota
+	// cacheFresh means the entry is within its TTL
+	cacheFresh
+	// cacheStale means the entry is past its TTL but may still be served
+	// while it is refreshed
+	cacheStale
+)
+
+// UserCache is a size-bounded LRU cache of users with a TTL. It also
+// remembers user IDs the user service doesn't know, for a shorter time.
+type UserCache struct {
+	cfg config.UserCacheConfig
+	now func() time.Time
+
+	mu sync.Mutex
+	// gen counts flushes and evictions. A fetch stores its users only if
+	// gen hasn't moved since it started, so a fetch that was in flight
+	// can't put back what an operator just removed.
+	gen     uint64
+	entries map[int]*list.Element
+	// order holds *cachedUser values, most recently used first
+	order *list.List
+}
+
+type cachedUser struct {
+	id int
+	// user is nil if the user doesn't exist
+	user       *domain.User
+	freshUntil time.Time
+	staleUntil time.Time
+	refreshing bool
+}
+
+// NewUserCache creates an empty cache. A size of 0 disables caching.
+func NewUserCache(cfg config.UserCacheConfig) *UserCache {
+	metrics.UserCacheEntries.Set(0)
+	return &UserCache{
+		cfg:     cfg,
+		now:     time.Now,
+		entries: make(map[int]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get looks up a user. A nil user with a hit status means the user is known
+// not to exist. refresh is true for the first caller that finds an entry
+// stale; it must call store or abortRefresh for the ID when done.
+func (c *UserCache) get(id int) (user *domain.User, status cacheStatus, refresh bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[id]
+	if !ok {
+		return nil, cacheMiss, false
+	}
+	entry := elem.Value.(*cachedUser)
+
+	now := c.now()
+	switch {
+	case now.Before(entry.freshUntil):
+		c.order.MoveToFront(elem)
+		return entry.user, cacheFresh, false
+	case now.Before(entry.staleUntil):
+		c.order.MoveToFront(elem)
+		refresh = !entry.refreshing
+		entry.refreshing = true
+		return entry.user, cacheStale, refresh
+	default:
+		c.remove(elem)
+		return nil, cacheMiss, false
+	}
+}
+
+// generation returns the value to pass to store for a fetch starting now
+func (c *UserCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// store caches user under id, or remembers id as missing if user is nil.
+// The least recently used entry is evicted when the cache is full. Users
+// fetched before a flush or eviction, i.e. under an older gen, are dropped.
+func (c *UserCache) store(gen uint64, id int, user *domain.User) {
+	if c.cfg.Size <= 0 {
+		return
+	}
+
+	now := c.now()
+	entry := &cachedUser{id: id, user: user}
+	if user != nil {
+		entry.freshUntil = now.Add(c.cfg.TTL)
+		entry.staleUntil = entry.freshUntil.Add(c.cfg.StaleTTL)
+	} else {
+		// A missing user is never served stale; it may have been created since
+		entry.freshUntil = now.Add(c.cfg.NegativeTTL)
+		entry.staleUntil = entry.freshUntil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+	if elem, ok := c.entries[id]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[id] = c.order.PushFront(entry)
+	for c.order.Len() > c.cfg.Size {
+		c.remove(c.order.Back())
+	}
+	metrics.UserCacheEntries.Set(float64(c.order.Len()))
+}
+
+// abortRefresh lets the next lookup of stale entries retry a refresh that failed
+func (c *UserCache) abortRefresh(ids []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range ids {
+		if elem, ok := c.entries[id]; ok {
+			elem.Value.(*cachedUser).refreshing = false
+		}
+	}
+}
+
+// Evict removes a user from the cache and reports whether it was cached
+func (c *UserCache) Evict(id int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	elem, ok := c.entries[id]
+	if ok {
+		c.remove(elem)
+	}
+	return ok
+}
+
+// Flush empties the cache and returns how many entries it held
+func (c *UserCache) Flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	n := c.order.Len()
+	c.entries = make(map[int]*list.Element)
+	c.order.Init()
+	metrics.UserCacheEntries.Set(0)
+	return n
+}
+
+// Len returns the number of cached entries, including missing users
+func (c *UserCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// remove deletes elem from the cache; c.mu must be held
+func (c *UserCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cachedUser).id)
+	metrics.UserCacheEntries.Set(float64(c.order.Len()))
+}
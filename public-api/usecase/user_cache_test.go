@@ -0,0 +1,130 @@
+package usecase
+
+import (
+	"testing"
+	"time"
+
+	"public-api/config"
+	"public-api/domain"
+)
+
+// testUserCache returns a cache on a clock the test moves forward
+func testUserCache(cfg config.UserCacheConfig) (*UserCache, *time.Time) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := NewUserCache(cfg)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestUserCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c, _ := testUserCache(config.UserCacheConfig{Size: 2, TTL: time.Minute})
+
+	c.store(0, 1, &domain.User{ID: 1})
+	c.store(0, 2, &domain.User{ID: 2})
+	c.get(1) // 2 is now the least recently used
+	c.store(0, 3, &domain.User{ID: 3})
+
+	if _, status, _ := c.get(2); status != cacheMiss {
+		t.Errorf("Expected user 2 evicted, got status %d", status)
+	}
+	for _, id := range []int{1, 3} {
+		if user, status, _ := c.get(id); status != cacheFresh || user.ID != id {
+			t.Errorf("Expected user %d cached, got %v with status %d", id, user, status)
+		}
+	}
+	if c.Len() != 2 {
+		t.Errorf("Expected 2 entries, got %d", c.Len())
+	}
+}
+
+func TestUserCacheExpiry(t *testing.T) {
+	c, now := testUserCache(config.UserCacheConfig{Size: 10, TTL: time.Minute, StaleTTL: time.Minute, NegativeTTL: 10 * time.Second})
+
+	c.store(0, 1, &domain.User{ID: 1})
+	c.store(0, 2, nil)
+
+	if user, status, _ := c.get(2); status != cacheFresh || user != nil {
+		t.Errorf("Expected user 2 cached as missing, got %v with status %d", user, status)
+	}
+
+	// Missing users expire after the negative TTL and are never stale
+	*now = now.Add(10 * time.Second)
+	if _, status, _ := c.get(2); status != cacheMiss {
+		t.Errorf("Expected the missing user expired, got status %d", status)
+	}
+
+	// Only the first lookup of a stale user is asked to refresh it
+	*now = now.Add(time.Minute)
+	if user, status, refresh := c.get(1); status != cacheStale || user == nil || !refresh {
+		t.Errorf("Expected user 1 stale and due for refresh, got %v with status %d, refresh %v", user, status, refresh)
+	}
+	if _, _, refresh := c.get(1); refresh {
+		t.Error("Expected only one refresh at a time")
+	}
+	c.abortRefresh([]int{1})
+	if _, _, refresh := c.get(1); !refresh {
+		t.Error("Expected a refresh again after the failed one")
+	}
+	c.store(0, 1, &domain.User{ID: 1, Name: "Renamed"})
+	if user, status, _ := c.get(1); status != cacheFresh || user.Name != "Renamed" {
+		t.Errorf("Expected the refreshed user fresh, got %v with status %d", user, status)
+	}
+
+	// Past the stale window the entry is gone
+	*now = now.Add(2 * time.Minute)
+	if _, status, _ := c.get(1); status != cacheMiss || c.Len() != 0 {
+		t.Errorf("Expected user 1 expired, got status %d and %d entries", status, c.Len())
+	}
+}
+
+func TestUserCacheEvictAndFlush(t *testing.T) {
+	c, _ := testUserCache(config.UserCacheConfig{Size: 10, TTL: time.Minute})
+	for id := 1; id <= 3; id++ {
+		c.store(0, id, &domain.User{ID: id})
+	}
+
+	if !c.Evict(2) || c.Evict(2) {
+		t.Error("Expected user 2 evicted exactly once")
+	}
+	if n := c.Flush(); n != 2 || c.Len() != 0 {
+		t.Errorf("Expected 2 entries flushed, got %d with %d left", n, c.Len())
+	}
+}
+
+func TestUserCacheDropsFetchesStartedBeforeFlush(t *testing.T) {
+	c, now := testUserCache(config.UserCacheConfig{Size: 10, TTL: time.Minute, StaleTTL: time.Minute})
+	c.store(0, 1, &domain.User{ID: 1, Name: "Old"})
+
+	// A refresh of the stale user finishes after an operator flushed it
+	*now = now.Add(90 * time.Second)
+	if _, _, refresh := c.get(1); !refresh {
+		t.Fatal("Expected user 1 due for refresh")
+	}
+	gen := c.generation()
+	c.Flush()
+	c.store(gen, 1, &domain.User{ID: 1, Name: "Old"})
+	if _, status, _ := c.get(1); status != cacheMiss {
+		t.Errorf("Expected the refresh from before the flush dropped, got status %d", status)
+	}
+
+	// Evicting any user ends the generation too
+	gen = c.generation()
+	c.Evict(2)
+	c.store(gen, 1, &domain.User{ID: 1})
+	if c.Len() != 0 {
+		t.Errorf("Expected the fetch from before the eviction dropped, got %d entries", c.Len())
+	}
+
+	c.store(c.generation(), 1, &domain.User{ID: 1, Name: "New"})
+	if user, status, _ := c.get(1); status != cacheFresh || user.Name != "New" {
+		t.Errorf("Expected a fetch after the flush cached, got %v with status %d", user, status)
+	}
+}
+
+func TestUserCacheDisabled(t *testing.T) {
+	c, _ := testUserCache(config.UserCacheConfig{Size: 0, TTL: time.Minute})
+	c.store(0, 1, &domain.User{ID: 1})
+	if _, status, _ := c.get(1); status != cacheMiss {
+		t.Errorf("Expected nothing cached, got status %d", status)
+	}
+}
@@ -2,6 +2,8 @@
 package usecase
 
 import (
+	"context"
+
 	"public-api/domain"
 )
 
@@ -15,14 +17,14 @@ func NewUserUseCase(userRepo domain.UserRepository) *UserUseCase {
 	}
 }
 
-func (u *UserUseCase) GetUserByID(id int) (*domain.User, error) {
-	return u.userRepo.GetUserByID(id)
+func (u *UserUseCase) GetUserByID(ctx context.Context, id int) (*domain.User, error) {
+	return u.userRepo.GetUserByID(ctx, id)
 }
 
-func (u *UserUseCase) GetUsers(pageNum, pageSize int) ([]*domain.User, error) {
-	return u.userRepo.GetUsers(pageNum, pageSize)
+func (u *UserUseCase) GetUsers(ctx context.Context, pageNum, pageSize int) ([]*domain.User, error) {
+	return u.userRepo.GetUsers(ctx, pageNum, pageSize)
 }
 
-func (u *UserUseCase) CreateUser(name string) (*domain.User, error) {
-	return u.userRepo.CreateUser(name)
+func (u *UserUseCase) CreateUser(ctx context.Context, name, idempotencyKey string) (*domain.User, error) {
+	return u.userRepo.CreateUser(ctx, name, idempotencyKey)
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// MaxUserBodyBytes limits the size of create and update request bodies
+const MaxUserBodyBytes = 64 << 10
+
+// userInput is the body of POST /users and PATCH /users/{id}
+type userInput struct {
+	Name *string `json:"name"`
+}
+
+// Errors describing why a request body was rejected
+var (
+	errUnsupportedMediaType = errors.New("unsupported media type")
+	errEmptyBody            = errors.New("request body is empty")
+	errTrailingData         = errors.New("request body must contain a single JSON object")
+)
+
+// decodeUserInput reads the user fields from a JSON, URL-encoded or multipart
+// body, chosen by Content-Type (URL-encoded if none is given). Whatever the
+// encoding, validation failures are reported the same way. When the body is
+// rejected an error response has already been written and ok is false.
+func decodeUserInput(w http.ResponseWriter, r *http.Request) (name string, ok bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxUserBodyBytes)
+
+	var input userInput
+	if err := readUserInput(r, &input); err != nil {
+		respondBodyError(w, r, err)
+		return "", false
+	}
+
+	switch {
+	case input.Name == nil || *input.Name == "":
+		respondInvalidField(w, r, "name", "is required")
+		return "", false
+	case strings.TrimSpace(*input.Name) == "":
+		respondInvalidField(w, r, "name", "must not be blank")
+		return "", false
+	}
+	return *input.Name, true
+}
+
+// readUserInput decodes the body according to its media type
+func readUserInput(r *http.Request, input *userInput) error {
+	mediaType := "application/x-www-form-urlencoded"
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return errUnsupportedMediaType
+		}
+		mediaType = parsed
+	}
+
+	var form url.Values
+	switch mediaType {
+	case "application/json":
+		return decodeJSONBody(r.Body, input)
+	case "application/x-www-form-urlencoded":
+		// Parsed here because ParseForm ignores the body of a request
+		// without a Content-Type
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		if form, err = url.ParseQuery(string(body)); err != nil {
+			return err
+		}
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(MaxUserBodyBytes); err != nil {
+			return err
+		}
+		form = r.PostForm
+	default:
+		return errUnsupportedMediaType
+	}
+
+	if form.Has("name") {
+		name := form.Get("name")
+		input.Name = &name
+	}
+	return nil
+}
+
+// decodeJSONBody decodes exactly one JSON object, rejecting unknown fields
+func decodeJSONBody(body io.Reader, v any) error {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errEmptyBody
+		}
+		return err
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return errTrailingData
+	}
+	return nil
+}
+
+// respondBodyError maps a body decoding error to an error response
+func respondBodyError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesErr *http.MaxBytesError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &maxBytesErr):
+		respondError(w, r, http.StatusRequestEntityTooLarge, CodePayloadTooLarge,
+			fmt.Sprintf("Request body must not be larger than %d bytes", maxBytesErr.Limit))
+	case errors.Is(err, errUnsupportedMediaType):
+		w.Header().Set("Accept-Post", "application/json, application/x-www-form-urlencoded, multipart/form-data")
+		respondError(w, r, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType,
+			"Content-Type must be application/json, application/x-www-form-urlencoded or multipart/form-data")
+	case errors.As(err, &syntaxErr):
+		respondError(w, r, http.StatusBadRequest, CodeInvalidArgument,
+			fmt.Sprintf("Malformed JSON body at offset %d", syntaxErr.Offset))
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		respondError(w, r, http.StatusBadRequest, CodeInvalidArgument, "Malformed JSON body")
+	case errors.As(err, &typeErr):
+		respondInvalidField(w, r, typeErr.Field, "must be a "+typeErr.Type.String())
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		respondInvalidField(w, r, field, "is not a known field")
+	case errors.Is(err, errEmptyBody), errors.Is(err, errTrailingData):
+		respondError(w, r, http.StatusBadRequest, CodeInvalidArgument, capitalize(err.Error()))
+	default:
+		respondError(w, r, http.StatusBadRequest, CodeInvalidArgument, "Invalid form data")
+	}
+}
+
+// capitalize upper-cases the first letter of an error message
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// runCommand executes a command line subcommand and returns the process exit code
+func runCommand(db *sql.DB, logger *slog.Logger, dbType string, args []string) int {
+	switch args[0] {
+	case "migrate":
+		return runMigrate(db, logger, dbType, args[1:], os.Stdout)
+	case "export":
+		return runExport(db, logger, dbType, args[1:])
+	case "import":
+		return runImport(db, logger, dbType, args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		fmt.Fprintln(os.Stderr, "usage: user-svc [migrate up|down|status | export [flags] | import [flags]]")
+		return 2
+	}
+}
+
+// migrateUsage is printed when migrate is called without a valid action
+const migrateUsage = `usage: user-svc migrate up|down|status
+
+  up      apply all pending migrations
+  down    roll back the most recently applied migration
+  status  list migrations and whether they are applied
+
+On PostgreSQL, migrate up creates the unaccent and pg_trgm extensions for
+user search. That needs a superuser, or on PostgreSQL 13+ the CREATE
+privilege on the database. Without it, have an administrator run
+CREATE EXTENSION unaccent; CREATE EXTENSION pg_trgm; in the database first.
+`
+
+// runMigrate handles `user-svc migrate up|down|status`
+func runMigrate(db *sql.DB, logger *slog.Logger, dbType string, args []string, out io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+	if db == nil {
+		fmt.Fprintf(os.Stderr, "DB_TYPE=%s has no schema to migrate\n", dbType)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	dialect, err := NewDialect(dbType)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	migrator := NewMigrator(db, logger, dialect)
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			fmt.Fprintf(out, "applied %d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate up failed: %v\n", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Fprintln(out, "schema is up to date")
+		}
+	case "down":
+		migration, rolledBack, err := migrator.Down(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate down failed: %v\n", err)
+			return 1
+		}
+		if !rolledBack {
+			fmt.Fprintln(out, "no migrations to roll back")
+			return 0
+		}
+		fmt.Fprintf(out, "rolled back %d_%s\n", migration.Version, migration.Name)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate status failed: %v\n", err)
+			return 1
+		}
+
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, status := range statuses {
+			state, appliedAt := "pending", "-"
+			if status.Applied {
+				state = "applied"
+				appliedAt = time.UnixMicro(status.AppliedAt).UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", status.Version, status.Name, state, appliedAt)
+		}
+		tw.Flush()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown migrate action %q\n", args[0])
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	return 0
+}
+
+// runExport handles `user-svc export`, which writes all users as CSV or NDJSON
+func runExport(db *sql.DB, logger *slog.Logger, dbType string, args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "", "output format, csv or ndjson (default: from the --output extension, else csv)")
+	output := flags.String("output", "-", "file to write, or - for stdout")
+	includeDeleted := flags.Bool("include-deleted", true, "also export soft-deleted users")
+	pageSize := flags.Int("page-size", 1000, "users read per query")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 || *pageSize < 1 {
+		fmt.Fprintln(os.Stderr, "usage: user-svc export [--format csv|ndjson] [--output FILE] [--include-deleted=false] [--page-size N]")
+		return 2
+	}
+
+	if *format == "" {
+		*format = formatFromPath(*output)
+		if *format == "" {
+			*format = formatCSV
+		}
+	}
+
+	repo, code := openTransferRepository(db, logger, dbType, "export")
+	if repo == nil {
+		return code
+	}
+	defer repo.Close()
+
+	out := io.Writer(os.Stdout)
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "export failed: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	written, err := exportUsers(ctx, repo, out, *format, *includeDeleted, *pageSize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export failed after %d users: %v\n", written, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "exported %d users\n", written)
+	return 0
+}
+
+// runImport handles `user-svc import`, which loads users from CSV or NDJSON
+func runImport(db *sql.DB, logger *slog.Logger, dbType string, args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "", "input format, csv or ndjson (default: from the --input extension)")
+	input := flags.String("input", "-", "file to read, or - for stdin")
+	batchSize := flags.Int("batch-size", 500, "users stored per transaction")
+	preserveIDs := flags.Bool("preserve-ids", false, "keep the ids from the input instead of assigning new ones")
+	preserveTimestamps := flags.Bool("preserve-timestamps", false, "keep created_at, updated_at and deleted_at from the input")
+	rejectsPath := flags.String("rejects", "", "file for rejected rows (default: <input>.rejected.<format>)")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 || *batchSize < 1 {
+		fmt.Fprintln(os.Stderr, "usage: user-svc import [--format csv|ndjson] [--input FILE] [--batch-size N] [--preserve-ids] [--preserve-timestamps] [--rejects FILE]")
+		return 2
+	}
+
+	if *format == "" {
+		*format = formatFromPath(*input)
+		if *format == "" {
+			fmt.Fprintln(os.Stderr, "--format is required when it can't be told from the --input extension")
+			return 2
+		}
+	}
+	if *rejectsPath == "" {
+		*rejectsPath = rejectsPathFor(*input, *format)
+	}
+
+	repo, code := openTransferRepository(db, logger, dbType, "import")
+	if repo == nil {
+		return code
+	}
+	defer repo.Close()
+
+	in := io.Reader(os.Stdin)
+	if *input != "-" {
+		file, err := os.Open(*input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "import failed: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		in = file
+	}
+
+	var rejectsFile *os.File
+	rejects := newRejectWriter(*format, func() (io.Writer, error) {
+		file, err := os.Create(*rejectsPath)
+		rejectsFile = file
+		return file, err
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stats, err := importUsers(ctx, repo, in, rejects, ImportOptions{
+		Format:             *format,
+		BatchSize:          *batchSize,
+		PreserveIDs:        *preserveIDs,
+		PreserveTimestamps: *preserveTimestamps,
+		Progress: func(stats ImportStats) {
+			fmt.Fprintf(os.Stderr, "read %d rows: %d imported, %d rejected\n", stats.Read, stats.Imported, stats.Rejected)
+		},
+	})
+	if closeErr := rejects.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if rejectsFile != nil {
+		if closeErr := rejectsFile.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	if stats.Rejected > 0 {
+		fmt.Fprintf(os.Stderr, "rejected rows written to %s\n", *rejectsPath)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import failed after %d imported users: %v\n", stats.Imported, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "imported %d of %d users, %d rejected\n", stats.Imported, stats.Read, stats.Rejected)
+	return 0
+}
+
+// openTransferRepository returns the repository export and import work
+// through, or nil and the exit code if the database can't be used
+func openTransferRepository(db *sql.DB, logger *slog.Logger, dbType, command string) (*UserRepository, int) {
+	if db == nil {
+		fmt.Fprintf(os.Stderr, "DB_TYPE=%s keeps no data to %s\n", dbType, command)
+		return nil, 2
+	}
+
+	dialect, err := NewDialect(dbType)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, 2
+	}
+
+	// Moving data in or out of a half-migrated schema would lose columns
+	if err := NewMigrator(db, logger, dialect).CheckCurrent(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "database schema is not up to date, run `user-svc migrate up`: %v\n", err)
+		return nil, 1
+	}
+
+	return NewUserRepository(db, logger, dialect, getQueryTimeouts()), 0
+}
+
+// formatFromPath infers the transfer format from a file extension, or returns ""
+func formatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return formatCSV
+	case ".ndjson", ".jsonl":
+		return formatNDJSON
+	default:
+		return ""
+	}
+}
+
+// rejectsPathFor returns the default rejected rows file: users.csv gives
+// users.rejected.csv, and stdin gives rejected.csv in the working directory
+func rejectsPathFor(input, format string) string {
+	if input == "-" {
+		return "rejected." + format
+	}
+	return strings.TrimSuffix(input, filepath.Ext(input)) + ".rejected." + format
+}
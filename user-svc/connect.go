@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+)
+
+// Backend identifies the store the service is running against
+type Backend struct {
+	// Type is the DB_TYPE in use: postgres, sqlite or memory
+	Type string
+	// Fallback is set when Type is the DB_FALLBACK store rather than the configured one
+	Fallback bool
+}
+
+// RetryPolicy controls how long startup keeps trying to reach the database.
+// The wait between attempts doubles from InitialInterval up to MaxInterval,
+// with jitter, until Timeout has passed since the first attempt.
+type RetryPolicy struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Timeout         time.Duration
+}
+
+// DefaultRetryPolicy returns the policy used when none is configured
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		InitialInterval: 500 * time.Millisecond,
+		MaxInterval:     5 * time.Second,
+		Timeout:         30 * time.Second,
+	}
+}
+
+// getRetryPolicy reads the startup retry policy from environment variables
+func getRetryPolicy() RetryPolicy {
+	defaults := DefaultRetryPolicy()
+
+	return RetryPolicy{
+		InitialInterval: getEnvDuration("DB_CONNECT_RETRY_INITIAL", defaults.InitialInterval),
+		MaxInterval:     getEnvDuration("DB_CONNECT_RETRY_MAX", defaults.MaxInterval),
+		Timeout:         getEnvDuration("DB_CONNECT_TIMEOUT", defaults.Timeout),
+	}
+}
+
+// delay returns the wait before the given retry (1 for the first), between
+// half and all of the capped exponential interval so that replicas starting
+// together don't retry in lockstep
+func (p RetryPolicy) delay(retry int) time.Duration {
+	interval := p.InitialInterval
+	for i := 1; i < retry && interval < p.MaxInterval; i++ {
+		interval *= 2
+	}
+	interval = min(interval, p.MaxInterval)
+
+	half := interval / 2
+	return half + rand.N(interval-half+1)
+}
+
+// pinger is implemented by *sql.DB
+type pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// pingWithRetry pings the database until it answers, the policy's timeout
+// passes or ctx is cancelled. The last ping error is returned on failure.
+func pingWithRetry(ctx context.Context, db pinger, policy RetryPolicy, logger *slog.Logger) error {
+	ctx, cancel := context.WithTimeout(ctx, policy.Timeout)
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil {
+			if attempt > 1 {
+				logger.Info("Database is reachable", "attempts", attempt)
+			}
+			return nil
+		}
+
+		delay := policy.delay(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return fmt.Errorf("database unreachable after %d attempts within DB_CONNECT_TIMEOUT=%s: %w", attempt, policy.Timeout, err)
+		}
+		logger.Warn("Database is not reachable yet, retrying", "error", err, "attempt", attempt, "retry_in", delay.Round(time.Millisecond).String())
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return fmt.Errorf("gave up connecting to the database: %w", ctx.Err())
+			}
+			return fmt.Errorf("database unreachable after %d attempts within DB_CONNECT_TIMEOUT=%s: %w", attempt, policy.Timeout, err)
+		case <-timer.C:
+		}
+	}
+}
+
+// getFallback reads DB_FALLBACK, the store to use when PostgreSQL can't be
+// reached at startup. Falling back is off unless explicitly requested, since
+// a service that quietly writes to a local file loses data in production.
+func getFallback() (string, error) {
+	switch fallback := getEnv("DB_FALLBACK", "none"); fallback {
+	case "none", "sqlite":
+		return fallback, nil
+	default:
+		return "", fmt.Errorf("invalid DB_FALLBACK %q, expected none or sqlite", fallback)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakePinger fails the first `failures` pings, then succeeds
+type fakePinger struct {
+	failures int
+	calls    int
+}
+
+func (p *fakePinger) PingContext(ctx context.Context) error {
+	p.calls++
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if p.calls <= p.failures {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{InitialInterval: 100 * time.Millisecond, MaxInterval: time.Second, Timeout: time.Minute}
+
+	tests := []struct {
+		retry    int
+		interval time.Duration
+	}{
+		{retry: 1, interval: 100 * time.Millisecond},
+		{retry: 2, interval: 200 * time.Millisecond},
+		{retry: 3, interval: 400 * time.Millisecond},
+		{retry: 4, interval: 800 * time.Millisecond},
+		{retry: 5, interval: time.Second},
+		{retry: 50, interval: time.Second},
+	}
+
+	for _, tc := range tests {
+		for i := 0; i < 100; i++ {
+			delay := policy.delay(tc.retry)
+			if delay < tc.interval/2 || delay > tc.interval {
+				t.Fatalf("Retry %d: expected delay in [%s, %s], got %s", tc.retry, tc.interval/2, tc.interval, delay)
+			}
+		}
+	}
+}
+
+func TestPingWithRetry(t *testing.T) {
+	policy := RetryPolicy{InitialInterval: time.Millisecond, MaxInterval: 4 * time.Millisecond, Timeout: time.Second}
+
+	t.Run("succeeds once the database answers", func(t *testing.T) {
+		db := &fakePinger{failures: 3}
+		if err := pingWithRetry(context.Background(), db, policy, discardLogger()); err != nil {
+			t.Fatalf("Expected success, got %v", err)
+		}
+		if db.calls != 4 {
+			t.Errorf("Expected 4 pings, got %d", db.calls)
+		}
+	})
+
+	t.Run("gives up at the deadline", func(t *testing.T) {
+		db := &fakePinger{failures: 1 << 30}
+		policy := RetryPolicy{InitialInterval: time.Millisecond, MaxInterval: 4 * time.Millisecond, Timeout: 50 * time.Millisecond}
+
+		start := time.Now()
+		err := pingWithRetry(context.Background(), db, policy, discardLogger())
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected to give up after about %s, took %s", policy.Timeout, elapsed)
+		}
+		if db.calls < 2 {
+			t.Errorf("Expected several attempts, got %d", db.calls)
+		}
+	})
+
+	t.Run("stops when cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := pingWithRetry(ctx, &fakePinger{}, policy, discardLogger())
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	})
+}
+
+func TestGetFallback(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "", want: "none"},
+		{value: "none", want: "none"},
+		{value: "sqlite", want: "sqlite"},
+		{value: "postgres", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Setenv("DB_FALLBACK", tc.value)
+		got, err := getFallback()
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("DB_FALLBACK=%q: expected (%q, error %v), got (%q, %v)", tc.value, tc.want, tc.wantErr, got, err)
+		}
+	}
+}
+
+func TestReadinessReportsBackend(t *testing.T) {
+	db := newTestSQLiteDB(t)
+	dialect := &sqliteDialect{}
+	migrateTestDB(t, db, dialect)
+	migrator := NewMigrator(db, discardLogger(), dialect)
+
+	tests := []struct {
+		name    string
+		handler *HealthHandler
+		want    map[string]any
+	}{
+		{name: "memory", handler: NewHealthHandler(nil, Backend{Type: "memory"}, nil, time.Second),
+			want: map[string]any{"backend": "memory"}},
+		{name: "configured sqlite", handler: NewHealthHandler(db, Backend{Type: "sqlite"}, migrator, time.Second),
+			want: map[string]any{"backend": "sqlite"}},
+		{name: "sqlite fallback", handler: NewHealthHandler(db, Backend{Type: "sqlite", Fallback: true}, migrator, time.Second),
+			want: map[string]any{"backend": "sqlite", "fallback": true}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tc.handler.Readiness(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+			}
+
+			var response HealthResponse
+			if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			details := response.Checks["database"].Details
+			if len(details) != len(tc.want) {
+				t.Fatalf("Expected details %v, got %v", tc.want, details)
+			}
+			for key, value := range tc.want {
+				if details[key] != value {
+					t.Errorf("Expected %s=%v, got %v", key, value, details[key])
+				}
+			}
+		})
+	}
+}
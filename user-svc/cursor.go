@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor can't be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// UserCursor marks a position in the (created_at DESC, id DESC) ordering of users.
+// Listing after a cursor returns the users that sort strictly after it.
+type UserCursor struct {
+	CreatedAt int64
+	ID        int
+}
+
+// cursorFor returns the cursor pointing at the given user
+func cursorFor(user User) UserCursor {
+	return UserCursor{CreatedAt: user.CreatedAt, ID: user.ID}
+}
+
+// Encode returns the opaque string form of the cursor handed out to clients
+func (c UserCursor) Encode() string {
+	raw := fmt.Sprintf("%d:%d", c.CreatedAt, c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses a cursor produced by UserCursor.Encode
+func DecodeCursor(value string) (UserCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return UserCursor{}, ErrInvalidCursor
+	}
+
+	createdAtStr, idStr, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return UserCursor{}, ErrInvalidCursor
+	}
+
+	createdAt, err := strconv.ParseInt(createdAtStr, 10, 64)
+	if err != nil {
+		return UserCursor{}, ErrInvalidCursor
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id <= 0 {
+		return UserCursor{}, ErrInvalidCursor
+	}
+
+	return UserCursor{CreatedAt: createdAt, ID: id}, nil
+}
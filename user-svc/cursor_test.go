@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestUserCursorRoundTrip(t *testing.T) {
+	cursor := UserCursor{CreatedAt: 1475820997000000, ID: 42}
+
+	decoded, err := DecodeCursor(cursor.Encode())
+	if err != nil {
+		t.Fatalf("Failed to decode cursor: %v", err)
+	}
+	if decoded != cursor {
+		t.Errorf("Expected cursor %v, got %v", cursor, decoded)
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+
+	for _, value := range []string{
+		"not base64!",
+		encode("1475820997000000"),
+		encode("abc:1"),
+		encode("1475820997000000:abc"),
+		encode("1475820997000000:0"),
+	} {
+		if _, err := DecodeCursor(value); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Expected ErrInvalidCursor for %q, got %v", value, err)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Dialect hides the SQL differences between the supported databases, so
+// repository queries are written once using ? placeholders
+type Dialect interface {
+	// Name is the DB_TYPE the dialect serves, which also keys migration scripts
+	Name() string
+	// Rebind rewrites the ? placeholders of a query into the dialect's syntax
+	Rebind(query string) string
+	// SupportsReturning reports whether INSERT ... RETURNING can be used.
+	// Without it the inserted row is read back by its last insert ID.
+	SupportsReturning() bool
+	// Upsert builds an INSERT of columns that updates updateColumns instead
+	// when a row with the same conflictColumns already exists
+	Upsert(table string, columns, conflictColumns, updateColumns []string) string
+	// ColumnType maps a portable column type to the dialect's own type
+	ColumnType(columnType ColumnType) string
+	// SyncIDSequence returns the statement that moves the ID sequence of a
+	// table past rows inserted with explicit IDs, or "" if none is needed
+	SyncIDSequence(table, column string) string
+	// TableExistsQuery returns a query that selects the number of tables
+	// named by its single ? argument, i.e. 1 if the table exists, else 0
+	TableExistsQuery() string
+	// SearchUsersQuery builds the full-text search over users for the given
+	// terms. The query selects userColumns and ends with LIMIT ? OFFSET ?,
+	// whose arguments the caller appends to args.
+	SearchUsersQuery(ctx context.Context, db *sql.DB, terms []string, includeDeleted bool) (query string, args []any, err error)
+}
+
+// ColumnType is a column type that each dialect maps to its own type name
+type ColumnType int
+
+// Portable column types
+const (
+	ColumnID     ColumnType = iota // auto-incrementing integer primary key
+	ColumnBigInt                   // 64-bit integer, e.g. a microsecond timestamp
+	ColumnText
+	ColumnBlob
+	ColumnBool
+)
+
+// NewDialect returns the dialect for a DB_TYPE. A dialect may cache facts about
+// the database it is used with, so each database needs its own instance.
+func NewDialect(dbType string) (Dialect, error) {
+	switch dbType {
+	case "sqlite":
+		return &sqliteDialect{}, nil
+	case "postgres":
+		return postgresDialect{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported database type %q", dbType)
+	}
+}
+
+// sqliteDialect is the dialect for SQLite, which uses ? placeholders natively
+type sqliteDialect struct {
+	// ftsModule caches whether the search index uses fts5 or fts4
+	ftsMu     sync.Mutex
+	ftsModule string
+}
+
+func (d *sqliteDialect) Name() string { return "sqlite" }
+
+func (d *sqliteDialect) Rebind(query string) string { return query }
+
+// SupportsReturning is false so the service also works when linked against a
+// system SQLite older than 3.35
+func (d *sqliteDialect) SupportsReturning() bool { return false }
+
+func (d *sqliteDialect) Upsert(table string, columns, conflictColumns, updateColumns []string) string {
+	return onConflictUpsert(table, columns, conflictColumns, updateColumns)
+}
+
+// SyncIDSequence needs no statement, since AUTOINCREMENT always continues
+// after the largest ID ever stored
+func (d *sqliteDialect) SyncIDSequence(table, column string) string { return "" }
+
+func (d *sqliteDialect) TableExistsQuery() string {
+	return `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`
+}
+
+func (d *sqliteDialect) ColumnType(columnType ColumnType) string {
+	switch columnType {
+	case ColumnID:
+		return "INTEGER PRIMARY KEY AUTOINCREMENT"
+	case ColumnBigInt, ColumnBool:
+		return "INTEGER"
+	case ColumnBlob:
+		return "BLOB"
+	default:
+		return "TEXT"
+	}
+}
+
+// SearchUsersQuery matches every term as a prefix against the users_fts index.
+// FTS4 has no built-in ranking, so shorter (closer) names rank first.
+func (d *sqliteDialect) SearchUsersQuery(ctx context.Context, db *sql.DB, terms []string, includeDeleted bool) (string, []any, error) {
+	module, err := d.ftsModuleOf(ctx, db)
+	if err != nil {
+		return "", nil, err
+	}
+
+	score := "0"
+	if module == "fts5" {
+		score = "bm25(users_fts)"
+	}
+
+	phrases := make([]string, len(terms))
+	for i, term := range terms {
+		if module == "fts5" {
+			phrases[i] = `"` + term + `"*`
+		} else {
+			phrases[i] = `"` + term + `*"`
+		}
+	}
+
+	return `
+		SELECT ` + userColumns + `
+		FROM users
+		JOIN (
+			SELECT rowid AS match_id, ` + score + ` AS score
+			FROM users_fts
+			WHERE users_fts MATCH ?
+		) matches ON matches.match_id = users.id
+		` + activeUsersFilter("WHERE", includeDeleted) + `
+		ORDER BY matches.score, length(users.name), users.created_at DESC, users.id DESC
+		LIMIT ? OFFSET ?
+	`, []any{strings.Join(phrases, " ")}, nil
+}
+
+// ftsModuleOf returns the module ("fts5" or "fts4") the users_fts index was created with
+func (d *sqliteDialect) ftsModuleOf(ctx context.Context, db *sql.DB) (string, error) {
+	d.ftsMu.Lock()
+	defer d.ftsMu.Unlock()
+
+	if d.ftsModule != "" {
+		return d.ftsModule, nil
+	}
+
+	var ddl string
+	err := db.QueryRowContext(ctx, `SELECT sql FROM sqlite_master WHERE name = 'users_fts'`).Scan(&ddl)
+	if err != nil {
+		return "", err
+	}
+
+	d.ftsModule = "fts4"
+	if strings.Contains(strings.ToLower(ddl), "fts5") {
+		d.ftsModule = "fts5"
+	}
+	return d.ftsModule, nil
+}
+
+// postgresDialect is the dialect for PostgreSQL, which numbers its placeholders
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string { return "postgres" }
+
+func (postgresDialect) Rebind(query string) string { return rebindNumbered(query) }
+
+func (postgresDialect) SupportsReturning() bool { return true }
+
+func (postgresDialect) Upsert(table string, columns, conflictColumns, updateColumns []string) string {
+	return onConflictUpsert(table, columns, conflictColumns, updateColumns)
+}
+
+func (postgresDialect) SyncIDSequence(table, column string) string {
+	return fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%s', '%s'), (SELECT MAX(%s) FROM %s))", table, column, column, table)
+}
+
+func (postgresDialect) TableExistsQuery() string {
+	return `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?`
+}
+
+func (postgresDialect) ColumnType(columnType ColumnType) string {
+	switch columnType {
+	case ColumnID:
+		return "SERIAL PRIMARY KEY"
+	case ColumnBigInt:
+		return "BIGINT"
+	case ColumnBlob:
+		return "BYTEA"
+	case ColumnBool:
+		return "BOOLEAN"
+	default:
+		return "TEXT"
+	}
+}
+
+// SearchUsersQuery matches every term as a prefix against the search_vector
+// column, while trigram similarity adds typo tolerance
+func (postgresDialect) SearchUsersQuery(ctx context.Context, db *sql.DB, terms []string, includeDeleted bool) (string, []any, error) {
+	prefixes := make([]string, len(terms))
+	for i, term := range terms {
+		prefixes[i] = term + ":*"
+	}
+	phrase := strings.Join(terms, " ")
+
+	return `
+		SELECT ` + userColumns + `
+		FROM users, to_tsquery('simple', users_unaccent(?)) AS query
+		WHERE (users.search_vector @@ query OR users_unaccent(lower(users.name)) % users_unaccent(lower(?)))
+		` + activeUsersFilter("AND", includeDeleted) + `
+		ORDER BY ts_rank(users.search_vector, query) + similarity(users_unaccent(lower(users.name)), users_unaccent(lower(?))) DESC,
+			users.created_at DESC, users.id DESC
+		LIMIT ? OFFSET ?
+	`, []any{strings.Join(prefixes, " & "), phrase, phrase}, nil
+}
+
+// activeUsersFilter returns the condition excluding soft-deleted users,
+// introduced by keyword, or nothing when deleted users are included
+func activeUsersFilter(keyword string, includeDeleted bool) string {
+	if includeDeleted {
+		return ""
+	}
+	return keyword + " users.deleted_at IS NULL"
+}
+
+// onConflictUpsert builds the INSERT ... ON CONFLICT DO UPDATE form shared by
+// SQLite and PostgreSQL
+func onConflictUpsert(table string, columns, conflictColumns, updateColumns []string) string {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+
+	action := "DO NOTHING"
+	if len(updateColumns) > 0 {
+		assignments := make([]string, len(updateColumns))
+		for i, column := range updateColumns {
+			assignments[i] = column + " = excluded." + column
+		}
+		action = "DO UPDATE SET " + strings.Join(assignments, ", ")
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) %s",
+		table, strings.Join(columns, ", "), placeholders, strings.Join(conflictColumns, ", "), action)
+}
+
+// rebindNumbered replaces ? placeholders with $1, $2, ... Question marks
+// inside quoted strings, quoted identifiers and comments are left alone.
+func rebindNumbered(query string) string {
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+
+	n := 0
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+2])
+			i += end + 1
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end])
+			i += end - 1
+		case c == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// stmtCache prepares each distinct query once and reuses the statement.
+// Queries are given with ? placeholders and rebound for the dialect.
+type stmtCache struct {
+	db      *sql.DB
+	dialect Dialect
+
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+// newStmtCache creates an empty stmtCache
+func newStmtCache(db *sql.DB, dialect Dialect) *stmtCache {
+	return &stmtCache{
+		db:      db,
+		dialect: dialect,
+		stmts:   make(map[string]*sql.Stmt),
+	}
+}
+
+// prepare returns the cached statement for query, preparing it on first use
+func (c *stmtCache) prepare(ctx context.Context, query string) (*sql.Stmt, error) {
+	c.mu.Lock()
+	stmt, ok := c.stmts[query]
+	c.mu.Unlock()
+	if ok {
+		return stmt, nil
+	}
+
+	// Prepare without holding the lock; if another caller won the race, keep theirs
+	stmt, err := c.db.PrepareContext(ctx, c.dialect.Rebind(query))
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.stmts[query]; ok {
+		stmt.Close()
+		return existing, nil
+	}
+	c.stmts[query] = stmt
+	return stmt, nil
+}
+
+// query runs a cached query that returns rows
+func (c *stmtCache) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	stmt, err := c.prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.QueryContext(ctx, args...)
+}
+
+// queryUser runs a cached query that selects a single row of userColumns
+func (c *stmtCache) queryUser(ctx context.Context, query string, args ...any) (User, error) {
+	stmt, err := c.prepare(ctx, query)
+	if err != nil {
+		return User{}, err
+	}
+	return scanUser(stmt.QueryRowContext(ctx, args...))
+}
+
+// exec runs a cached statement that returns no rows
+func (c *stmtCache) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	stmt, err := c.prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.ExecContext(ctx, args...)
+}
+
+// Close closes every cached statement
+func (c *stmtCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var firstErr error
+	for query, stmt := range c.stmts {
+		if err := stmt.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(c.stmts, query)
+	}
+	return firstErr
+}
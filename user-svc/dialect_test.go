@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestNewDialect(t *testing.T) {
+	for _, name := range []string{"sqlite", "postgres"} {
+		dialect, err := NewDialect(name)
+		if err != nil {
+			t.Fatalf("NewDialect(%q) failed: %v", name, err)
+		}
+		if dialect.Name() != name {
+			t.Errorf("Expected dialect %q, got %q", name, dialect.Name())
+		}
+	}
+
+	if _, err := NewDialect("mysql"); err == nil {
+		t.Error("Expected an error for an unsupported database type")
+	}
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{name: "no placeholders", query: `SELECT 1`, want: `SELECT 1`},
+		{name: "numbered in order", query: `SELECT * FROM users WHERE id = ? AND name = ? LIMIT ?`,
+			want: `SELECT * FROM users WHERE id = $1 AND name = $2 LIMIT $3`},
+		{name: "string literal", query: `SELECT '?', name FROM users WHERE id = ?`,
+			want: `SELECT '?', name FROM users WHERE id = $1`},
+		{name: "escaped quote in literal", query: `SELECT 'it''s ?' WHERE a = ?`,
+			want: `SELECT 'it''s ?' WHERE a = $1`},
+		{name: "quoted identifier", query: `SELECT "what?" FROM t WHERE a = ?`,
+			want: `SELECT "what?" FROM t WHERE a = $1`},
+		{name: "comment", query: "SELECT a -- really?\nFROM t WHERE a = ?",
+			want: "SELECT a -- really?\nFROM t WHERE a = $1"},
+		{name: "unterminated literal", query: `SELECT ? WHERE a = '?`, want: `SELECT $1 WHERE a = '?`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := (postgresDialect{}).Rebind(tc.query); got != tc.want {
+				t.Errorf("Rebind(%q)\ngot:  %q\nwant: %q", tc.query, got, tc.want)
+			}
+			if got := (&sqliteDialect{}).Rebind(tc.query); got != tc.query {
+				t.Errorf("SQLite should keep ? placeholders, got %q", got)
+			}
+		})
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	got := (postgresDialect{}).Upsert("settings", []string{"key", "value", "updated_at"}, []string{"key"}, []string{"value", "updated_at"})
+	want := `INSERT INTO settings (key, value, updated_at) VALUES (?, ?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`
+	if got != want {
+		t.Errorf("Unexpected upsert\ngot:  %s\nwant: %s", got, want)
+	}
+
+	got = (&sqliteDialect{}).Upsert("settings", []string{"key", "value"}, []string{"key"}, nil)
+	want = `INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT (key) DO NOTHING`
+	if got != want {
+		t.Errorf("Unexpected insert-or-ignore\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestSQLiteDialectSchema(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLiteDB(t)
+	dialect := &sqliteDialect{}
+
+	// The mapped types and the upsert must be accepted by SQLite itself
+	_, err := db.ExecContext(ctx, `CREATE TABLE settings (
+		id `+dialect.ColumnType(ColumnID)+`,
+		key `+dialect.ColumnType(ColumnText)+` NOT NULL UNIQUE,
+		value `+dialect.ColumnType(ColumnBlob)+`,
+		enabled `+dialect.ColumnType(ColumnBool)+` NOT NULL,
+		updated_at `+dialect.ColumnType(ColumnBigInt)+` NOT NULL
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create table from mapped types: %v", err)
+	}
+
+	upsert := dialect.Rebind(dialect.Upsert("settings", []string{"key", "value", "enabled", "updated_at"},
+		[]string{"key"}, []string{"value", "updated_at"}))
+	for i, value := range []string{"first", "second"} {
+		if _, err := db.ExecContext(ctx, upsert, "theme", []byte(value), true, int64(i+1)); err != nil {
+			t.Fatalf("Upsert %d failed: %v", i, err)
+		}
+	}
+
+	var count int
+	var value []byte
+	var updatedAt int64
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*), MAX(value), MAX(updated_at) FROM settings`).Scan(&count, &value, &updatedAt); err != nil {
+		t.Fatalf("Failed to read settings: %v", err)
+	}
+	if count != 1 || string(value) != "second" || updatedAt != 2 {
+		t.Errorf("Expected one updated row, got count=%d value=%q updated_at=%d", count, value, updatedAt)
+	}
+}
+
+func TestStmtCache(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLiteDB(t)
+	cache := newStmtCache(db, &sqliteDialect{})
+	defer cache.Close()
+
+	if _, err := cache.exec(ctx, `CREATE TABLE counters (id INTEGER PRIMARY KEY, n INTEGER NOT NULL)`); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	// Concurrent first use of the same query must end up with one cached statement
+	const insert = `INSERT INTO counters (n) VALUES (?)`
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := cache.exec(ctx, insert, i); err != nil {
+				t.Errorf("Insert %d failed: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(cache.stmts) != 2 {
+		t.Errorf("Expected 2 cached statements, got %d", len(cache.stmts))
+	}
+
+	rows, err := cache.query(ctx, `SELECT COUNT(*) FROM counters WHERE n >= ?`, 0)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	defer rows.Close()
+	var count int
+	for rows.Next() {
+		if err := rows.Scan(&count); err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+	}
+	if count != 20 {
+		t.Errorf("Expected 20 rows, got %d", count)
+	}
+
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if len(cache.stmts) != 0 {
+		t.Errorf("Expected Close to empty the cache, got %d statements", len(cache.stmts))
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// Machine-readable error codes returned in ErrorResponse
+const (
+	CodeInvalidArgument      = "invalid_argument"
+	CodeNotFound             = "not_found"
+	CodeGone                 = "gone"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodePreconditionFailed   = "precondition_failed"
+	CodePreconditionRequired = "precondition_required"
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	CodeIdempotencyKeyInUse  = "idempotency_key_in_use"
+	CodePayloadTooLarge      = "payload_too_large"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeTimeout              = "timeout"
+	CodeInternal             = "internal"
+)
+
+// requestIDHeader carries the correlation ID between services
+const requestIDHeader = "X-Request-ID"
+
+// ErrorResponse is the response format for every error. Soft-deleted users
+// are still returned alongside a gone error.
+type ErrorResponse struct {
+	Result bool     `json:"result"`
+	Error  APIError `json:"error"`
+	User   *User    `json:"user,omitempty"`
+}
+
+// APIError describes what went wrong. Internal errors only carry a generic
+// message; the cause is logged under the correlation ID instead.
+type APIError struct {
+	Code          string       `json:"code"`
+	Message       string       `json:"message"`
+	Details       []FieldError `json:"details,omitempty"`
+	CorrelationID string       `json:"correlation_id"`
+}
+
+// FieldError points at the request parameter, field or header that was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// respondError sends an error response with the given status and code
+func respondError(w http.ResponseWriter, r *http.Request, status int, code, message string, details ...FieldError) {
+	writeError(w, r, status, ErrorResponse{
+		Error: APIError{Code: code, Message: message, Details: details},
+	})
+}
+
+// respondInvalidField sends a 400 for a single invalid parameter or field
+func respondInvalidField(w http.ResponseWriter, r *http.Request, field, message string) {
+	respondError(w, r, http.StatusBadRequest, CodeInvalidArgument, "Invalid request", FieldError{Field: field, Message: message})
+}
+
+// writeError fills in the correlation ID and sends the error response
+func writeError(w http.ResponseWriter, r *http.Request, status int, response ErrorResponse) {
+	response.Error.CorrelationID = correlationID(w, r)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// respondUserError maps errors from single-user operations to HTTP responses.
+// Missing users get 404 and soft-deleted users get 410, which includes deleted
+// if the operation returned the deleted record.
+func respondUserError(w http.ResponseWriter, r *http.Request, deleted *User, err error, action string) {
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		respondError(w, r, http.StatusNotFound, CodeNotFound, "User not found")
+	case errors.Is(err, ErrUserGone):
+		writeError(w, r, http.StatusGone, ErrorResponse{
+			Error: APIError{Code: CodeGone, Message: "User has been deleted"},
+			User:  deleted,
+		})
+	case errors.Is(err, ErrPreconditionFailed):
+		respondError(w, r, http.StatusPreconditionFailed, CodePreconditionFailed,
+			"User has been modified, fetch the latest version and retry")
+	case errors.Is(err, ErrInvalidArgument):
+		respondInvalidField(w, r, "id", "must be a positive integer")
+	default:
+		respondInternalError(w, r, err, "Failed to "+action+" user")
+	}
+}
+
+// respondInternalError reports an unexpected error. Requests abandoned by the
+// client are only logged, and operations that ran out of time get 504. The
+// underlying error never reaches the client; it is logged with the
+// correlation ID returned in the response.
+func respondInternalError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(r.Context().Err(), context.Canceled):
+		slog.Info("Request cancelled by client", "method", r.Method, "path", r.URL.Path)
+	case errors.Is(err, context.DeadlineExceeded):
+		respondError(w, r, http.StatusGatewayTimeout, CodeTimeout, message+": request timed out")
+	default:
+		slog.Error(message, "error", err, "method", r.Method, "path", r.URL.Path, "correlation_id", correlationID(w, r))
+		respondError(w, r, http.StatusInternalServerError, CodeInternal, message)
+	}
+}
+
+// correlationID returns the ID that ties an error response to its log lines.
+// A caller-supplied X-Request-ID is reused; otherwise a random ID is generated.
+// The ID is echoed in the X-Request-ID response header.
+func correlationID(w http.ResponseWriter, r *http.Request) string {
+	if id := w.Header().Get(requestIDHeader); id != "" {
+		return id
+	}
+
+	id := r.Header.Get(requestIDHeader)
+	if id == "" || len(id) > 128 {
+		b := make([]byte, 8)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+
+	w.Header().Set(requestIDHeader, id)
+	return id
+}
+
+// jsonRouteErrors replaces the plain-text 404 and 405 responses ServeMux
+// writes for unknown routes and methods with JSON error responses
+func jsonRouteErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler, pattern := mux.Handler(r)
+		if pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		// No route matched; let the mux decide between 404, 405 and a redirect
+		recorder := &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+		handler.ServeHTTP(recorder, r)
+
+		switch recorder.status {
+		case http.StatusNotFound:
+			respondError(w, r, http.StatusNotFound, CodeNotFound, "Route not found")
+		case http.StatusMethodNotAllowed:
+			w.Header().Set("Allow", recorder.header.Get("Allow"))
+			respondError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		default:
+			for key, values := range recorder.header {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(recorder.status)
+			w.Write(recorder.body.Bytes())
+		}
+	})
+}
+
+// bufferedResponse holds a response in memory until it is inspected
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 // UserHandler handles HTTP requests for user operations
@@ -19,8 +22,80 @@ func NewUserHandler(service *UserService) *UserHandler {
 	}
 }
 
-// GetAllUsers handles GET /users request
+// GetAllUsers handles GET /users request. When the ids parameter is given it
+// performs a batch lookup instead of listing users.
 func (h *UserHandler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("ids") {
+		h.getUsersByIDs(w, r)
+		return
+	}
+
+	pageNum, pageSize := parsePagination(r)
+	includeDeleted := parseIncludeDeleted(r)
+
+	// Get users, continuing from the cursor if one was given
+	var users []User
+	var err error
+	if cursorStr := r.URL.Query().Get("cursor"); cursorStr != "" {
+		cursor, cursorErr := DecodeCursor(cursorStr)
+		if cursorErr != nil {
+			respondInvalidField(w, r, "cursor", "must be a cursor returned in next_cursor")
+			return
+		}
+		users, err = h.service.GetUsersAfter(r.Context(), cursor, pageSize, includeDeleted)
+	} else {
+		users, err = h.service.GetAllUsers(r.Context(), pageNum, pageSize, includeDeleted)
+	}
+	if err != nil {
+		respondInternalError(w, r, err, "Failed to fetch users")
+		return
+	}
+
+	// Create response
+	response := UsersResponse{
+		Result: true,
+		Users:  users,
+	}
+
+	// A full page means there may be more users after the last one
+	if len(users) == pageSize {
+		response.NextCursor = cursorFor(users[len(users)-1]).Encode()
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// SearchUsers handles GET /users/search?q= request
+func (h *UserHandler) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	pageNum, pageSize := parsePagination(r)
+	includeDeleted := parseIncludeDeleted(r)
+
+	// Search users
+	users, err := h.service.SearchUsers(r.Context(), r.URL.Query().Get("q"), pageNum, pageSize, includeDeleted)
+	if err != nil {
+		if errors.Is(err, ErrRequiredField) {
+			respondInvalidField(w, r, "q", "is required")
+			return
+		}
+		respondInternalError(w, r, err, "Failed to search users")
+		return
+	}
+
+	// Create response
+	response := UsersResponse{
+		Result: true,
+		Users:  users,
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// parsePagination reads page_num and page_size, falling back to defaults for missing or invalid values
+func parsePagination(r *http.Request) (int, int) {
 	// Parse page parameters
 	pageNum := 1
 	pageSize := 10
@@ -39,17 +114,48 @@ func (h *UserHandler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	return pageNum, pageSize
+}
+
+// parseIncludeDeleted reads include_deleted; soft-deleted users are hidden unless explicitly requested
+func parseIncludeDeleted(r *http.Request) bool {
+	if includeDeletedStr := r.URL.Query().Get("include_deleted"); includeDeletedStr != "" {
+		if include, err := strconv.ParseBool(includeDeletedStr); err == nil {
+			return include
+		}
+	}
+	return false
+}
+
+// getUsersByIDs handles GET /users?ids=1,2,3 request
+func (h *UserHandler) getUsersByIDs(w http.ResponseWriter, r *http.Request) {
+	// Parse comma separated IDs
+	var ids []int
+	for _, idStr := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(idStr))
+		if err != nil {
+			respondInvalidField(w, r, "ids", "must be a comma separated list of integers")
+			return
+		}
+		ids = append(ids, id)
+	}
+
 	// Get users
-	users, err := h.service.GetAllUsers(pageNum, pageSize)
+	users, missing, err := h.service.GetUsersByIDs(r.Context(), ids)
 	if err != nil {
-		http.Error(w, "Failed to fetch users: "+err.Error(), http.StatusInternalServerError)
+		if errors.Is(err, ErrInvalidArgument) {
+			respondInvalidField(w, r, "ids", fmt.Sprintf("must contain between 1 and %d positive IDs", MaxBatchSize))
+			return
+		}
+		respondInternalError(w, r, err, "Failed to fetch users")
 		return
 	}
 
 	// Create response
-	response := UsersResponse{
-		Result: true,
-		Users:  users,
+	response := UsersBatchResponse{
+		Result:     true,
+		Users:      users,
+		MissingIDs: missing,
 	}
 
 	// Send response
@@ -62,27 +168,15 @@ func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		respondInvalidField(w, r, "id", "must be a positive integer")
 		return
 	}
 
 	// Get user
-	user, err := h.service.GetUserByID(id)
+	user, err := h.service.GetUserByID(r.Context(), id)
 	if err != nil {
-		if errors.Is(err, ErrUserNotFound) {
-			// http.Error(w, "User not found", http.StatusNotFound)
-			response := UserResponse{
-				Result: false,
-				User:   User{},
-			}
-
-			// Send response
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound)
-			json.NewEncoder(w).Encode(response)
-		} else {
-			http.Error(w, "Failed to fetch user: "+err.Error(), http.StatusInternalServerError)
-		}
+		// A deleted user comes back with ErrUserGone
+		respondUserError(w, r, &user, err, "fetch")
 		return
 	}
 
@@ -94,28 +188,76 @@ func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 
 	// Send response
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", userETag(user))
 	json.NewEncoder(w).Encode(response)
 }
 
-// CreateUser handles POST /users request
+// CreateUser handles POST /users request. The body may be JSON, URL-encoded or multipart.
 func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
-	// Parse form data
-	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+	// Read the name from the body, whatever its encoding
+	name, ok := decodeUserInput(w, r)
+	if !ok {
 		return
 	}
 
-	// Get name parameter
-	name := r.FormValue("name")
-	if name == "" {
-		http.Error(w, "Name is required", http.StatusBadRequest)
+	// Create user
+	user, err := h.service.CreateUser(r.Context(), name)
+	if err != nil {
+		respondInternalError(w, r, err, "Failed to create user")
 		return
 	}
 
-	// Create user
-	user, err := h.service.CreateUser(name)
+	// Create response
+	response := UserResponse{
+		Result: true,
+		User:   user,
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", userETag(user))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// UpdateUser handles PATCH /users/{id} request. The If-Match header must carry
+// the ETag from a previous read so concurrent edits are rejected with 412.
+func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Failed to create user: "+err.Error(), http.StatusInternalServerError)
+		respondInvalidField(w, r, "id", "must be a positive integer")
+		return
+	}
+
+	// Require a precondition so writers cannot blindly overwrite each other
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		respondError(w, r, http.StatusPreconditionRequired, CodePreconditionRequired, "If-Match header is required",
+			FieldError{Field: "If-Match", Message: "is required"})
+		return
+	}
+	ifUpdatedAt, err := parseETag(ifMatch)
+	if errors.Is(err, errWeakETag) {
+		respondError(w, r, http.StatusPreconditionFailed, CodePreconditionFailed,
+			"If-Match requires a strong ETag, a weak one never matches")
+		return
+	}
+	if err != nil {
+		respondInvalidField(w, r, "If-Match", "must be an ETag returned by this service")
+		return
+	}
+
+	// Read the name from the body, whatever its encoding
+	name, ok := decodeUserInput(w, r)
+	if !ok {
+		return
+	}
+
+	// Update user
+	user, err := h.service.UpdateUser(r.Context(), id, name, ifUpdatedAt)
+	if err != nil {
+		respondUserError(w, r, nil, err, "update")
 		return
 	}
 
@@ -127,6 +269,74 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 
 	// Send response
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+	w.Header().Set("ETag", userETag(user))
 	json.NewEncoder(w).Encode(response)
 }
+
+// DeleteUser handles DELETE /users/{id} request by soft-deleting the user
+func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	h.changeDeletionState(w, r, h.service.DeleteUser, "delete")
+}
+
+// RestoreUser handles POST /users/{id}/restore request by undoing a soft delete
+func (h *UserHandler) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	h.changeDeletionState(w, r, h.service.RestoreUser, "restore")
+}
+
+// changeDeletionState parses the user ID and applies a delete or restore operation
+func (h *UserHandler) changeDeletionState(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, id int) (User, error), action string) {
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		respondInvalidField(w, r, "id", "must be a positive integer")
+		return
+	}
+
+	user, err := change(r.Context(), id)
+	if err != nil {
+		respondUserError(w, r, nil, err, action)
+		return
+	}
+
+	// Create response
+	response := UserResponse{
+		Result: true,
+		User:   user,
+	}
+
+	// Send response
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", userETag(user))
+	json.NewEncoder(w).Encode(response)
+}
+
+// userETag returns the entity tag for a user, derived from its updated_at version
+func userETag(user User) string {
+	return fmt.Sprintf(`"%d"`, user.UpdatedAt)
+}
+
+// errWeakETag is returned by parseETag for a weak entity tag. If-Match uses
+// strong comparison, which a weak tag never passes (RFC 9110, section 13.1.1).
+var errWeakETag = errors.New("weak entity tag")
+
+// parseETag parses an If-Match value produced by userETag. The wildcard "*"
+// matches any version and is returned as 0.
+func parseETag(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "*" {
+		return 0, nil
+	}
+
+	if strings.HasPrefix(value, "W/") {
+		return 0, errWeakETag
+	}
+	if len(value) < 2 || !strings.HasPrefix(value, `"`) || !strings.HasSuffix(value, `"`) {
+		return 0, fmt.Errorf("malformed entity tag %q", value)
+	}
+
+	version, err := strconv.ParseInt(value[1:len(value)-1], 10, 64)
+	if err != nil || version <= 0 {
+		return 0, fmt.Errorf("malformed entity tag %q", value)
+	}
+	return version, nil
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// testRequestID is sent with every handler test request so correlation IDs are stable
+const testRequestID = "test-request-id"
+
+// handlerTest describes one request against the router and the expected response
+type handlerTest struct {
+	name    string
+	method  string
+	target  string
+	body    string
+	headers map[string]string
+
+	// repo builds the repository behind the handler; defaults to seededRepository
+	repo func(t *testing.T) UserRepositoryInterface
+
+	wantStatus  int
+	wantHeaders map[string]string
+
+	// golden is the file in testdata/handler holding the expected response body
+	golden string
+}
+
+// seededRepository returns an in-memory repository with fixed users: Alice,
+// Bob, and Carol who has been soft-deleted
+func seededRepository(t *testing.T) UserRepositoryInterface {
+	repo := NewMemoryUserRepository()
+	deletedAt := int64(3500)
+	for _, user := range []User{
+		{ID: 1, Name: "Alice Smith", CreatedAt: 1000, UpdatedAt: 1000},
+		{ID: 2, Name: "Bob Jones", CreatedAt: 2000, UpdatedAt: 2500},
+		{ID: 3, Name: "Carol Smith", CreatedAt: 3000, UpdatedAt: 3500, DeletedAt: &deletedAt},
+	} {
+		insertTestUser(t, repo, user)
+	}
+	return repo
+}
+
+// failingRepository returns a repository whose every method fails with err
+func failingRepository(err error) func(t *testing.T) UserRepositoryInterface {
+	return func(t *testing.T) UserRepositoryInterface {
+		return &MockUserRepository{
+			getUserByIDFn: func(id int) (User, error) { return User{}, err },
+			getByIDsFn:    func(ids []int) ([]User, error) { return nil, err },
+			searchFn: func(query string, pageNum, pageSize int, includeDeleted bool) ([]User, error) {
+				return nil, err
+			},
+			getAllUsersFn: func(pageNum, pageSize int, includeDeleted bool) ([]User, error) { return nil, err },
+			getAfterFn: func(cursor UserCursor, pageSize int, includeDeleted bool) ([]User, error) {
+				return nil, err
+			},
+			createUserFn:  func(name string) (User, error) { return User{}, err },
+			updateUserFn:  func(id int, name string, ifUpdatedAt int64) (User, error) { return User{}, err },
+			deleteUserFn:  func(id int) (User, error) { return User{}, err },
+			restoreUserFn: func(id int) (User, error) { return User{}, err },
+			importUsersFn: func(users []User, preserveIDs bool) (int, []int, error) { return 0, nil, err },
+		}
+	}
+}
+
+// fixedClockRepository wraps seededRepository so writes return fixed timestamps
+func fixedClockRepository(t *testing.T) UserRepositoryInterface {
+	seeded := seededRepository(t)
+	return &MockUserRepository{
+		getUserByIDFn: func(id int) (User, error) { return seeded.GetUserByID(context.Background(), id) },
+		createUserFn: func(name string) (User, error) {
+			return User{ID: 4, Name: name, CreatedAt: 4000, UpdatedAt: 4000}, nil
+		},
+		updateUserFn: func(id int, name string, ifUpdatedAt int64) (User, error) {
+			user, err := seeded.UpdateUser(context.Background(), id, name, ifUpdatedAt)
+			if err != nil {
+				return User{}, err
+			}
+			user.UpdatedAt = 5000
+			return user, nil
+		},
+		deleteUserFn: func(id int) (User, error) {
+			deletedAt := int64(6000)
+			return User{ID: id, Name: "Alice Smith", CreatedAt: 1000, UpdatedAt: 6000, DeletedAt: &deletedAt}, nil
+		},
+		restoreUserFn: func(id int) (User, error) {
+			return User{ID: id, Name: "Carol Smith", CreatedAt: 3000, UpdatedAt: 7000}, nil
+		},
+	}
+}
+
+// runHandlerTests sends each request through the router and checks the status,
+// headers and body
+func runHandlerTests(t *testing.T, tests []handlerTest) {
+	t.Helper()
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			newRepo := tc.repo
+			if newRepo == nil {
+				newRepo = seededRepository
+			}
+			router := newRouter(NewUserHandler(NewUserService(newRepo(t))), NewHealthHandler(nil, Backend{Type: "memory"}, nil, time.Second),
+				NewMemoryIdempotencyStore(testIdempotencyConfig))
+
+			req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			req.Header.Set(requestIDHeader, testRequestID)
+			for key, value := range tc.headers {
+				req.Header.Set(key, value)
+			}
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("Expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+			for key, value := range tc.wantHeaders {
+				if got := rec.Header().Get(key); got != value {
+					t.Errorf("Expected header %s: %q, got %q", key, value, got)
+				}
+			}
+			if tc.golden != "" {
+				assertGolden(t, filepath.Join("testdata", "handler", tc.golden), rec.Body.Bytes())
+			}
+		})
+	}
+}
+
+// assertGolden compares got with the contents of the golden file at path.
+// Run `go test -update` to rewrite golden files after an intended change.
+func assertGolden(t *testing.T, path string, got []byte) {
+	t.Helper()
+
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("Failed to create golden directory: %v", err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("Failed to write golden file: %v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read golden file (run `go test -update` to create it): %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("Response body doesn't match %s\ngot:  %s\nwant: %s", path, got, want)
+	}
+}
+
+func TestListUsersHandler(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{name: "first page", method: http.MethodGet, target: "/users",
+			wantStatus: http.StatusOK, wantHeaders: map[string]string{"Content-Type": "application/json"},
+			golden: "list_users.golden"},
+		{name: "full page has next cursor", method: http.MethodGet, target: "/users?page_size=1",
+			wantStatus: http.StatusOK, golden: "list_users_next_cursor.golden"},
+		{name: "include deleted", method: http.MethodGet, target: "/users?include_deleted=true",
+			wantStatus: http.StatusOK, golden: "list_users_include_deleted.golden"},
+		{name: "invalid page_num falls back to first page", method: http.MethodGet, target: "/users?page_num=abc",
+			wantStatus: http.StatusOK, golden: "list_users.golden"},
+		{name: "negative page_num falls back to first page", method: http.MethodGet, target: "/users?page_num=-3",
+			wantStatus: http.StatusOK, golden: "list_users.golden"},
+		{name: "invalid page_size falls back to default", method: http.MethodGet, target: "/users?page_size=0",
+			wantStatus: http.StatusOK, golden: "list_users.golden"},
+		{name: "page past the end", method: http.MethodGet, target: "/users?page_num=9",
+			wantStatus: http.StatusOK, golden: "list_users_empty.golden"},
+		{name: "after cursor", method: http.MethodGet, target: "/users?cursor=" + UserCursor{CreatedAt: 2000, ID: 2}.Encode(),
+			wantStatus: http.StatusOK, golden: "list_users_after_cursor.golden"},
+		{name: "invalid cursor", method: http.MethodGet, target: "/users?cursor=not-a-cursor",
+			wantStatus: http.StatusBadRequest, golden: "error_invalid_cursor.golden"},
+		{name: "database error is hidden", method: http.MethodGet, target: "/users",
+			repo:       failingRepository(errors.New("database disk image is malformed")),
+			wantStatus: http.StatusInternalServerError, wantHeaders: map[string]string{requestIDHeader: testRequestID},
+			golden: "error_internal_list.golden"},
+		{name: "timeout", method: http.MethodGet, target: "/users",
+			repo:       failingRepository(context.DeadlineExceeded),
+			wantStatus: http.StatusGatewayTimeout, golden: "error_timeout_list.golden"},
+	})
+}
+
+func TestBatchAndSearchUsersHandler(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{name: "batch lookup", method: http.MethodGet, target: "/users?ids=2,99,1",
+			wantStatus: http.StatusOK, golden: "batch_users.golden"},
+		{name: "batch with invalid id", method: http.MethodGet, target: "/users?ids=1,x",
+			wantStatus: http.StatusBadRequest, golden: "error_invalid_ids.golden"},
+		{name: "batch with non-positive id", method: http.MethodGet, target: "/users?ids=0",
+			wantStatus: http.StatusBadRequest, golden: "error_ids_out_of_range.golden"},
+		{name: "batch too large", method: http.MethodGet, target: "/users?ids=" + strings.Repeat("1,", MaxBatchSize) + "1",
+			wantStatus: http.StatusBadRequest, golden: "error_ids_out_of_range.golden"},
+		{name: "search", method: http.MethodGet, target: "/users/search?q=smith",
+			wantStatus: http.StatusOK, golden: "search_users.golden"},
+		{name: "search without query", method: http.MethodGet, target: "/users/search?q=%20",
+			wantStatus: http.StatusBadRequest, golden: "error_missing_query.golden"},
+	})
+}
+
+func TestGetUserHandler(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{name: "found", method: http.MethodGet, target: "/users/2",
+			wantStatus: http.StatusOK, wantHeaders: map[string]string{"ETag": `"2500"`},
+			golden: "get_user.golden"},
+		{name: "invalid id", method: http.MethodGet, target: "/users/abc",
+			wantStatus: http.StatusBadRequest, golden: "error_invalid_id.golden"},
+		{name: "non-positive id", method: http.MethodGet, target: "/users/0",
+			wantStatus: http.StatusBadRequest, golden: "error_invalid_id.golden"},
+		{name: "not found", method: http.MethodGet, target: "/users/99",
+			wantStatus: http.StatusNotFound, golden: "error_user_not_found.golden"},
+		{name: "deleted", method: http.MethodGet, target: "/users/3",
+			wantStatus: http.StatusGone, golden: "error_user_gone.golden"},
+		{name: "database error is hidden", method: http.MethodGet, target: "/users/1",
+			repo:       failingRepository(errors.New("pq: password authentication failed")),
+			wantStatus: http.StatusInternalServerError, golden: "error_internal_get.golden"},
+	})
+}
+
+func TestCreateUserHandler(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{name: "json", method: http.MethodPost, target: "/users", body: `{"name":"Dave"}`,
+			headers: map[string]string{"Content-Type": "application/json"}, repo: fixedClockRepository,
+			wantStatus: http.StatusCreated, wantHeaders: map[string]string{"ETag": `"4000"`},
+			golden: "create_user.golden"},
+		{name: "form", method: http.MethodPost, target: "/users", body: "name=Dave",
+			headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, repo: fixedClockRepository,
+			wantStatus: http.StatusCreated, golden: "create_user.golden"},
+		{name: "form without content type", method: http.MethodPost, target: "/users", body: "name=Dave",
+			repo: fixedClockRepository, wantStatus: http.StatusCreated, golden: "create_user.golden"},
+		{name: "multipart", method: http.MethodPost, target: "/users",
+			body:    "--b\r\nContent-Disposition: form-data; name=\"name\"\r\n\r\nDave\r\n--b--\r\n",
+			headers: map[string]string{"Content-Type": "multipart/form-data; boundary=b"}, repo: fixedClockRepository,
+			wantStatus: http.StatusCreated, golden: "create_user.golden"},
+		{name: "malformed json", method: http.MethodPost, target: "/users", body: `{"name":`,
+			headers:    map[string]string{"Content-Type": "application/json"},
+			wantStatus: http.StatusBadRequest, golden: "error_malformed_json.golden"},
+		{name: "invalid json syntax", method: http.MethodPost, target: "/users", body: `{name: "Dave"}`,
+			headers:    map[string]string{"Content-Type": "application/json"},
+			wantStatus: http.StatusBadRequest, golden: "error_json_syntax.golden"},
+		{name: "unknown field", method: http.MethodPost, target: "/users", body: `{"name":"Dave","admin":true}`,
+			headers:    map[string]string{"Content-Type": "application/json"},
+			wantStatus: http.StatusBadRequest, golden: "error_unknown_field.golden"},
+		{name: "wrong field type", method: http.MethodPost, target: "/users", body: `{"name":42}`,
+			headers:    map[string]string{"Content-Type": "application/json"},
+			wantStatus: http.StatusBadRequest, golden: "error_name_type.golden"},
+		{name: "multiple json values", method: http.MethodPost, target: "/users", body: `{"name":"Dave"} {}`,
+			headers:    map[string]string{"Content-Type": "application/json"},
+			wantStatus: http.StatusBadRequest, golden: "error_trailing_json.golden"},
+		{name: "empty json body", method: http.MethodPost, target: "/users",
+			headers:    map[string]string{"Content-Type": "application/json"},
+			wantStatus: http.StatusBadRequest, golden: "error_empty_body.golden"},
+		{name: "missing name in json", method: http.MethodPost, target: "/users", body: `{}`,
+			headers:    map[string]string{"Content-Type": "application/json"},
+			wantStatus: http.StatusBadRequest, golden: "error_name_required.golden"},
+		{name: "missing name in form", method: http.MethodPost, target: "/users", body: "other=x",
+			headers:    map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+			wantStatus: http.StatusBadRequest, golden: "error_name_required.golden"},
+		{name: "blank name", method: http.MethodPost, target: "/users", body: `{"name":"   "}`,
+			headers:    map[string]string{"Content-Type": "application/json"},
+			wantStatus: http.StatusBadRequest, golden: "error_name_blank.golden"},
+		{name: "unsupported media type", method: http.MethodPost, target: "/users", body: "Dave",
+			headers:    map[string]string{"Content-Type": "text/plain"},
+			wantStatus: http.StatusUnsupportedMediaType, golden: "error_unsupported_media_type.golden"},
+		{name: "body too large", method: http.MethodPost, target: "/users",
+			body:       `{"name":"` + strings.Repeat("a", MaxUserBodyBytes) + `"}`,
+			headers:    map[string]string{"Content-Type": "application/json"},
+			wantStatus: http.StatusRequestEntityTooLarge, golden: "error_body_too_large.golden"},
+		{name: "database error is hidden", method: http.MethodPost, target: "/users", body: "name=Dave",
+			headers:    map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+			repo:       failingRepository(errors.New("UNIQUE constraint failed: users.id")),
+			wantStatus: http.StatusInternalServerError, golden: "error_internal_create.golden"},
+	})
+}
+
+func TestUpdateUserHandler(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{name: "updated", method: http.MethodPatch, target: "/users/2", body: `{"name":"Robert Jones"}`,
+			headers: map[string]string{"Content-Type": "application/json", "If-Match": `"2500"`}, repo: fixedClockRepository,
+			wantStatus: http.StatusOK, wantHeaders: map[string]string{"ETag": `"5000"`},
+			golden: "update_user.golden"},
+		{name: "missing If-Match", method: http.MethodPatch, target: "/users/2", body: "name=Robert",
+			wantStatus: http.StatusPreconditionRequired, golden: "error_if_match_required.golden"},
+		{name: "malformed If-Match", method: http.MethodPatch, target: "/users/2", body: "name=Robert",
+			headers:    map[string]string{"If-Match": "2500"},
+			wantStatus: http.StatusBadRequest, golden: "error_invalid_if_match.golden"},
+		{name: "weak If-Match", method: http.MethodPatch, target: "/users/2", body: "name=Robert",
+			headers:    map[string]string{"If-Match": `W/"2500"`},
+			wantStatus: http.StatusPreconditionFailed, golden: "error_if_match_weak.golden"},
+		{name: "stale version", method: http.MethodPatch, target: "/users/2", body: "name=Robert",
+			headers:    map[string]string{"If-Match": `"2000"`},
+			wantStatus: http.StatusPreconditionFailed, golden: "error_precondition_failed.golden"},
+		{name: "deleted user", method: http.MethodPatch, target: "/users/3", body: "name=Caroline",
+			headers:    map[string]string{"If-Match": "*"},
+			wantStatus: http.StatusGone, golden: "error_update_gone.golden"},
+		{name: "unknown user", method: http.MethodPatch, target: "/users/99", body: "name=Nobody",
+			headers:    map[string]string{"If-Match": "*"},
+			wantStatus: http.StatusNotFound, golden: "error_user_not_found.golden"},
+		{name: "invalid id", method: http.MethodPatch, target: "/users/abc", body: "name=Nobody",
+			headers:    map[string]string{"If-Match": "*"},
+			wantStatus: http.StatusBadRequest, golden: "error_invalid_id.golden"},
+	})
+}
+
+func TestDeleteAndRestoreUserHandler(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{name: "delete", method: http.MethodDelete, target: "/users/1", repo: fixedClockRepository,
+			wantStatus: http.StatusOK, wantHeaders: map[string]string{"ETag": `"6000"`},
+			golden: "delete_user.golden"},
+		{name: "restore", method: http.MethodPost, target: "/users/3/restore", repo: fixedClockRepository,
+			wantStatus: http.StatusOK, golden: "restore_user.golden"},
+		{name: "delete unknown user", method: http.MethodDelete, target: "/users/99",
+			wantStatus: http.StatusNotFound, golden: "error_user_not_found.golden"},
+		{name: "restore invalid id", method: http.MethodPost, target: "/users/x/restore",
+			wantStatus: http.StatusBadRequest, golden: "error_invalid_id.golden"},
+	})
+}
+
+func TestRouteErrors(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{name: "method not allowed", method: http.MethodPut, target: "/users/1",
+			wantStatus: http.StatusMethodNotAllowed, wantHeaders: map[string]string{"Allow": "DELETE, GET, HEAD, PATCH"},
+			golden: "error_method_not_allowed.golden"},
+		{name: "method not allowed on collection", method: http.MethodDelete, target: "/users",
+			wantStatus: http.StatusMethodNotAllowed, golden: "error_method_not_allowed.golden"},
+		{name: "unknown route", method: http.MethodGet, target: "/accounts",
+			wantStatus: http.StatusNotFound, golden: "error_route_not_found.golden"},
+	})
+}
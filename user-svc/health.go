@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// HealthCheck is the result of checking a single dependency
+type HealthCheck struct {
+	Status    string         `json:"status"`
+	LatencyMS float64        `json:"latency_ms"`
+	Error     string         `json:"error,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+// HealthResponse is the response format for GET /healthz and GET /readyz
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+// HealthHandler serves the liveness and readiness endpoints
+type HealthHandler struct {
+	db       *sql.DB
+	backend  Backend
+	migrator *Migrator
+	timeout  time.Duration
+}
+
+// NewHealthHandler creates a new HealthHandler
+func NewHealthHandler(db *sql.DB, backend Backend, migrator *Migrator, timeout time.Duration) *HealthHandler {
+	return &HealthHandler{
+		db:       db,
+		backend:  backend,
+		migrator: migrator,
+		timeout:  timeout,
+	}
+}
+
+// Liveness handles GET /healthz. It only reports that the process is up and serving.
+func (h *HealthHandler) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, HealthResponse{Status: "ok"})
+}
+
+// Readiness handles GET /readyz. The service is ready when the database answers
+// and its schema is at the version this binary expects. The in-memory store
+// (no database) is always ready. The database check reports which backend is
+// in use and whether it is the DB_FALLBACK store.
+func (h *HealthHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+	if h.db == nil {
+		checks := map[string]HealthCheck{
+			"database": runCheck(func() (map[string]any, error) {
+				return h.backendDetails(), nil
+			}),
+		}
+		writeHealth(w, HealthResponse{Status: overallStatus(checks), Checks: checks})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	checks := map[string]HealthCheck{
+		"database": runCheck(func() (map[string]any, error) {
+			return h.backendDetails(), h.db.PingContext(ctx)
+		}),
+		"migrations": runCheck(func() (map[string]any, error) {
+			current, err := h.migrator.SchemaVersion(ctx)
+			if err != nil && !errors.Is(err, ErrSchemaOutdated) {
+				return nil, err
+			}
+			details := map[string]any{
+				"current_version": current,
+				"latest_version":  h.migrator.LatestVersion(),
+			}
+			return details, err
+		}),
+	}
+
+	writeHealth(w, HealthResponse{Status: overallStatus(checks), Checks: checks})
+}
+
+// backendDetails describes the active backend for the database check
+func (h *HealthHandler) backendDetails() map[string]any {
+	details := map[string]any{"backend": h.backend.Type}
+	if h.backend.Fallback {
+		details["fallback"] = true
+	}
+	return details
+}
+
+// runCheck times a dependency check and converts its outcome into a HealthCheck
+func runCheck(check func() (map[string]any, error)) HealthCheck {
+	start := time.Now()
+	details, err := check()
+
+	result := HealthCheck{
+		Status:    "ok",
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		Details:   details,
+	}
+	if err != nil {
+		result.Status = "fail"
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// overallStatus is "ok" only if every check passed
+func overallStatus(checks map[string]HealthCheck) string {
+	for _, check := range checks {
+		if check.Status != "ok" {
+			return "fail"
+		}
+	}
+	return "ok"
+}
+
+// writeHealth sends a health response, using 503 when the status isn't ok
+func writeHealth(w http.ResponseWriter, response HealthResponse) {
+	status := http.StatusOK
+	if response.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
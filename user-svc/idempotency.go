@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyKeyHeader lets a client retry a request without repeating its effect
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// errIdempotencyKeyLost is returned when a response is stored for a key that
+// is no longer claimed, because its claim timed out and was taken over
+var errIdempotencyKeyLost = errors.New("idempotency key is no longer reserved")
+
+// replayedHeaders are the response headers stored with a key and sent again on replay
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// IdempotencyConfig controls how long Idempotency-Keys are remembered
+type IdempotencyConfig struct {
+	// TTL is how long a key and its response are kept after first use
+	TTL time.Duration
+	// LockTimeout is how long a key stays claimed by a request that never
+	// stored a response, e.g. because the process died
+	LockTimeout time.Duration
+	// PurgeInterval is how often expired keys are deleted
+	PurgeInterval time.Duration
+}
+
+// getIdempotencyConfig reads the Idempotency-Key settings from environment variables
+func getIdempotencyConfig() IdempotencyConfig {
+	return IdempotencyConfig{
+		TTL:           getEnvDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+		LockTimeout:   getEnvDuration("IDEMPOTENCY_LOCK_TIMEOUT", time.Minute),
+		PurgeInterval: getEnvDuration("IDEMPOTENCY_PURGE_INTERVAL", time.Hour),
+	}
+}
+
+// IdempotencyRecord is a stored Idempotency-Key with the response to replay
+type IdempotencyRecord struct {
+	Key         string
+	RequestHash string
+	// StatusCode is 0 while the request that claimed the key is still running
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	CreatedAt  int64
+	ExpiresAt  int64
+}
+
+// IdempotencyStore remembers Idempotency-Keys and the responses sent for them
+type IdempotencyStore interface {
+	// Reserve claims key for a request with the given hash. If the key is
+	// already held, its record is returned and reserved is false. Expired
+	// keys, and claims older than the lock timeout, are taken over.
+	Reserve(ctx context.Context, key, requestHash string) (record IdempotencyRecord, reserved bool, err error)
+	// Complete stores the response for a claim returned by Reserve. If the
+	// claim has since been taken over it returns errIdempotencyKeyLost.
+	Complete(ctx context.Context, claim IdempotencyRecord, statusCode int, header http.Header, body []byte) error
+	// Release drops a claim returned by Reserve without a response, so the
+	// request can be retried. A claim that has been taken over is left alone.
+	Release(ctx context.Context, claim IdempotencyRecord) error
+	// DeleteExpired removes expired keys and returns how many were removed
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+// idempotent makes a handler safe to retry. A request carrying an
+// Idempotency-Key runs once; repeating it with the same key and body replays
+// the stored response, and reusing the key for a different request gets 422.
+// Server errors aren't stored, so the request can be retried with the same key.
+func idempotent(store IdempotencyStore, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if !validIdempotencyKey(key) {
+			respondInvalidField(w, r, idempotencyKeyHeader, "must be 1 to 255 printable ASCII characters")
+			return
+		}
+
+		// Read the body up front to fingerprint it, then hand it on unchanged
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxUserBodyBytes))
+		if err != nil {
+			respondBodyError(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := requestHash(r, body)
+		record, reserved, err := store.Reserve(r.Context(), key, hash)
+		if err != nil {
+			respondInternalError(w, r, err, "Failed to check idempotency key")
+			return
+		}
+		if !reserved {
+			replayIdempotent(w, r, record, hash)
+			return
+		}
+
+		capture := &responseCapture{ResponseWriter: w}
+		next(capture, r)
+
+		// Store the outcome even if the client has gone away, since that
+		// client is the one most likely to retry
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+		defer cancel()
+
+		if capture.status == 0 || capture.status >= http.StatusInternalServerError {
+			if err := store.Release(ctx, record); err != nil {
+				slog.Error("Failed to release idempotency key", "error", err, "method", r.Method, "path", r.URL.Path)
+			}
+			return
+		}
+
+		header := make(http.Header)
+		for _, name := range replayedHeaders {
+			if value := w.Header().Get(name); value != "" {
+				header.Set(name, value)
+			}
+		}
+		if err := store.Complete(ctx, record, capture.status, header, capture.body.Bytes()); err != nil {
+			slog.Error("Failed to store idempotent response", "error", err, "method", r.Method, "path", r.URL.Path)
+		}
+	}
+}
+
+// replayIdempotent answers a request whose key is already in use
+func replayIdempotent(w http.ResponseWriter, r *http.Request, record IdempotencyRecord, hash string) {
+	switch {
+	case record.RequestHash != hash:
+		respondError(w, r, http.StatusUnprocessableEntity, CodeIdempotencyKeyReused,
+			"Idempotency-Key was already used for a different request",
+			FieldError{Field: idempotencyKeyHeader, Message: "must be unique per request"})
+	case record.StatusCode == 0:
+		w.Header().Set("Retry-After", "1")
+		respondError(w, r, http.StatusConflict, CodeIdempotencyKeyInUse,
+			"A request with this Idempotency-Key is still being processed")
+	default:
+		for name, values := range record.Header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(record.StatusCode)
+		w.Write(record.Body)
+	}
+}
+
+// validIdempotencyKey reports whether key is 1 to 255 printable ASCII characters
+func validIdempotencyKey(key string) bool {
+	if len(key) > 255 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestHash fingerprints what a request asks for: its method, path, media
+// type and body
+func requestHash(r *http.Request, body []byte) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n"+mediaType+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseCapture keeps a copy of the response while passing it through
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (c *responseCapture) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *responseCapture) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.body.Write(p)
+	return c.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (c *responseCapture) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// purgeIdempotencyKeys deletes expired keys every interval until ctx is cancelled
+func purgeIdempotencyKeys(ctx context.Context, store IdempotencyStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		deleted, err := store.DeleteExpired(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("Failed to purge expired idempotency keys", "error", err)
+			}
+			continue
+		}
+		if deleted > 0 {
+			slog.Info("Purged expired idempotency keys", "deleted", deleted)
+		}
+	}
+}
+
+// SQLIdempotencyStore keeps Idempotency-Keys in the idempotency_keys table
+type SQLIdempotencyStore struct {
+	stmts   *stmtCache
+	config  IdempotencyConfig
+	timeout time.Duration
+}
+
+// NewSQLIdempotencyStore creates a SQLIdempotencyStore whose queries each run
+// within timeout
+func NewSQLIdempotencyStore(db *sql.DB, dialect Dialect, config IdempotencyConfig, timeout time.Duration) *SQLIdempotencyStore {
+	return &SQLIdempotencyStore{
+		stmts:   newStmtCache(db, dialect),
+		config:  config,
+		timeout: timeout,
+	}
+}
+
+// Close releases the store's prepared statements
+func (s *SQLIdempotencyStore) Close() error {
+	return s.stmts.Close()
+}
+
+func (s *SQLIdempotencyStore) Reserve(ctx context.Context, key, requestHash string) (IdempotencyRecord, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	now := time.Now().UnixMicro()
+
+	// Free the key if it has expired or was claimed by a request that never finished
+	_, err := s.stmts.exec(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE idempotency_key = ? AND (expires_at <= ? OR (status_code IS NULL AND created_at <= ?))
+	`, key, now, now-s.config.LockTimeout.Microseconds())
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+
+	insert := s.stmts.dialect.Upsert("idempotency_keys",
+		[]string{"idempotency_key", "request_hash", "created_at", "expires_at"}, []string{"idempotency_key"}, nil)
+	result, err := s.stmts.exec(ctx, insert, key, requestHash, now, now+s.config.TTL.Microseconds())
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return IdempotencyRecord{}, false, err
+	} else if affected == 1 {
+		return IdempotencyRecord{Key: key, RequestHash: requestHash, CreatedAt: now, ExpiresAt: now + s.config.TTL.Microseconds()}, true, nil
+	}
+
+	// Someone else holds the key
+	record := IdempotencyRecord{Key: key}
+	var statusCode sql.NullInt64
+	var header sql.NullString
+	stmt, err := s.stmts.prepare(ctx, `
+		SELECT request_hash, status_code, response_header, response_body, created_at, expires_at
+		FROM idempotency_keys
+		WHERE idempotency_key = ?
+	`)
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	err = stmt.QueryRowContext(ctx, key).Scan(&record.RequestHash, &statusCode, &header, &record.Body, &record.CreatedAt, &record.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The holder released the key in the meantime; have the client retry
+		return IdempotencyRecord{Key: key, RequestHash: requestHash}, false, nil
+	}
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+
+	record.StatusCode = int(statusCode.Int64)
+	if header.Valid {
+		if err := json.Unmarshal([]byte(header.String), &record.Header); err != nil {
+			return IdempotencyRecord{}, false, err
+		}
+	}
+	return record, false, nil
+}
+
+func (s *SQLIdempotencyStore) Complete(ctx context.Context, claim IdempotencyRecord, statusCode int, header http.Header, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	encodedHeader, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	// The claim is identified by its key and creation time, so a request
+	// whose claim timed out can't store over the one that took it over
+	result, err := s.stmts.exec(ctx, `
+		UPDATE idempotency_keys
+		SET status_code = ?, response_header = ?, response_body = ?
+		WHERE idempotency_key = ? AND created_at = ? AND status_code IS NULL
+	`, statusCode, string(encodedHeader), body, claim.Key, claim.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return errIdempotencyKeyLost
+	}
+	return nil
+}
+
+func (s *SQLIdempotencyStore) Release(ctx context.Context, claim IdempotencyRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	_, err := s.stmts.exec(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE idempotency_key = ? AND created_at = ? AND status_code IS NULL
+	`, claim.Key, claim.CreatedAt)
+	return err
+}
+
+func (s *SQLIdempotencyStore) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	result, err := s.stmts.exec(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= ?`, time.Now().UnixMicro())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// MemoryIdempotencyStore keeps Idempotency-Keys in process memory, for DB_TYPE=memory
+type MemoryIdempotencyStore struct {
+	config IdempotencyConfig
+
+	mu      sync.Mutex
+	records map[string]IdempotencyRecord
+}
+
+// NewMemoryIdempotencyStore creates an empty MemoryIdempotencyStore
+func NewMemoryIdempotencyStore(config IdempotencyConfig) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		config:  config,
+		records: make(map[string]IdempotencyRecord),
+	}
+}
+
+func (s *MemoryIdempotencyStore) Reserve(ctx context.Context, key, requestHash string) (IdempotencyRecord, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UnixMicro()
+	if record, ok := s.records[key]; ok {
+		abandoned := record.StatusCode == 0 && record.CreatedAt <= now-s.config.LockTimeout.Microseconds()
+		if record.ExpiresAt > now && !abandoned {
+			return record, false, nil
+		}
+	}
+
+	record := IdempotencyRecord{Key: key, RequestHash: requestHash, CreatedAt: now, ExpiresAt: now + s.config.TTL.Microseconds()}
+	s.records[key] = record
+	return record, true, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(ctx context.Context, claim IdempotencyRecord, statusCode int, header http.Header, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[claim.Key]
+	if !ok || record.CreatedAt != claim.CreatedAt || record.StatusCode != 0 {
+		return errIdempotencyKeyLost
+	}
+	record.StatusCode = statusCode
+	record.Header = header.Clone()
+	record.Body = bytes.Clone(body)
+	s.records[claim.Key] = record
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, claim IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.records[claim.Key]; ok && record.CreatedAt == claim.CreatedAt && record.StatusCode == 0 {
+		delete(s.records, claim.Key)
+	}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) DeleteExpired(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UnixMicro()
+	var deleted int64
+	for key, record := range s.records {
+		if record.ExpiresAt <= now {
+			delete(s.records, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIdempotencyConfig keeps keys long enough that they never expire during a test
+var testIdempotencyConfig = IdempotencyConfig{TTL: time.Hour, LockTimeout: time.Minute, PurgeInterval: time.Hour}
+
+// postUser sends POST /users with a JSON body and optional Idempotency-Key
+func postUser(handler http.Handler, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(requestIDHeader, testRequestID)
+	if key != "" {
+		req.Header.Set(idempotencyKeyHeader, key)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotentCreateUser(t *testing.T) {
+	repo := seededRepository(t)
+	router := newRouter(NewUserHandler(NewUserService(repo)), NewHealthHandler(nil, Backend{Type: "memory"}, nil, time.Second),
+		NewMemoryIdempotencyStore(testIdempotencyConfig))
+
+	first := postUser(router, "create-dave", `{"name":"Dave"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", first.Code, first.Body.String())
+	}
+
+	// A retry gets the original response without creating another user
+	replay := postUser(router, "create-dave", `{"name":"Dave"}`)
+	if replay.Code != http.StatusCreated || replay.Body.String() != first.Body.String() {
+		t.Errorf("Expected the original response replayed, got %d: %s", replay.Code, replay.Body.String())
+	}
+	if replay.Header().Get("Idempotent-Replayed") != "true" || replay.Header().Get("ETag") != first.Header().Get("ETag") {
+		t.Errorf("Expected replay headers, got %v", replay.Header())
+	}
+	if users, _ := repo.GetAllUsers(context.Background(), 1, 10, true); len(users) != 4 {
+		t.Errorf("Expected 4 users after the replay, got %d", len(users))
+	}
+
+	// The same key with a different body is refused
+	reused := postUser(router, "create-dave", `{"name":"Eve"}`)
+	if reused.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for a reused key, got %d", reused.Code)
+	}
+	assertGolden(t, filepath.Join("testdata", "handler", "error_idempotency_key_reused.golden"), reused.Body.Bytes())
+
+	invalid := postUser(router, strings.Repeat("k", 256), `{"name":"Eve"}`)
+	if invalid.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an overlong key, got %d", invalid.Code)
+	}
+
+	// Without a key every request creates a user
+	postUser(router, "", `{"name":"Dave"}`)
+	if users, _ := repo.GetAllUsers(context.Background(), 1, 10, true); len(users) != 5 {
+		t.Errorf("Expected 5 users, got %d", len(users))
+	}
+}
+
+func TestIdempotentStoresClientErrors(t *testing.T) {
+	router := newRouter(NewUserHandler(NewUserService(seededRepository(t))), NewHealthHandler(nil, Backend{Type: "memory"}, nil, time.Second),
+		NewMemoryIdempotencyStore(testIdempotencyConfig))
+
+	first := postUser(router, "blank", `{"name":"  "}`)
+	replay := postUser(router, "blank", `{"name":"  "}`)
+	if first.Code != http.StatusBadRequest || replay.Code != http.StatusBadRequest || replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Expected the 400 replayed, got %d then %d", first.Code, replay.Code)
+	}
+}
+
+func TestIdempotentServerErrorsCanBeRetried(t *testing.T) {
+	failing := true
+	repo := &MockUserRepository{
+		createUserFn: func(name string) (User, error) {
+			if failing {
+				return User{}, errors.New("disk I/O error")
+			}
+			return User{ID: 1, Name: name, CreatedAt: 1000, UpdatedAt: 1000}, nil
+		},
+	}
+	router := newRouter(NewUserHandler(NewUserService(repo)), NewHealthHandler(nil, Backend{Type: "memory"}, nil, time.Second),
+		NewMemoryIdempotencyStore(testIdempotencyConfig))
+
+	if rec := postUser(router, "retry-me", `{"name":"Dave"}`); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected 500, got %d", rec.Code)
+	}
+
+	failing = false
+	rec := postUser(router, "retry-me", `{"name":"Dave"}`)
+	if rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("Expected the retry to run, got %d with headers %v", rec.Code, rec.Header())
+	}
+}
+
+func TestIdempotencyKeyInProgress(t *testing.T) {
+	store := NewMemoryIdempotencyStore(testIdempotencyConfig)
+	handler := idempotent(store, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Handler must not run while the key is held")
+	})
+
+	body := `{"name":"Dave"}`
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if _, _, err := store.Reserve(context.Background(), "busy", requestHash(req, []byte(body))); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := postUser(handler, "busy", body)
+	if rec.Code != http.StatusConflict || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected 409 with Retry-After, got %d and %v", rec.Code, rec.Header())
+	}
+}
+
+func TestIdempotencyStores(t *testing.T) {
+	stores := []struct {
+		name string
+		open func(t *testing.T, config IdempotencyConfig) IdempotencyStore
+	}{
+		{name: "memory", open: func(t *testing.T, config IdempotencyConfig) IdempotencyStore {
+			return NewMemoryIdempotencyStore(config)
+		}},
+		{name: "sqlite", open: func(t *testing.T, config IdempotencyConfig) IdempotencyStore {
+			db := newTestSQLiteDB(t)
+			migrateTestDB(t, db, &sqliteDialect{})
+			return NewSQLIdempotencyStore(db, &sqliteDialect{}, config, time.Second)
+		}},
+		{name: "postgres", open: func(t *testing.T, config IdempotencyConfig) IdempotencyStore {
+			db := newTestPostgresDB(t)
+			migrateTestDB(t, db, postgresDialect{})
+			if _, err := db.Exec(`TRUNCATE idempotency_keys`); err != nil {
+				t.Fatalf("Failed to empty idempotency_keys table: %v", err)
+			}
+			return NewSQLIdempotencyStore(db, postgresDialect{}, config, time.Second)
+		}},
+	}
+
+	for _, backend := range stores {
+		t.Run(backend.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := backend.open(t, testIdempotencyConfig)
+
+			claim, reserved, err := store.Reserve(ctx, "k1", "hash")
+			if err != nil || !reserved {
+				t.Fatalf("Expected k1 reserved, got %v, %v", reserved, err)
+			}
+			record, reserved, err := store.Reserve(ctx, "k1", "other")
+			if err != nil || reserved || record.RequestHash != "hash" || record.StatusCode != 0 {
+				t.Fatalf("Expected k1 held and in progress, got %+v, %v, %v", record, reserved, err)
+			}
+
+			header := http.Header{"Etag": {`"1"`}}
+			if err := store.Complete(ctx, claim, http.StatusCreated, header, []byte(`{"result":true}`)); err != nil {
+				t.Fatalf("Complete failed: %v", err)
+			}
+			record, _, err = store.Reserve(ctx, "k1", "hash")
+			if err != nil || record.StatusCode != http.StatusCreated || string(record.Body) != `{"result":true}` || record.Header.Get("ETag") != `"1"` {
+				t.Errorf("Expected the stored response, got %+v, %v", record, err)
+			}
+
+			// A completed key can't be released or completed again
+			if err := store.Release(ctx, claim); err != nil {
+				t.Fatalf("Release failed: %v", err)
+			}
+			if err := store.Complete(ctx, claim, http.StatusOK, nil, nil); !errors.Is(err, errIdempotencyKeyLost) {
+				t.Errorf("Expected errIdempotencyKeyLost, got %v", err)
+			}
+
+			// A released key is free again
+			claim, _, _ = store.Reserve(ctx, "k2", "hash")
+			if err := store.Release(ctx, claim); err != nil {
+				t.Fatalf("Release failed: %v", err)
+			}
+			if _, reserved, err := store.Reserve(ctx, "k2", "new"); err != nil || !reserved {
+				t.Errorf("Expected k2 reserved after release, got %v, %v", reserved, err)
+			}
+		})
+
+		t.Run(backend.name+" expiry", func(t *testing.T) {
+			ctx := context.Background()
+			store := backend.open(t, IdempotencyConfig{TTL: time.Millisecond, LockTimeout: time.Hour})
+
+			claim, _, _ := store.Reserve(ctx, "old", "hash")
+			if err := store.Complete(ctx, claim, http.StatusCreated, nil, nil); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(5 * time.Millisecond)
+
+			if _, reserved, err := store.Reserve(ctx, "old", "new"); err != nil || !reserved {
+				t.Errorf("Expected the expired key reserved again, got %v, %v", reserved, err)
+			}
+			time.Sleep(5 * time.Millisecond)
+			if deleted, err := store.DeleteExpired(ctx); err != nil || deleted != 1 {
+				t.Errorf("Expected 1 expired key deleted, got %d, %v", deleted, err)
+			}
+		})
+
+		t.Run(backend.name+" abandoned claim", func(t *testing.T) {
+			ctx := context.Background()
+			store := backend.open(t, IdempotencyConfig{TTL: time.Hour, LockTimeout: time.Millisecond})
+
+			stale, _, _ := store.Reserve(ctx, "stuck", "hash")
+			time.Sleep(5 * time.Millisecond)
+			claim, reserved, err := store.Reserve(ctx, "stuck", "hash")
+			if err != nil || !reserved {
+				t.Fatalf("Expected the abandoned claim taken over, got %v, %v", reserved, err)
+			}
+
+			// The original holder can neither drop nor answer the new claim
+			if err := store.Release(ctx, stale); err != nil {
+				t.Fatalf("Release failed: %v", err)
+			}
+			if err := store.Complete(ctx, stale, http.StatusOK, nil, []byte("stale")); !errors.Is(err, errIdempotencyKeyLost) {
+				t.Errorf("Expected errIdempotencyKeyLost for the stale claim, got %v", err)
+			}
+			if err := store.Complete(ctx, claim, http.StatusCreated, nil, []byte("fresh")); err != nil {
+				t.Fatalf("Expected the new claim still held, got %v", err)
+			}
+			record, _, err := store.Reserve(ctx, "stuck", "hash")
+			if err != nil || record.StatusCode != http.StatusCreated || string(record.Body) != "fresh" {
+				t.Errorf("Expected the new holder's response stored, got %+v, %v", record, err)
+			}
+		})
+	}
+}
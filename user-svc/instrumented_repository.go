@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// InstrumentedUserRepository wraps a repository and records the latency and
+// failures of every call in the db_query_* metrics
+type InstrumentedUserRepository struct {
+	next UserRepositoryInterface
+}
+
+// NewInstrumentedUserRepository creates a new InstrumentedUserRepository
+func NewInstrumentedUserRepository(next UserRepositoryInterface) *InstrumentedUserRepository {
+	return &InstrumentedUserRepository{
+		next: next,
+	}
+}
+
+// observe records one repository call. Expected outcomes such as a missing
+// user are not counted as errors.
+func observe(method string, start time.Time, err error) {
+	dbQueryDuration.Observe(time.Since(start), method)
+
+	if err != nil && !errors.Is(err, ErrUserNotFound) && !errors.Is(err, ErrUserGone) && !errors.Is(err, ErrPreconditionFailed) {
+		dbQueryErrorsTotal.Inc(method)
+	}
+}
+
+// GetAllUsers records metrics for UserRepositoryInterface.GetAllUsers
+func (r *InstrumentedUserRepository) GetAllUsers(ctx context.Context, pageNum, pageSize int, includeDeleted bool) (users []User, err error) {
+	defer func(start time.Time) { observe("GetAllUsers", start, err) }(time.Now())
+	return r.next.GetAllUsers(ctx, pageNum, pageSize, includeDeleted)
+}
+
+// GetUsersAfter records metrics for UserRepositoryInterface.GetUsersAfter
+func (r *InstrumentedUserRepository) GetUsersAfter(ctx context.Context, cursor UserCursor, pageSize int, includeDeleted bool) (users []User, err error) {
+	defer func(start time.Time) { observe("GetUsersAfter", start, err) }(time.Now())
+	return r.next.GetUsersAfter(ctx, cursor, pageSize, includeDeleted)
+}
+
+// GetUserByID records metrics for UserRepositoryInterface.GetUserByID
+func (r *InstrumentedUserRepository) GetUserByID(ctx context.Context, id int) (user User, err error) {
+	defer func(start time.Time) { observe("GetUserByID", start, err) }(time.Now())
+	return r.next.GetUserByID(ctx, id)
+}
+
+// GetUsersByIDs records metrics for UserRepositoryInterface.GetUsersByIDs
+func (r *InstrumentedUserRepository) GetUsersByIDs(ctx context.Context, ids []int) (users []User, err error) {
+	defer func(start time.Time) { observe("GetUsersByIDs", start, err) }(time.Now())
+	return r.next.GetUsersByIDs(ctx, ids)
+}
+
+// SearchUsers records metrics for UserRepositoryInterface.SearchUsers
+func (r *InstrumentedUserRepository) SearchUsers(ctx context.Context, query string, pageNum, pageSize int, includeDeleted bool) (users []User, err error) {
+	defer func(start time.Time) { observe("SearchUsers", start, err) }(time.Now())
+	return r.next.SearchUsers(ctx, query, pageNum, pageSize, includeDeleted)
+}
+
+// CreateUser records metrics for UserRepositoryInterface.CreateUser
+func (r *InstrumentedUserRepository) CreateUser(ctx context.Context, name string) (user User, err error) {
+	defer func(start time.Time) { observe("CreateUser", start, err) }(time.Now())
+	return r.next.CreateUser(ctx, name)
+}
+
+// UpdateUser records metrics for UserRepositoryInterface.UpdateUser
+func (r *InstrumentedUserRepository) UpdateUser(ctx context.Context, id int, name string, ifUpdatedAt int64) (user User, err error) {
+	defer func(start time.Time) { observe("UpdateUser", start, err) }(time.Now())
+	return r.next.UpdateUser(ctx, id, name, ifUpdatedAt)
+}
+
+// DeleteUser records metrics for UserRepositoryInterface.DeleteUser
+func (r *InstrumentedUserRepository) DeleteUser(ctx context.Context, id int) (user User, err error) {
+	defer func(start time.Time) { observe("DeleteUser", start, err) }(time.Now())
+	return r.next.DeleteUser(ctx, id)
+}
+
+// RestoreUser records metrics for UserRepositoryInterface.RestoreUser
+func (r *InstrumentedUserRepository) RestoreUser(ctx context.Context, id int) (user User, err error) {
+	defer func(start time.Time) { observe("RestoreUser", start, err) }(time.Now())
+	return r.next.RestoreUser(ctx, id)
+}
+
+// ImportUsers records metrics for UserRepositoryInterface.ImportUsers
+func (r *InstrumentedUserRepository) ImportUsers(ctx context.Context, users []User, preserveIDs bool) (imported int, conflicts []int, err error) {
+	defer func(start time.Time) { observe("ImportUsers", start, err) }(time.Now())
+	return r.next.ImportUsers(ctx, users, preserveIDs)
+}
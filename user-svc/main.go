@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"           // PostgreSQL driver
@@ -15,8 +19,13 @@ import (
 )
 
 func main() {
-	// Set up structured logging with slog
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+	// Set up structured logging with slog. Subcommands log to stderr, since
+	// export writes its data to stdout.
+	logOutput := os.Stdout
+	if len(os.Args) > 1 {
+		logOutput = os.Stderr
+	}
+	logger := slog.New(slog.NewJSONHandler(logOutput, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
 	}))
 	slog.SetDefault(logger)
@@ -26,115 +35,194 @@ func main() {
 		slog.Warn("Error loading .env file, using environment variables", "error", err)
 	}
 
-	// Get database type (postgres or sqlite)
-	dbType := getEnv("DB_TYPE", "postgres")
-	dbType = strings.ToLower(dbType)
+	// Cancelled on SIGINT/SIGTERM, which also aborts waiting for the database
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	var db *sql.DB
-	var err error
+	db, backend, err := openDB(ctx, logger)
+	if err != nil {
+		slog.Error("Failed to connect to database", "error", err, "backend", backend.Type)
+		os.Exit(1)
+	}
+	slog.Info("Database backend selected", "backend", backend.Type, "fallback", backend.Fallback)
 
-	// Connect to appropriate database based on DB_TYPE
-	if dbType == "sqlite" {
-		// SQLite connection
-		sqlitePath := getEnv("SQLITE_DB_PATH", "./userservice.db")
-		slog.Info("Using SQLite database", "path", sqlitePath)
+	// Run a subcommand (e.g. `user-svc migrate up`) instead of the server if one is given
+	if len(os.Args) > 1 {
+		code := runCommand(db, logger, backend.Type, os.Args[1:])
+		if db != nil {
+			db.Close()
+		}
+		os.Exit(code)
+	}
 
-		db, err = sql.Open("sqlite3", sqlitePath)
+	// Initialize repository and service with the dialect of the database
+	var userRepo UserRepositoryInterface
+	var sqlRepo *UserRepository
+	var migrator *Migrator
+	var idempotencyStore IdempotencyStore
+	var sqlIdempotencyStore *SQLIdempotencyStore
+	idempotencyConfig := getIdempotencyConfig()
+	if db == nil {
+		userRepo = NewMemoryUserRepository()
+		idempotencyStore = NewMemoryIdempotencyStore(idempotencyConfig)
+	} else {
+		dialect, err := NewDialect(backend.Type)
 		if err != nil {
-			slog.Error("Failed to connect to SQLite database", "error", err)
+			slog.Error("Failed to select SQL dialect", "error", err)
+			db.Close()
 			os.Exit(1)
 		}
-	} else {
-		// Default to PostgreSQL
-		dbConfig := getDBConfig()
-		slog.Info("Using PostgreSQL database", "host", dbConfig.Host, "dbname", dbConfig.Name)
 
-		connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-			dbConfig.Host, dbConfig.Port, dbConfig.User, dbConfig.Password, dbConfig.Name)
-
-		db, err = sql.Open("postgres", connStr)
-		if err != nil {
-			// Try SQLite as fallback
-			slog.Warn("Failed to connect to PostgreSQL, trying SQLite as fallback", "error", err)
-
-			sqlitePath := getEnv("SQLITE_DB_PATH", "./userservice.db")
-			db, err = sql.Open("sqlite3", sqlitePath)
-			if err != nil {
-				slog.Error("Failed to connect to SQLite fallback", "error", err)
-				os.Exit(1)
-			}
-			slog.Info("Connected to SQLite fallback database", "path", sqlitePath)
-			dbType = "sqlite" // Update type for table creation
+		// Refuse to serve until all schema migrations have been applied
+		migrator = NewMigrator(db, logger, dialect)
+		if err := migrator.CheckCurrent(context.Background()); err != nil {
+			slog.Error("Database schema is not up to date, run `user-svc migrate up`", "error", err)
+			db.Close()
+			os.Exit(1)
 		}
-	}
-	defer db.Close()
 
-	// Test database connection
-	if err := db.Ping(); err != nil {
-		slog.Error("Failed to ping database", "error", err, "dbType", dbType)
-		os.Exit(1)
+		timeouts := getQueryTimeouts()
+		sqlRepo = NewUserRepository(db, logger, dialect, timeouts)
+		userRepo = sqlRepo
+		sqlIdempotencyStore = NewSQLIdempotencyStore(db, dialect, idempotencyConfig, timeouts.Create)
+		idempotencyStore = sqlIdempotencyStore
+		registerDBStats(db)
 	}
-	slog.Info("Successfully connected to database", "type", dbType)
+	userService := NewUserService(NewInstrumentedUserRepository(userRepo))
+	userHandler := NewUserHandler(userService)
+	healthHandler := NewHealthHandler(db, backend, migrator, getEnvDuration("HEALTH_CHECK_TIMEOUT", 2*time.Second))
 
-	// Create user table if it doesn't exist (with DB-specific SQL)
-	if err := initDB(db, dbType); err != nil {
-		slog.Error("Failed to initialize database", "error", err)
-		os.Exit(1)
+	go purgeIdempotencyKeys(ctx, idempotencyStore, idempotencyConfig.PurgeInterval)
+
+	// Serve until SIGINT/SIGTERM, then drain in-flight requests
+	router := newRouter(userHandler, healthHandler, idempotencyStore)
+	serverErr := runServer(ctx, getServerConfig(), metricsMiddleware(router))
+	if serverErr != nil {
+		slog.Error("Server failed", "error", serverErr)
 	}
 
-	serverPort := getServerPort()
+	// Close the database only after the last request has finished with it
+	if db != nil {
+		sqlRepo.Close()
+		sqlIdempotencyStore.Close()
+		if err := db.Close(); err != nil {
+			slog.Error("Failed to close database", "error", err)
+		} else {
+			slog.Info("Database connection closed")
+		}
+	}
 
-	// Initialize repository and service with database type
-	userRepo := NewUserRepository(db, logger, dbType)
-	userService := NewUserService(userRepo)
-	userHandler := NewUserHandler(userService)
+	if serverErr != nil {
+		os.Exit(1)
+	}
+}
 
-	// Register HTTP handlers
+// newRouter registers the HTTP handlers. Unknown routes and methods get JSON
+// errors, and user creation honours Idempotency-Key.
+func newRouter(userHandler *UserHandler, healthHandler *HealthHandler, idempotencyStore IdempotencyStore) http.Handler {
 	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", healthHandler.Liveness)
+	mux.HandleFunc("GET /readyz", healthHandler.Readiness)
+	mux.HandleFunc("GET /metrics", metricsHandler)
 	mux.HandleFunc("GET /users", userHandler.GetAllUsers)
+	mux.HandleFunc("GET /users/search", userHandler.SearchUsers)
 	mux.HandleFunc("GET /users/{id}", userHandler.GetUser)
-	mux.HandleFunc("POST /users", userHandler.CreateUser)
+	mux.HandleFunc("POST /users", idempotent(idempotencyStore, userHandler.CreateUser))
+	mux.HandleFunc("PATCH /users/{id}", userHandler.UpdateUser)
+	mux.HandleFunc("DELETE /users/{id}", userHandler.DeleteUser)
+	mux.HandleFunc("POST /users/{id}/restore", userHandler.RestoreUser)
 
-	// Start server
-	slog.Info("Server starting", "port", serverPort)
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", serverPort), mux); err != nil {
-		slog.Error("Server failed", "error", err)
-		os.Exit(1)
+	return jsonRouteErrors(mux)
+}
+
+// openDB connects to the database selected by DB_TYPE and returns the connection
+// and the backend in use. The in-memory store needs no connection, so db is nil
+// for it. The configuration is validated before connecting. PostgreSQL is
+// retried until DB_CONNECT_TIMEOUT passes; only then, and only with
+// DB_FALLBACK=sqlite, is the SQLite database used instead.
+func openDB(ctx context.Context, logger *slog.Logger) (*sql.DB, Backend, error) {
+	// Get database type (postgres, sqlite or memory)
+	backend := Backend{Type: strings.ToLower(getEnv("DB_TYPE", "postgres"))}
+
+	if backend.Type == "memory" {
+		slog.Warn("Using in-memory user store, data will be lost on restart")
+		return nil, backend, nil
+	}
+
+	fallback, err := getFallback()
+	if err != nil {
+		return nil, backend, err
+	}
+	cfg, err := loadDBConfig(backend.Type)
+	if err != nil {
+		return nil, backend, fmt.Errorf("invalid database configuration: %w", err)
+	}
+
+	switch backend.Type {
+	case "sqlite":
+		db, err := openSQLite(cfg)
+		return db, backend, err
+	case "postgres":
+		db, err := openPostgres(ctx, cfg, logger)
+		if err == nil {
+			return db, backend, nil
+		}
+		if fallback != "sqlite" || ctx.Err() != nil {
+			return nil, backend, err
+		}
+
+		slog.Warn("PostgreSQL is unavailable, falling back to SQLite because DB_FALLBACK=sqlite", "error", err)
+		backend = Backend{Type: "sqlite", Fallback: true}
+		db, err = openSQLite(cfg)
+		return db, backend, err
+	default:
+		return nil, backend, fmt.Errorf("unsupported DB_TYPE %q, expected postgres, sqlite or memory", backend.Type)
 	}
 }
 
-// DBConfig holds database configuration
-type DBConfig struct {
-	Host     string
-	Port     int
-	User     string
-	Password string
-	Name     string
+// openSQLite opens the SQLite database at SQLITE_DB_PATH. Problems such as a
+// bad path or missing permissions don't go away by waiting, so it isn't retried.
+func openSQLite(cfg DBConfig) (*sql.DB, error) {
+	slog.Info("Using SQLite database", "sqlite", cfg.SQLite, "pool", cfg.Pool)
+
+	db, err := sql.Open("sqlite3", cfg.SQLite.DSN())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+	cfg.Pool.Apply(db)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to SQLite database: %w", err)
+	}
+
+	slog.Info("Successfully connected to database", "type", "sqlite", "path", cfg.SQLite.Path)
+	return db, nil
 }
 
-// getDBConfig reads database configuration from environment variables
-func getDBConfig() DBConfig {
-	// Get database configuration with defaults
-	dbHost := getEnv("DB_HOST", "localhost")
-	dbPortStr := getEnv("DB_PORT", "5432")
-	dbUser := getEnv("DB_USER", "postgres")
-	dbPassword := getEnv("DB_PASSWORD", "postgres")
-	dbName := getEnv("DB_NAME", "userservice")
-
-	// Parse port number
-	dbPort, err := strconv.Atoi(dbPortStr)
+// openPostgres connects to PostgreSQL, retrying while it is unreachable, e.g.
+// while the database container is still starting
+func openPostgres(ctx context.Context, cfg DBConfig, logger *slog.Logger) (*sql.DB, error) {
+	dsn, err := cfg.PostgresDSN()
 	if err != nil {
-		slog.Warn("Invalid DB_PORT, using default", "value", dbPortStr, "default", 5432)
-		dbPort = 5432
+		return nil, err
 	}
+	slog.Info("Using PostgreSQL database", "dsn", redactDSN(dsn), "pool", cfg.Pool)
 
-	return DBConfig{
-		Host:     dbHost,
-		Port:     dbPort,
-		User:     dbUser,
-		Password: dbPassword,
-		Name:     dbName,
+	// sql.Open only validates its arguments; the connection is made by the ping
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PostgreSQL database: %w", err)
+	}
+	cfg.Pool.Apply(db)
+
+	if err := pingWithRetry(ctx, db, getRetryPolicy(), logger); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
 	}
+
+	slog.Info("Successfully connected to database", "type", "postgres", "dsn", redactDSN(dsn))
+	return db, nil
 }
 
 // getServerPort reads server port from environment variables
@@ -148,6 +236,36 @@ func getServerPort() int {
 	return port
 }
 
+// getQueryTimeouts reads the per-operation database timeouts from environment variables
+func getQueryTimeouts() QueryTimeouts {
+	defaults := DefaultQueryTimeouts()
+
+	return QueryTimeouts{
+		List:   getEnvDuration("DB_TIMEOUT_LIST", defaults.List),
+		Get:    getEnvDuration("DB_TIMEOUT_GET", defaults.Get),
+		Search: getEnvDuration("DB_TIMEOUT_SEARCH", defaults.Search),
+		Create: getEnvDuration("DB_TIMEOUT_CREATE", defaults.Create),
+		Update: getEnvDuration("DB_TIMEOUT_UPDATE", defaults.Update),
+		Delete: getEnvDuration("DB_TIMEOUT_DELETE", defaults.Delete),
+		Import: getEnvDuration("DB_TIMEOUT_IMPORT", defaults.Import),
+	}
+}
+
+// getEnvDuration reads a duration such as "500ms" or "5s" from an environment variable or returns a default value
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		slog.Warn("Invalid duration, using default", "key", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+	return duration
+}
+
 // getEnv reads an environment variable or returns a default value
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
@@ -156,33 +274,3 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
-
-// initDB creates the database schema based on the database type
-func initDB(db *sql.DB, dbType string) error {
-	var createTableSQL string
-
-	// Use appropriate SQL syntax based on database type
-	if dbType == "sqlite" {
-		createTableSQL = `
-			CREATE TABLE IF NOT EXISTS users (
-				id INTEGER PRIMARY KEY AUTOINCREMENT,
-				name TEXT NOT NULL,
-				created_at INTEGER NOT NULL,
-				updated_at INTEGER NOT NULL
-			)
-		`
-	} else {
-		// Default to PostgreSQL syntax
-		createTableSQL = `
-			CREATE TABLE IF NOT EXISTS users (
-				id SERIAL PRIMARY KEY,
-				name TEXT NOT NULL,
-				created_at BIGINT NOT NULL,
-				updated_at BIGINT NOT NULL
-			)
-		`
-	}
-
-	_, err := db.Exec(createTableSQL)
-	return err
-}
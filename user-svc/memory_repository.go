@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MemoryUserRepository keeps users in process memory. It is meant for local
+// development and tests, and behaves like the SQLite repository: IDs start at
+// 1 and are never reused, and results are ordered the same way.
+type MemoryUserRepository struct {
+	mu     sync.RWMutex
+	users  []User // indexed by ID - 1; IDs skipped by an import hold a zero User
+	lastID int
+}
+
+// NewMemoryUserRepository creates an empty MemoryUserRepository
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{}
+}
+
+// cloneUser copies a user so callers can't modify the stored record through DeletedAt
+func cloneUser(user User) User {
+	if user.DeletedAt != nil {
+		deletedAt := *user.DeletedAt
+		user.DeletedAt = &deletedAt
+	}
+	return user
+}
+
+// compareNewestFirst orders users by (created_at DESC, id DESC)
+func compareNewestFirst(a, b User) int {
+	switch {
+	case a.CreatedAt != b.CreatedAt:
+		if a.CreatedAt > b.CreatedAt {
+			return -1
+		}
+		return 1
+	case a.ID != b.ID:
+		if a.ID > b.ID {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// sortedUsers returns copies of the stored users matching keep, newest first.
+// The caller must hold the lock.
+func (r *MemoryUserRepository) sortedUsers(keep func(User) bool) []User {
+	users := make([]User, 0, len(r.users))
+	for _, user := range r.users {
+		if user.ID != 0 && keep(user) {
+			users = append(users, cloneUser(user))
+		}
+	}
+	slices.SortFunc(users, compareNewestFirst)
+	return users
+}
+
+// page returns users[offset:offset+limit], clamped to the slice
+func page(users []User, offset, limit int) []User {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(users) {
+		return []User{}
+	}
+	end := len(users)
+	if limit >= 0 && offset+limit < end {
+		end = offset + limit
+	}
+	return users[offset:end]
+}
+
+// GetAllUsers retrieves a page of users. Soft-deleted users are only included when includeDeleted is set.
+func (r *MemoryUserRepository) GetAllUsers(ctx context.Context, pageNum, pageSize int, includeDeleted bool) ([]User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := r.sortedUsers(func(user User) bool {
+		return includeDeleted || user.DeletedAt == nil
+	})
+	return page(users, (pageNum-1)*pageSize, pageSize), nil
+}
+
+// GetUsersAfter retrieves the page of users that follows the cursor in (created_at DESC, id DESC) order
+func (r *MemoryUserRepository) GetUsersAfter(ctx context.Context, cursor UserCursor, pageSize int, includeDeleted bool) ([]User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := r.sortedUsers(func(user User) bool {
+		after := user.CreatedAt < cursor.CreatedAt || (user.CreatedAt == cursor.CreatedAt && user.ID < cursor.ID)
+		return after && (includeDeleted || user.DeletedAt == nil)
+	})
+	return page(users, 0, pageSize), nil
+}
+
+// GetUserByID retrieves a specific user by ID, including soft-deleted users
+func (r *MemoryUserRepository) GetUserByID(ctx context.Context, id int) (User, error) {
+	if err := ctx.Err(); err != nil {
+		return User{}, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.get(id)
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return cloneUser(user), nil
+}
+
+// get returns the stored user with the given ID. The caller must hold the lock.
+func (r *MemoryUserRepository) get(id int) (User, bool) {
+	if id < 1 || id > len(r.users) || r.users[id-1].ID == 0 {
+		return User{}, false
+	}
+	return r.users[id-1], true
+}
+
+// GetUsersByIDs retrieves the users with the given IDs in ascending ID order,
+// including soft-deleted users. IDs that don't exist are simply absent.
+func (r *MemoryUserRepository) GetUsersByIDs(ctx context.Context, ids []int) ([]User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := make([]User, 0, len(ids))
+	for _, id := range slices.Compact(slices.Sorted(slices.Values(ids))) {
+		if user, ok := r.get(id); ok {
+			users = append(users, cloneUser(user))
+		}
+	}
+	return users, nil
+}
+
+// SearchUsers finds users whose name has a word starting with each term of
+// the query, ignoring case and accents. Like the SQLite FTS4 index, shorter
+// names rank first, then newer users.
+func (r *MemoryUserRepository) SearchUsers(ctx context.Context, query string, pageNum, pageSize int, includeDeleted bool) ([]User, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return []User{}, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for i, term := range terms {
+		terms[i] = foldSearchText(term)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := r.sortedUsers(func(user User) bool {
+		if !includeDeleted && user.DeletedAt != nil {
+			return false
+		}
+		words := searchTerms(foldSearchText(user.Name))
+		for _, term := range terms {
+			if !slices.ContainsFunc(words, func(word string) bool { return strings.HasPrefix(word, term) }) {
+				return false
+			}
+		}
+		return true
+	})
+
+	// Stable sort keeps the newest-first order among names of equal length
+	slices.SortStableFunc(users, func(a, b User) int {
+		return utf8.RuneCountInString(a.Name) - utf8.RuneCountInString(b.Name)
+	})
+	return page(users, (pageNum-1)*pageSize, pageSize), nil
+}
+
+// CreateUser stores a new user with the next ID
+func (r *MemoryUserRepository) CreateUser(ctx context.Context, name string) (User, error) {
+	if err := ctx.Err(); err != nil {
+		return User{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UnixMicro()
+	r.lastID++
+	user := User{ID: r.lastID, Name: name, CreatedAt: now, UpdatedAt: now}
+	r.users = append(r.users, user)
+
+	return cloneUser(user), nil
+}
+
+// ImportUsers stores a batch of users with their timestamps and deletion state
+// as given. With preserveIDs the users keep their IDs and those whose ID is
+// taken are skipped and returned in conflicts; otherwise new IDs are assigned.
+func (r *MemoryUserRepository) ImportUsers(ctx context.Context, users []User, preserveIDs bool) (int, []int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	imported := 0
+	conflicts := []int{}
+	for _, user := range users {
+		if !preserveIDs {
+			user.ID = r.lastID + 1
+		}
+		if _, taken := r.get(user.ID); taken {
+			conflicts = append(conflicts, user.ID)
+			continue
+		}
+
+		// Keep users indexed by ID, leaving holes for IDs that were skipped
+		for len(r.users) < user.ID {
+			r.users = append(r.users, User{})
+		}
+		r.users[user.ID-1] = cloneUser(user)
+		r.lastID = max(r.lastID, user.ID)
+		imported++
+	}
+
+	return imported, conflicts, nil
+}
+
+// UpdateUser changes a user's name. If ifUpdatedAt is non-zero the update only
+// succeeds while the stored updated_at still matches it, otherwise
+// ErrPreconditionFailed is returned.
+func (r *MemoryUserRepository) UpdateUser(ctx context.Context, id int, name string, ifUpdatedAt int64) (User, error) {
+	if err := ctx.Err(); err != nil {
+		return User{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.get(id)
+	switch {
+	case !ok:
+		return User{}, ErrUserNotFound
+	case user.DeletedAt != nil:
+		return User{}, ErrUserGone
+	case ifUpdatedAt != 0 && user.UpdatedAt != ifUpdatedAt:
+		return User{}, ErrPreconditionFailed
+	}
+
+	user.Name = name
+	user.UpdatedAt = nextVersion(user.UpdatedAt)
+	r.users[id-1] = user
+
+	return cloneUser(user), nil
+}
+
+// DeleteUser soft-deletes a user. Deleting an already deleted user is a no-op.
+func (r *MemoryUserRepository) DeleteUser(ctx context.Context, id int) (User, error) {
+	return r.setDeleted(ctx, id, true)
+}
+
+// RestoreUser clears a user's deleted_at tombstone. Restoring a user that isn't deleted is a no-op.
+func (r *MemoryUserRepository) RestoreUser(ctx context.Context, id int) (User, error) {
+	return r.setDeleted(ctx, id, false)
+}
+
+// setDeleted sets or clears deleted_at, bumping updated_at when the state changes
+func (r *MemoryUserRepository) setDeleted(ctx context.Context, id int, deleted bool) (User, error) {
+	if err := ctx.Err(); err != nil {
+		return User{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.get(id)
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+
+	if (user.DeletedAt != nil) != deleted {
+		now := nextVersion(user.UpdatedAt)
+		user.UpdatedAt = now
+		user.DeletedAt = nil
+		if deleted {
+			user.DeletedAt = &now
+		}
+		r.users[id-1] = user
+	}
+
+	return cloneUser(user), nil
+}
+
+// foldSearchText lower-cases s and strips accents from Latin letters, the way
+// the search indexes compare names
+func foldSearchText(s string) string {
+	return strings.Map(func(r rune) rune {
+		r = unicode.ToLower(r)
+		if folded, ok := accentFolds[r]; ok {
+			return folded
+		}
+		return r
+	}, s)
+}
+
+// accentFolds maps accented lower-case Latin letters to their base letter
+var accentFolds = func() map[rune]rune {
+	groups := map[rune]string{
+		'a': "àáâãäåāăą",
+		'c': "çćĉċč",
+		'd': "ďđ",
+		'e': "èéêëēĕėęě",
+		'g': "ĝğġģ",
+		'h': "ĥħ",
+		'i': "ìíîïĩīĭįı",
+		'j': "ĵ",
+		'k': "ķ",
+		'l': "ĺļľŀł",
+		'n': "ñńņňŉ",
+		'o': "òóôõöøōŏő",
+		'r': "ŕŗř",
+		's': "śŝşš",
+		't': "ţťŧ",
+		'u': "ùúûüũūŭůűų",
+		'w': "ŵ",
+		'y': "ýÿŷ",
+		'z': "źżž",
+	}
+
+	folds := make(map[rune]rune)
+	for base, accented := range groups {
+		for _, r := range accented {
+			folds[r] = base
+		}
+	}
+	return folds
+}()
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTP and repository metrics; registerDBStats adds the connection pool
+var (
+	httpRequestsTotal = newCounterVec("http_requests_total",
+		"Total HTTP requests by method, route and status code.", "method", "route", "status")
+	httpRequestDuration = newHistogramVec("http_request_duration_seconds",
+		"HTTP request latency by method and route.", latencyBuckets, "method", "route")
+	dbQueryDuration = newHistogramVec("db_query_duration_seconds",
+		"Database operation latency by repository method.", latencyBuckets, "method")
+	dbQueryErrorsTotal = newCounterVec("db_query_errors_total",
+		"Failed database operations by repository method.", "method")
+)
+
+// latencyBuckets, in seconds, are shared by requests and queries: most
+// requests are a single query, so both need resolution below a millisecond
+var latencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// collector is a metric family or a pool statistic the endpoint writes out
+type collector interface {
+	collect(w io.Writer)
+}
+
+// metricsRegistry is locked because registerDBStats adds collectors once the
+// database is open, after the package-level metrics
+var metricsRegistry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// registerCollector adds a collector to the metrics endpoint
+func registerCollector(c collector) {
+	metricsRegistry.mu.Lock()
+	defer metricsRegistry.mu.Unlock()
+	metricsRegistry.collectors = append(metricsRegistry.collectors, c)
+}
+
+// metricsHandler serves GET /metrics
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	metricsRegistry.mu.Lock()
+	collectors := append([]collector(nil), metricsRegistry.collectors...)
+	metricsRegistry.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, c := range collectors {
+		c.collect(w)
+	}
+}
+
+// counterVec is a counter partitioned by label values
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	registerCollector(c)
+	return c
+}
+
+// Inc adds one to the series for labelValues
+func (c *counterVec) Inc(labelValues ...string) {
+	key := formatLabels(c.labels, labelValues)
+
+	c.mu.Lock()
+	c.values[key]++
+	c.mu.Unlock()
+}
+
+func (c *counterVec) collect(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, key, formatFloat(c.values[key]))
+	}
+}
+
+// histogramVec is a histogram partitioned by label values
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+// histogram is one series; counts are per bucket and summed up on collect
+type histogram struct {
+	labelValues []string
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogram)}
+	registerCollector(h)
+	return h
+}
+
+// Observe adds d to the series for labelValues
+func (h *histogramVec) Observe(d time.Duration, labelValues ...string) {
+	value := d.Seconds()
+	key := formatLabels(h.labels, labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{labelValues: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *histogramVec) collect(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		bucketLabels := append(append([]string(nil), h.labels...), "le")
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			le := formatLabels(bucketLabels, append(append([]string(nil), s.labelValues...), formatFloat(bound)))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, le, cumulative)
+		}
+		le := formatLabels(bucketLabels, append(append([]string(nil), s.labelValues...), "+Inf"))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, le, s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, key, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, s.count)
+	}
+}
+
+// gaugeFunc is a gauge (or counter) whose value is read at scrape time
+type gaugeFunc struct {
+	name   string
+	help   string
+	kind   string
+	valueF func() float64
+}
+
+func (g *gaugeFunc) collect(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", g.name, g.help, g.name, g.kind, g.name, formatFloat(g.valueF()))
+}
+
+// registerDBStats exposes the connection pool statistics of db
+func registerDBStats(db *sql.DB) {
+	stat := func(name, help, kind string, value func(s sql.DBStats) float64) {
+		registerCollector(&gaugeFunc{name: name, help: help, kind: kind, valueF: func() float64 {
+			return value(db.Stats())
+		}})
+	}
+
+	stat("db_max_open_connections", "Maximum number of open connections to the database.", "gauge",
+		func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) })
+	stat("db_open_connections", "Number of established connections, both in use and idle.", "gauge",
+		func(s sql.DBStats) float64 { return float64(s.OpenConnections) })
+	stat("db_in_use_connections", "Number of connections currently in use.", "gauge",
+		func(s sql.DBStats) float64 { return float64(s.InUse) })
+	stat("db_idle_connections", "Number of idle connections.", "gauge",
+		func(s sql.DBStats) float64 { return float64(s.Idle) })
+	stat("db_wait_count_total", "Total number of connections waited for.", "counter",
+		func(s sql.DBStats) float64 { return float64(s.WaitCount) })
+	stat("db_wait_duration_seconds_total", "Total time blocked waiting for a new connection.", "counter",
+		func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() })
+	stat("db_max_idle_closed_total", "Total connections closed due to SetMaxIdleConns.", "counter",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) })
+	stat("db_max_idle_time_closed_total", "Total connections closed due to SetConnMaxIdleTime.", "counter",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) })
+	stat("db_max_lifetime_closed_total", "Total connections closed due to SetConnMaxLifetime.", "counter",
+		func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) })
+}
+
+// metricsMiddleware records request counts and latency per route. Every
+// route is registered with a method, so the label is the path half of the
+// pattern that matched, e.g. /users/{id}.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r)
+
+		_, route, _ := strings.Cut(r.Pattern, " ")
+		if route == "" {
+			route = "unmatched"
+		}
+
+		httpRequestsTotal.Inc(r.Method, route, strconv.Itoa(recorder.status))
+		httpRequestDuration.Observe(time.Since(start), r.Method, route)
+	})
+}
+
+// statusRecorder remembers the status for the http_requests_total label
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap exposes the wrapped writer to http.ResponseController
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// formatLabels builds the {name="value",...} part of a sample line
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatFloat writes +Inf the way the exposition format spells it
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sortedKeys orders series so consecutive scrapes list them the same way
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogramExposition(t *testing.T) {
+	h := &histogramVec{
+		name:    "test_duration_seconds",
+		help:    "Test histogram.",
+		labels:  []string{"method"},
+		buckets: []float64{0.1, 1},
+		series:  make(map[string]*histogram),
+	}
+
+	h.Observe(50*time.Millisecond, "Get")
+	h.Observe(500*time.Millisecond, "Get")
+	h.Observe(5*time.Second, "Get")
+
+	var b strings.Builder
+	h.collect(&b)
+	out := b.String()
+
+	for _, line := range []string{
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{method="Get",le="0.1"} 1`,
+		`test_duration_seconds_bucket{method="Get",le="1"} 2`,
+		`test_duration_seconds_bucket{method="Get",le="+Inf"} 3`,
+		`test_duration_seconds_sum{method="Get"} 5.55`,
+		`test_duration_seconds_count{method="Get"} 3`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected exposition to contain %q, got:\n%s", line, out)
+		}
+	}
+}
+
+func TestCounterLabelEscaping(t *testing.T) {
+	c := &counterVec{name: "test_total", help: "Test counter.", labels: []string{"route"}, values: make(map[string]float64)}
+	c.Inc(`/a"b\c`)
+	c.Inc(`/a"b\c`)
+
+	var b strings.Builder
+	c.collect(&b)
+
+	if want := `test_total{route="/a\"b\\c"} 2` + "\n"; !strings.Contains(b.String(), want) {
+		t.Errorf("Expected %q in output, got:\n%s", want, b.String())
+	}
+}
+
+func TestMetricsMiddlewareUsesRoutePattern(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics-test/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	rec := httptest.NewRecorder()
+	metricsMiddleware(mux).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics-test/42", nil))
+
+	var b strings.Builder
+	httpRequestsTotal.collect(&b)
+
+	want := `http_requests_total{method="GET",route="/metrics-test/{id}",status="418"} 1`
+	if !strings.Contains(b.String(), want) {
+		t.Errorf("Expected %q in output, got:\n%s", want, b.String())
+	}
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrSchemaOutdated is returned when the database has pending migrations
+var ErrSchemaOutdated = errors.New("database schema is out of date")
+
+// MigrationFunc is a migration step written in Go, for changes that depend on
+// what the database supports and can't be expressed as a fixed script
+type MigrationFunc func(ctx context.Context, tx *sql.Tx) error
+
+// Migration is a versioned schema change with SQL for each supported database type.
+// A database type may use UpFunc/DownFunc instead of an Up/Down script.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       map[string]string
+	Down     map[string]string
+	UpFunc   map[string]MigrationFunc
+	DownFunc map[string]MigrationFunc
+}
+
+// MigrationStatus describes whether a migration has been applied to the database
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt int64
+}
+
+// migrations is the ordered list of schema changes for the user service.
+// New migrations must be appended with the next version number; applied
+// migrations must never be edited.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_users",
+		Up: map[string]string{
+			"sqlite": `
+				CREATE TABLE IF NOT EXISTS users (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					name TEXT NOT NULL,
+					created_at INTEGER NOT NULL,
+					updated_at INTEGER NOT NULL
+				)
+			`,
+			"postgres": `
+				CREATE TABLE IF NOT EXISTS users (
+					id SERIAL PRIMARY KEY,
+					name TEXT NOT NULL,
+					created_at BIGINT NOT NULL,
+					updated_at BIGINT NOT NULL
+				)
+			`,
+		},
+		Down: map[string]string{
+			"sqlite":   `DROP TABLE IF EXISTS users`,
+			"postgres": `DROP TABLE IF EXISTS users`,
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add_users_deleted_at",
+		Up: map[string]string{
+			"sqlite":   `ALTER TABLE users ADD COLUMN deleted_at INTEGER`,
+			"postgres": `ALTER TABLE users ADD COLUMN deleted_at BIGINT`,
+		},
+		Down: map[string]string{
+			"sqlite":   `ALTER TABLE users DROP COLUMN deleted_at`,
+			"postgres": `ALTER TABLE users DROP COLUMN deleted_at`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add_users_created_at_id_index",
+		Up: map[string]string{
+			"sqlite":   `CREATE INDEX IF NOT EXISTS idx_users_created_at_id ON users (created_at DESC, id DESC)`,
+			"postgres": `CREATE INDEX IF NOT EXISTS idx_users_created_at_id ON users (created_at DESC, id DESC)`,
+		},
+		Down: map[string]string{
+			"sqlite":   `DROP INDEX IF EXISTS idx_users_created_at_id`,
+			"postgres": `DROP INDEX IF EXISTS idx_users_created_at_id`,
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add_users_search_index",
+		Down: map[string]string{
+			"sqlite": `
+				DROP TRIGGER IF EXISTS users_fts_before_update;
+				DROP TRIGGER IF EXISTS users_fts_before_delete;
+				DROP TRIGGER IF EXISTS users_fts_after_update;
+				DROP TRIGGER IF EXISTS users_fts_after_insert;
+				DROP TABLE IF EXISTS users_fts;
+			`,
+			"postgres": `
+				DROP INDEX IF EXISTS idx_users_name_trgm;
+				DROP INDEX IF EXISTS idx_users_search_vector;
+				ALTER TABLE users DROP COLUMN IF EXISTS search_vector;
+				DROP FUNCTION IF EXISTS users_unaccent(text);
+			`,
+		},
+		UpFunc: map[string]MigrationFunc{
+			"sqlite":   createSQLiteUserSearch,
+			"postgres": createPostgresUserSearch,
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create_idempotency_keys",
+		Up: dialectScripts(func(d Dialect) string {
+			return `
+				CREATE TABLE IF NOT EXISTS idempotency_keys (
+					idempotency_key ` + d.ColumnType(ColumnText) + ` PRIMARY KEY,
+					request_hash ` + d.ColumnType(ColumnText) + ` NOT NULL,
+					status_code ` + d.ColumnType(ColumnBigInt) + `,
+					response_header ` + d.ColumnType(ColumnText) + `,
+					response_body ` + d.ColumnType(ColumnBlob) + `,
+					created_at ` + d.ColumnType(ColumnBigInt) + ` NOT NULL,
+					expires_at ` + d.ColumnType(ColumnBigInt) + ` NOT NULL
+				);
+				CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
+			`
+		}),
+		Down: dialectScripts(func(d Dialect) string {
+			return `DROP TABLE IF EXISTS idempotency_keys`
+		}),
+	},
+}
+
+// dialectScripts builds a migration script for every SQL database type from
+// one definition, using the dialect's column types
+func dialectScripts(build func(d Dialect) string) map[string]string {
+	scripts := make(map[string]string)
+	for _, dbType := range []string{"sqlite", "postgres"} {
+		dialect, err := NewDialect(dbType)
+		if err != nil {
+			panic(err)
+		}
+		scripts[dbType] = build(dialect)
+	}
+	return scripts
+}
+
+// userSearchExtensions are the PostgreSQL extensions the users search index
+// is built on
+var userSearchExtensions = []string{"unaccent", "pg_trgm"}
+
+// createPostgresUserSearch adds the search_vector column and the trigram index
+// used by user search. Creating the extensions takes more than the usual
+// application role's privileges, so a refusal is reported with what to ask a
+// database administrator for.
+func createPostgresUserSearch(ctx context.Context, tx *sql.Tx) error {
+	for _, extension := range userSearchExtensions {
+		if _, err := tx.ExecContext(ctx, `CREATE EXTENSION IF NOT EXISTS `+extension); err != nil {
+			return extensionError(extension, err)
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		-- unaccent() is only STABLE, so wrap it to use it in indexes and generated columns
+		CREATE OR REPLACE FUNCTION users_unaccent(text) RETURNS text AS
+			$$ SELECT unaccent('unaccent', $1) $$
+			LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT;
+
+		ALTER TABLE users ADD COLUMN search_vector tsvector
+			GENERATED ALWAYS AS (to_tsvector('simple', users_unaccent(name))) STORED;
+		CREATE INDEX idx_users_search_vector ON users USING GIN (search_vector);
+		CREATE INDEX idx_users_name_trgm ON users USING GIN (users_unaccent(lower(name)) gin_trgm_ops);
+	`)
+	return err
+}
+
+// extensionError explains a failed CREATE EXTENSION. An extension that
+// already exists is not created again, so once an administrator has created
+// them the migration runs as the application role.
+func extensionError(extension string, err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code.Name() != "insufficient_privilege" {
+		return fmt.Errorf("create extension %s: %w", extension, err)
+	}
+
+	var statements []string
+	for _, name := range userSearchExtensions {
+		statements = append(statements, "CREATE EXTENSION IF NOT EXISTS "+name+";")
+	}
+	return fmt.Errorf("creating the %s extension needs a superuser, or on PostgreSQL 13+ the CREATE privilege on the database; "+
+		"have an administrator run `%s` in this database, then run migrate up again: %w", extension, strings.Join(statements, " "), err)
+}
+
+// createSQLiteUserSearch creates the users_fts full-text index, kept in sync
+// with the users table by triggers. FTS5 is only compiled into go-sqlite3 with
+// the sqlite_fts5 build tag, so FTS4 is used when it isn't available.
+func createSQLiteUserSearch(ctx context.Context, tx *sql.Tx) error {
+	var fts5 bool
+	if err := tx.QueryRowContext(ctx, `SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5); err != nil {
+		return err
+	}
+
+	var statements []string
+	if fts5 {
+		statements = []string{
+			`CREATE VIRTUAL TABLE users_fts USING fts5(
+				name, content='users', content_rowid='id', tokenize='unicode61 remove_diacritics 2'
+			)`,
+			`CREATE TRIGGER users_fts_after_insert AFTER INSERT ON users BEGIN
+				INSERT INTO users_fts (rowid, name) VALUES (new.id, new.name);
+			END`,
+			`CREATE TRIGGER users_fts_after_update AFTER UPDATE OF name ON users BEGIN
+				INSERT INTO users_fts (users_fts, rowid, name) VALUES ('delete', old.id, old.name);
+				INSERT INTO users_fts (rowid, name) VALUES (new.id, new.name);
+			END`,
+			`CREATE TRIGGER users_fts_before_delete BEFORE DELETE ON users BEGIN
+				INSERT INTO users_fts (users_fts, rowid, name) VALUES ('delete', old.id, old.name);
+			END`,
+		}
+	} else {
+		// FTS4 reads deleted rows back from the content table, so removals
+		// must happen before the users row changes
+		statements = []string{
+			`CREATE VIRTUAL TABLE users_fts USING fts4(
+				content="users", name, tokenize=unicode61 "remove_diacritics=2"
+			)`,
+			`CREATE TRIGGER users_fts_after_insert AFTER INSERT ON users BEGIN
+				INSERT INTO users_fts (docid, name) VALUES (new.id, new.name);
+			END`,
+			`CREATE TRIGGER users_fts_before_update BEFORE UPDATE OF name ON users BEGIN
+				DELETE FROM users_fts WHERE docid = old.id;
+			END`,
+			`CREATE TRIGGER users_fts_after_update AFTER UPDATE OF name ON users BEGIN
+				INSERT INTO users_fts (docid, name) VALUES (new.id, new.name);
+			END`,
+			`CREATE TRIGGER users_fts_before_delete BEFORE DELETE ON users BEGIN
+				DELETE FROM users_fts WHERE docid = old.id;
+			END`,
+		}
+	}
+
+	// Index the users that already exist
+	statements = append(statements, `INSERT INTO users_fts (users_fts) VALUES ('rebuild')`)
+
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Migrator applies and rolls back schema migrations, tracking them in the schema_migrations table
+type Migrator struct {
+	db         *sql.DB
+	logger     *slog.Logger
+	dialect    Dialect
+	migrations []Migration
+}
+
+// NewMigrator creates a new Migrator for the registered migrations
+func NewMigrator(db *sql.DB, logger *slog.Logger, dialect Dialect) *Migrator {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+
+	return &Migrator{
+		db:         db,
+		logger:     logger,
+		dialect:    dialect,
+		migrations: sorted,
+	}
+}
+
+// LatestVersion returns the highest migration version known to this binary
+func (m *Migrator) LatestVersion() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// CurrentVersion returns the highest migration version applied to the database
+func (m *Migrator) CurrentVersion(ctx context.Context) (int, error) {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return highestVersion(applied), nil
+}
+
+// CheckCurrent returns ErrSchemaOutdated if any known migration has not been applied
+func (m *Migrator) CheckCurrent(ctx context.Context) error {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return err
+	}
+	return m.checkApplied(applied)
+}
+
+// SchemaVersion returns the highest migration version applied to the database,
+// along with ErrSchemaOutdated if any known migration has not been applied.
+// Unlike CurrentVersion and CheckCurrent it never writes, which makes it safe
+// for readiness probes: without a schema_migrations table the version is 0.
+func (m *Migrator) SchemaVersion(ctx context.Context) (int, error) {
+	var exists int
+	if err := m.db.QueryRowContext(ctx, m.dialect.Rebind(m.dialect.TableExistsQuery()), "schema_migrations").Scan(&exists); err != nil {
+		return 0, err
+	}
+
+	applied := map[int]int64{}
+	if exists > 0 {
+		var err error
+		if applied, err = m.readAppliedVersions(ctx); err != nil {
+			return 0, err
+		}
+	}
+	return highestVersion(applied), m.checkApplied(applied)
+}
+
+// highestVersion returns the highest of the applied versions, or 0 if there are none
+func highestVersion(applied map[int]int64) int {
+	current := 0
+	for version := range applied {
+		if version > current {
+			current = version
+		}
+	}
+	return current
+}
+
+// checkApplied returns ErrSchemaOutdated for the first known migration missing from applied
+func (m *Migrator) checkApplied(applied map[int]int64) error {
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			return fmt.Errorf("%w: migration %d (%s) is pending", ErrSchemaOutdated, migration.Version, migration.Name)
+		}
+	}
+	return nil
+}
+
+// Status reports every known migration and whether it has been applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		appliedAt, ok := applied[migration.Version]
+		statuses = append(statuses, MigrationStatus{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+	return statuses, nil
+}
+
+// Up applies all pending migrations in version order and returns the ones that were applied
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		step, ok := m.step(migration, true)
+		if !ok {
+			return done, fmt.Errorf("migration %d (%s) has no up script for %s", migration.Version, migration.Name, m.dialect.Name())
+		}
+
+		if err := m.apply(ctx, migration, step, true); err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// Down rolls back the most recently applied migration. It returns false if nothing was applied.
+func (m *Migrator) Down(ctx context.Context) (Migration, bool, error) {
+	current, err := m.CurrentVersion(ctx)
+	if err != nil {
+		return Migration{}, false, err
+	}
+	if current == 0 {
+		return Migration{}, false, nil
+	}
+
+	for _, migration := range m.migrations {
+		if migration.Version != current {
+			continue
+		}
+
+		step, ok := m.step(migration, false)
+		if !ok {
+			return migration, false, fmt.Errorf("migration %d (%s) has no down script for %s", migration.Version, migration.Name, m.dialect.Name())
+		}
+
+		if err := m.apply(ctx, migration, step, false); err != nil {
+			return migration, false, err
+		}
+		return migration, true, nil
+	}
+
+	return Migration{}, false, fmt.Errorf("applied migration %d is unknown to this binary", current)
+}
+
+// step returns the up or down step of a migration for the database type
+func (m *Migrator) step(migration Migration, up bool) (MigrationFunc, bool) {
+	scripts, funcs := migration.Down, migration.DownFunc
+	if up {
+		scripts, funcs = migration.Up, migration.UpFunc
+	}
+
+	if query, ok := scripts[m.dialect.Name()]; ok {
+		return func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, query)
+			return err
+		}, true
+	}
+
+	fn, ok := funcs[m.dialect.Name()]
+	return fn, ok
+}
+
+// apply runs a migration step and records the change in schema_migrations within one transaction
+func (m *Migrator) apply(ctx context.Context, migration Migration, step MigrationFunc, up bool) error {
+	direction := "down"
+	if up {
+		direction = "up"
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := step(ctx, tx); err != nil {
+		m.logger.Error("Migration failed", "error", err, "version", migration.Version, "name", migration.Name, "direction", direction)
+		return fmt.Errorf("migration %d (%s) %s: %w", migration.Version, migration.Name, direction, err)
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, m.dialect.Rebind(`
+			INSERT INTO schema_migrations (version, name, applied_at)
+			VALUES (?, ?, ?)
+		`), migration.Version, migration.Name, time.Now().UnixMicro())
+	} else {
+		_, err = tx.ExecContext(ctx, m.dialect.Rebind(`DELETE FROM schema_migrations WHERE version = ?`), migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	m.logger.Info("Applied migration", "version", migration.Version, "name", migration.Name, "direction", direction)
+	return nil
+}
+
+// appliedVersions returns the applied migration versions mapped to their applied_at timestamps
+func (m *Migrator) appliedVersions(ctx context.Context) (map[int]int64, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	return m.readAppliedVersions(ctx)
+}
+
+// readAppliedVersions reads schema_migrations, which must exist
+func (m *Migrator) readAppliedVersions(ctx context.Context) (map[int]int64, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]int64)
+	for rows.Next() {
+		var version int
+		var appliedAt int64
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// ensureTable creates the schema_migrations tracking table if it doesn't exist
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at BIGINT NOT NULL
+		)
+	`)
+	return err
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// newTestSQLiteDB opens an empty SQLite database in a temporary directory
+func newTestSQLiteDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to open SQLite database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// discardLogger returns a logger that drops all output
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestMigratorUpAndDown(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLiteDB(t)
+	migrator := NewMigrator(db, discardLogger(), &sqliteDialect{})
+
+	if err := migrator.CheckCurrent(ctx); !errors.Is(err, ErrSchemaOutdated) {
+		t.Fatalf("Expected ErrSchemaOutdated on empty database, got %v", err)
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("Expected %d migrations to be applied, got %d", len(migrations), len(applied))
+	}
+
+	if err := migrator.CheckCurrent(ctx); err != nil {
+		t.Errorf("Expected schema to be current after Up, got %v", err)
+	}
+
+	current, err := migrator.CurrentVersion(ctx)
+	if err != nil {
+		t.Fatalf("CurrentVersion failed: %v", err)
+	}
+	if current != migrator.LatestVersion() {
+		t.Errorf("Expected version %d, got %d", migrator.LatestVersion(), current)
+	}
+
+	// Running Up again is a no-op
+	applied, err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("Second Up failed: %v", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("Expected no migrations on second Up, got %d", len(applied))
+	}
+
+	// Roll everything back one step at a time
+	for i := len(migrations); i > 0; i-- {
+		migration, rolledBack, err := migrator.Down(ctx)
+		if err != nil {
+			t.Fatalf("Down failed: %v", err)
+		}
+		if !rolledBack {
+			t.Fatalf("Expected a migration to be rolled back")
+		}
+		if migration.Version != migrator.migrations[i-1].Version {
+			t.Errorf("Expected to roll back version %d, got %d", migrator.migrations[i-1].Version, migration.Version)
+		}
+	}
+
+	if _, rolledBack, err := migrator.Down(ctx); err != nil || rolledBack {
+		t.Errorf("Expected Down on empty schema to be a no-op, got rolledBack=%v err=%v", rolledBack, err)
+	}
+
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'users'`).Scan(&count); err != nil {
+		t.Fatalf("Failed to inspect schema: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected users table to be dropped after rolling back all migrations")
+	}
+}
+
+func TestMigratorStatus(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLiteDB(t)
+	migrator := NewMigrator(db, discardLogger(), &sqliteDialect{})
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	for _, status := range statuses {
+		if status.Applied {
+			t.Errorf("Expected migration %d to be pending", status.Version)
+		}
+	}
+
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+
+	statuses, err = migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if len(statuses) != len(migrations) {
+		t.Fatalf("Expected %d statuses, got %d", len(migrations), len(statuses))
+	}
+	for _, status := range statuses {
+		if !status.Applied || status.AppliedAt == 0 {
+			t.Errorf("Expected migration %d to be applied with a timestamp, got %+v", status.Version, status)
+		}
+	}
+}
+
+func TestMigratorSchemaVersion(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLiteDB(t)
+	migrator := NewMigrator(db, discardLogger(), &sqliteDialect{})
+
+	// A fresh database is at version 0, and looking doesn't create the tracking table
+	current, err := migrator.SchemaVersion(ctx)
+	if current != 0 || !errors.Is(err, ErrSchemaOutdated) {
+		t.Fatalf("Expected version 0 and ErrSchemaOutdated, got %d and %v", current, err)
+	}
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`).Scan(&count); err != nil {
+		t.Fatalf("Failed to inspect schema: %v", err)
+	}
+	if count != 0 {
+		t.Error("Expected SchemaVersion not to create schema_migrations")
+	}
+
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	current, err = migrator.SchemaVersion(ctx)
+	if err != nil || current != migrator.LatestVersion() {
+		t.Errorf("Expected version %d, got %d and %v", migrator.LatestVersion(), current, err)
+	}
+}
+
+func TestMigrationsHaveScriptsForAllDialects(t *testing.T) {
+	seen := make(map[int]bool)
+	for _, migration := range migrations {
+		if seen[migration.Version] {
+			t.Errorf("Duplicate migration version %d", migration.Version)
+		}
+		seen[migration.Version] = true
+
+		for _, dialect := range []Dialect{&sqliteDialect{}, postgresDialect{}} {
+			migrator := NewMigrator(nil, discardLogger(), dialect)
+			if _, ok := migrator.step(migration, true); !ok {
+				t.Errorf("Migration %d has no up script for %s", migration.Version, dialect.Name())
+			}
+			if _, ok := migrator.step(migration, false); !ok {
+				t.Errorf("Migration %d has no down script for %s", migration.Version, dialect.Name())
+			}
+		}
+	}
+}
+
+func TestExtensionErrorExplainsMissingPrivilege(t *testing.T) {
+	denied := &pq.Error{Code: "42501", Message: "permission denied to create extension \"unaccent\""}
+	err := extensionError("unaccent", fmt.Errorf("exec: %w", denied))
+	if !errors.Is(err, denied) {
+		t.Errorf("Expected the driver error wrapped, got %v", err)
+	}
+	for _, want := range []string{"CREATE EXTENSION IF NOT EXISTS unaccent;", "CREATE EXTENSION IF NOT EXISTS pg_trgm;", "superuser"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in %q", want, err.Error())
+		}
+	}
+
+	other := &pq.Error{Code: "58P01", Message: "could not open extension control file"}
+	if err := extensionError("pg_trgm", other); strings.Contains(err.Error(), "superuser") || !errors.Is(err, other) {
+		t.Errorf("Expected other failures passed on as they are, got %v", err)
+	}
+}
@@ -6,12 +6,21 @@ type User struct {
 	Name      string `json:"name"`
 	CreatedAt int64  `json:"created_at"`
 	UpdatedAt int64  `json:"updated_at"`
+	DeletedAt *int64 `json:"deleted_at,omitempty"`
 }
 
 // UsersResponse is the response format for GET /users
 type UsersResponse struct {
-	Result bool   `json:"result"`
-	Users  []User `json:"users"`
+	Result     bool   `json:"result"`
+	Users      []User `json:"users"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// UsersBatchResponse is the response format for GET /users?ids=...
+type UsersBatchResponse struct {
+	Result     bool   `json:"result"`
+	Users      []User `json:"users"`
+	MissingIDs []int  `json:"missing_ids"`
 }
 
 // UserResponse is the response format for GET /users/{id} and POST /users
@@ -4,74 +4,153 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"log/slog"
+	"strings"
 	"time"
+	"unicode"
 )
 
 // UserRepositoryInterface defines the methods that a user repository must implement
 type UserRepositoryInterface interface {
-	GetAllUsers(pageNum, pageSize int) ([]User, error)
-	GetUserByID(id int) (User, error)
-	CreateUser(name string) (User, error)
+	GetAllUsers(ctx context.Context, pageNum, pageSize int, includeDeleted bool) ([]User, error)
+	GetUsersAfter(ctx context.Context, cursor UserCursor, pageSize int, includeDeleted bool) ([]User, error)
+	GetUserByID(ctx context.Context, id int) (User, error)
+	GetUsersByIDs(ctx context.Context, ids []int) ([]User, error)
+	SearchUsers(ctx context.Context, query string, pageNum, pageSize int, includeDeleted bool) ([]User, error)
+	CreateUser(ctx context.Context, name string) (User, error)
+	UpdateUser(ctx context.Context, id int, name string, ifUpdatedAt int64) (User, error)
+	DeleteUser(ctx context.Context, id int) (User, error)
+	RestoreUser(ctx context.Context, id int) (User, error)
+	ImportUsers(ctx context.Context, users []User, preserveIDs bool) (imported int, conflicts []int, err error)
 }
 
-// UserRepository handles data access operations for users
+// userColumns is the column list matching scanUser
+const userColumns = "id, name, created_at, updated_at, deleted_at"
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanUser reads a row selected with userColumns into a User
+func scanUser(row rowScanner) (User, error) {
+	var user User
+	var deletedAt sql.NullInt64
+	if err := row.Scan(&user.ID, &user.Name, &user.CreatedAt, &user.UpdatedAt, &deletedAt); err != nil {
+		return User{}, err
+	}
+	if deletedAt.Valid {
+		user.DeletedAt = &deletedAt.Int64
+	}
+	return user, nil
+}
+
+// nextVersion returns a new updated_at value that is strictly greater than
+// current, since updated_at doubles as the user's version
+func nextVersion(current int64) int64 {
+	now := time.Now().UnixMicro()
+	if now <= current {
+		return current + 1
+	}
+	return now
+}
+
+// QueryTimeouts bounds how long each kind of repository operation may run.
+// The effective deadline is the earlier of this and the caller's context.
+type QueryTimeouts struct {
+	List   time.Duration
+	Get    time.Duration
+	Search time.Duration
+	Create time.Duration
+	Update time.Duration
+	Delete time.Duration
+	Import time.Duration
+}
+
+// DefaultQueryTimeouts returns the timeouts used when none are configured
+func DefaultQueryTimeouts() QueryTimeouts {
+	return QueryTimeouts{
+		List:   5 * time.Second,
+		Get:    3 * time.Second,
+		Search: 5 * time.Second,
+		Create: 3 * time.Second,
+		Update: 3 * time.Second,
+		Delete: 3 * time.Second,
+		Import: 60 * time.Second,
+	}
+}
+
+// UserRepository handles data access operations for users. Queries are
+// written once with ? placeholders; the dialect adapts them to the database.
 type UserRepository struct {
-	db     *sql.DB
-	logger *slog.Logger
-	dbType string
+	db       *sql.DB
+	logger   *slog.Logger
+	dialect  Dialect
+	stmts    *stmtCache
+	timeouts QueryTimeouts
 }
 
 // NewUserRepository creates a new UserRepository
-func NewUserRepository(db *sql.DB, logger *slog.Logger, dbType string) *UserRepository {
+func NewUserRepository(db *sql.DB, logger *slog.Logger, dialect Dialect, timeouts QueryTimeouts) *UserRepository {
 	return &UserRepository{
-		db:     db,
-		logger: logger,
-		dbType: dbType,
+		db:       db,
+		logger:   logger,
+		dialect:  dialect,
+		stmts:    newStmtCache(db, dialect),
+		timeouts: timeouts,
 	}
 }
 
-// GetAllUsers retrieves all users from the database
-func (r *UserRepository) GetAllUsers(pageNum, pageSize int) ([]User, error) {
+// Close releases the repository's prepared statements
+func (r *UserRepository) Close() error {
+	return r.stmts.Close()
+}
+
+// logError logs a failed database operation. Failures caused by the caller
+// cancelling the request or by a deadline are logged as such rather than as
+// database errors.
+func (r *UserRepository) logError(ctx context.Context, msg string, err error, args ...any) {
+	args = append([]any{"error", err}, args...)
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled):
+		r.logger.Info("Database operation cancelled", append(args, "operation", msg)...)
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		r.logger.Warn("Database operation timed out", append(args, "operation", msg)...)
+	default:
+		r.logger.Error(msg, args...)
+	}
+}
+
+// GetAllUsers retrieves all users from the database. Soft-deleted users are
+// only included when includeDeleted is set.
+func (r *UserRepository) GetAllUsers(ctx context.Context, pageNum, pageSize int, includeDeleted bool) ([]User, error) {
 	// Create context with timeout for database operations
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.List)
 	defer cancel()
 
 	// Calculate offset
 	offset := (pageNum - 1) * pageSize
 
-	var rows *sql.Rows
-	var err error
-
-	// Use appropriate SQL syntax based on database type
-	if r.dbType == "sqlite" {
-		rows, err = r.db.QueryContext(ctx, `
-			SELECT id, name, created_at, updated_at
-			FROM users
-			ORDER BY created_at DESC
-			LIMIT ? OFFSET ?
-		`, pageSize, offset)
-	} else {
-		rows, err = r.db.QueryContext(ctx, `
-			SELECT id, name, created_at, updated_at
-			FROM users
-			ORDER BY created_at DESC
-			LIMIT $1 OFFSET $2
-		`, pageSize, offset)
-	}
-
+	rows, err := r.stmts.query(ctx, `
+		SELECT `+userColumns+`
+		FROM users
+		`+activeUsersFilter("WHERE", includeDeleted)+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, pageSize, offset)
 	if err != nil {
-		r.logger.Error("Database query failed", "error", err, "pageNum", pageNum, "pageSize", pageSize)
+		r.logError(ctx, "Database query failed", err, "pageNum", pageNum, "pageSize", pageSize)
 		return nil, err
 	}
 	defer rows.Close()
 
 	// Parse results
-	var users []User
+	users := []User{}
 	for rows.Next() {
-		var user User
-		if err := rows.Scan(&user.ID, &user.Name, &user.CreatedAt, &user.UpdatedAt); err != nil {
-			r.logger.Error("Row scan failed", "error", err)
+		user, err := scanUser(rows)
+		if err != nil {
+			r.logError(ctx, "Row scan failed", err)
 			return nil, err
 		}
 		users = append(users, user)
@@ -79,7 +158,7 @@ func (r *UserRepository) GetAllUsers(pageNum, pageSize int) ([]User, error) {
 
 	// Check for errors from iterating over rows
 	if err := rows.Err(); err != nil {
-		r.logger.Error("Row iteration error", "error", err)
+		r.logError(ctx, "Row iteration error", err)
 		return nil, err
 	}
 
@@ -87,37 +166,63 @@ func (r *UserRepository) GetAllUsers(pageNum, pageSize int) ([]User, error) {
 	return users, nil
 }
 
-// GetUserByID retrieves a specific user by ID
-func (r *UserRepository) GetUserByID(id int) (User, error) {
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// GetUsersAfter retrieves the page of users that follows the cursor in
+// (created_at DESC, id DESC) order. Unlike offset pagination this stays fast
+// for deep pages and is unaffected by rows inserted during a scan.
+func (r *UserRepository) GetUsersAfter(ctx context.Context, cursor UserCursor, pageSize int, includeDeleted bool) ([]User, error) {
+	// Create context with timeout for database operations
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.List)
 	defer cancel()
 
-	var user User
-	var err error
+	// Row values compare (created_at, id) lexicographically, matching the sort order
+	rows, err := r.stmts.query(ctx, `
+		SELECT `+userColumns+`
+		FROM users
+		WHERE (created_at, id) < (?, ?)
+		`+activeUsersFilter("AND", includeDeleted)+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, cursor.CreatedAt, cursor.ID, pageSize)
+	if err != nil {
+		r.logError(ctx, "Database query failed", err, "cursor", cursor, "pageSize", pageSize)
+		return nil, err
+	}
+	defer rows.Close()
 
-	// Use appropriate SQL syntax based on database type
-	if r.dbType == "sqlite" {
-		err = r.db.QueryRowContext(ctx, `
-			SELECT id, name, created_at, updated_at
-			FROM users
-			WHERE id = ?
-		`, id).Scan(&user.ID, &user.Name, &user.CreatedAt, &user.UpdatedAt)
-	} else {
-		// Default to PostgreSQL syntax
-		err = r.db.QueryRowContext(ctx, `
-			SELECT id, name, created_at, updated_at
-			FROM users
-			WHERE id = $1
-		`, id).Scan(&user.ID, &user.Name, &user.CreatedAt, &user.UpdatedAt)
+	// Parse results
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			r.logError(ctx, "Row scan failed", err)
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	// Check for errors from iterating over rows
+	if err := rows.Err(); err != nil {
+		r.logError(ctx, "Row iteration error", err)
+		return nil, err
 	}
 
+	r.logger.Info("Retrieved users after cursor", "count", len(users))
+	return users, nil
+}
+
+// GetUserByID retrieves a specific user by ID, including soft-deleted users
+func (r *UserRepository) GetUserByID(ctx context.Context, id int) (User, error) {
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Get)
+	defer cancel()
+
+	user, err := r.stmts.queryUser(ctx, `SELECT `+userColumns+` FROM users WHERE id = ?`, id)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			r.logger.Info("User not found", "id", id)
 			return User{}, ErrUserNotFound
 		}
-		r.logger.Error("Database query failed", "error", err, "id", id)
+		r.logError(ctx, "Database query failed", err, "id", id)
 		return User{}, err
 	}
 
@@ -125,64 +230,350 @@ func (r *UserRepository) GetUserByID(id int) (User, error) {
 	return user, nil
 }
 
-// CreateUser inserts a new user into the database
-func (r *UserRepository) CreateUser(name string) (User, error) {
+// GetUsersByIDs retrieves the users with the given IDs in a single query,
+// including soft-deleted users. IDs that don't exist are simply absent from
+// the result.
+func (r *UserRepository) GetUsersByIDs(ctx context.Context, ids []int) ([]User, error) {
+	if len(ids) == 0 {
+		return []User{}, nil
+	}
+
 	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.List)
 	defer cancel()
 
-	// Get current timestamp in microseconds
-	now := time.Now().UnixMicro()
-
-	var user User
-	var err error
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
 
-	// Use appropriate SQL syntax based on database type
-	if r.dbType == "sqlite" {
-		// SQLite doesn't support RETURNING, so we need to use two queries
-		result, err := r.db.ExecContext(ctx, `
-			INSERT INTO users (name, created_at, updated_at)
-			VALUES (?, ?, ?)
-		`, name, now, now)
+	// The IN list changes length with every call, so it isn't worth preparing
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	rows, err := r.db.QueryContext(ctx, r.dialect.Rebind(`
+		SELECT `+userColumns+`
+		FROM users
+		WHERE id IN (`+placeholders+`)
+	`), args...)
+	if err != nil {
+		r.logError(ctx, "Database query failed", err, "ids", len(ids))
+		return nil, err
+	}
+	defer rows.Close()
 
+	// Parse results
+	users := make([]User, 0, len(ids))
+	for rows.Next() {
+		user, err := scanUser(rows)
 		if err != nil {
-			r.logger.Error("Failed to create user", "error", err, "name", name)
-			return User{}, err
+			r.logError(ctx, "Row scan failed", err)
+			return nil, err
 		}
+		users = append(users, user)
+	}
 
-		// Get the last inserted ID
-		lastID, err := result.LastInsertId()
-		if err != nil {
-			r.logger.Error("Failed to get last insert ID", "error", err)
-			return User{}, err
-		}
+	// Check for errors from iterating over rows
+	if err := rows.Err(); err != nil {
+		r.logError(ctx, "Row iteration error", err)
+		return nil, err
+	}
+
+	r.logger.Info("Retrieved users by IDs", "requested", len(ids), "found", len(users))
+	return users, nil
+}
 
-		// Fetch the created user
-		err = r.db.QueryRowContext(ctx, `
-			SELECT id, name, created_at, updated_at
-			FROM users
-			WHERE id = ?
-		`, lastID).Scan(&user.ID, &user.Name, &user.CreatedAt, &user.UpdatedAt)
+// maxSearchTerms limits how many words of a search query are used
+const maxSearchTerms = 8
+
+// searchTerms splits a search query into words, dropping punctuation and
+// operators so user input can't change the meaning of the full-text query
+func searchTerms(query string) []string {
+	terms := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+	return terms
+}
+
+// SearchUsers finds users whose name matches every word of the query, ranked
+// by relevance. The last word of a name may be given partially, and matching
+// ignores case and accents.
+func (r *UserRepository) SearchUsers(ctx context.Context, query string, pageNum, pageSize int, includeDeleted bool) ([]User, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return []User{}, nil
+	}
+
+	// Create context with timeout for database operations
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Search)
+	defer cancel()
+
+	// Calculate offset
+	offset := (pageNum - 1) * pageSize
 
+	searchQuery, args, err := r.dialect.SearchUsersQuery(ctx, r.db, terms, includeDeleted)
+	if err != nil {
+		r.logError(ctx, "Failed to build search query", err)
+		return nil, err
+	}
+
+	rows, err := r.stmts.query(ctx, searchQuery, append(args, pageSize, offset)...)
+	if err != nil {
+		r.logError(ctx, "Database query failed", err, "query", query)
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Parse results
+	users := make([]User, 0, pageSize)
+	for rows.Next() {
+		user, err := scanUser(rows)
 		if err != nil {
-			r.logger.Error("Failed to fetch created user", "error", err)
-			return User{}, err
+			r.logError(ctx, "Row scan failed", err)
+			return nil, err
 		}
+		users = append(users, user)
+	}
 
-	} else {
-		// PostgreSQL supports RETURNING
-		err = r.db.QueryRowContext(ctx, `
-			INSERT INTO users (name, created_at, updated_at)
-			VALUES ($1, $2, $3)
-			RETURNING id, name, created_at, updated_at
-		`, name, now, now).Scan(&user.ID, &user.Name, &user.CreatedAt, &user.UpdatedAt)
+	// Check for errors from iterating over rows
+	if err := rows.Err(); err != nil {
+		r.logError(ctx, "Row iteration error", err)
+		return nil, err
 	}
 
+	r.logger.Info("Searched users", "query", query, "count", len(users))
+	return users, nil
+}
+
+// CreateUser inserts a new user into the database
+func (r *UserRepository) CreateUser(ctx context.Context, name string) (User, error) {
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Create)
+	defer cancel()
+
+	// Get current timestamp in microseconds
+	now := time.Now().UnixMicro()
+
+	user, err := r.insertUser(ctx, []string{"name", "created_at", "updated_at"}, name, now, now)
 	if err != nil {
-		r.logger.Error("Failed to create user", "error", err, "name", name)
+		r.logError(ctx, "Failed to create user", err, "name", name)
 		return User{}, err
 	}
 
 	r.logger.Info("Created user", "id", user.ID, "name", user.Name)
 	return user, nil
 }
+
+// insertUser inserts a row into users and returns it as stored. Dialects
+// without INSERT ... RETURNING read the row back by its last insert ID.
+func (r *UserRepository) insertUser(ctx context.Context, columns []string, values ...any) (User, error) {
+	insert := `INSERT INTO users (` + strings.Join(columns, ", ") + `) VALUES (` +
+		strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + `)`
+
+	if r.dialect.SupportsReturning() {
+		return r.stmts.queryUser(ctx, insert+` RETURNING `+userColumns, values...)
+	}
+
+	result, err := r.stmts.exec(ctx, insert, values...)
+	if err != nil {
+		return User{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return User{}, fmt.Errorf("get last insert ID: %w", err)
+	}
+	return r.stmts.queryUser(ctx, `SELECT `+userColumns+` FROM users WHERE id = ?`, id)
+}
+
+// UpdateUser changes a user's name. If ifUpdatedAt is non-zero the update only
+// succeeds while the stored updated_at still matches it, otherwise
+// ErrPreconditionFailed is returned.
+func (r *UserRepository) UpdateUser(ctx context.Context, id int, name string, ifUpdatedAt int64) (User, error) {
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Update)
+	defer cancel()
+
+	current, err := r.GetUserByID(ctx, id)
+	if err != nil {
+		return User{}, err
+	}
+
+	if current.DeletedAt != nil {
+		return User{}, ErrUserGone
+	}
+
+	if ifUpdatedAt != 0 && current.UpdatedAt != ifUpdatedAt {
+		r.logger.Info("Stale user update rejected", "id", id, "expected", ifUpdatedAt, "actual", current.UpdatedAt)
+		return User{}, ErrPreconditionFailed
+	}
+
+	now := nextVersion(current.UpdatedAt)
+
+	// Compare-and-swap on updated_at so concurrent writers cannot overwrite each other
+	result, err := r.stmts.exec(ctx, `
+		UPDATE users
+		SET name = ?, updated_at = ?
+		WHERE id = ? AND updated_at = ? AND deleted_at IS NULL
+	`, name, now, id, current.UpdatedAt)
+	if err != nil {
+		r.logError(ctx, "Failed to update user", err, "id", id)
+		return User{}, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		r.logError(ctx, "Failed to get affected rows", err, "id", id)
+		return User{}, err
+	}
+	if affected == 0 {
+		r.logger.Info("Concurrent user update rejected", "id", id)
+		return User{}, ErrPreconditionFailed
+	}
+
+	current.Name = name
+	current.UpdatedAt = now
+
+	r.logger.Info("Updated user", "id", id, "name", name)
+	return current, nil
+}
+
+// DeleteUser soft-deletes a user by setting its deleted_at tombstone.
+// Deleting an already deleted user is a no-op.
+func (r *UserRepository) DeleteUser(ctx context.Context, id int) (User, error) {
+	return r.setDeleted(ctx, id, true)
+}
+
+// RestoreUser clears a user's deleted_at tombstone.
+// Restoring a user that isn't deleted is a no-op.
+func (r *UserRepository) RestoreUser(ctx context.Context, id int) (User, error) {
+	return r.setDeleted(ctx, id, false)
+}
+
+// setDeleted sets or clears the deleted_at column, bumping updated_at when the
+// state changes. The UPDATE is conditioned on the deletion state rather than
+// the version read beforehand, so a concurrent PATCH can't make it miss: the
+// user always ends up in the requested state.
+func (r *UserRepository) setDeleted(ctx context.Context, id int, deleted bool) (User, error) {
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Delete)
+	defer cancel()
+
+	current, err := r.GetUserByID(ctx, id)
+	if err != nil {
+		return User{}, err
+	}
+
+	// Nothing to do if the user is already in the requested state
+	if (current.DeletedAt != nil) == deleted {
+		return current, nil
+	}
+
+	// The new version must stay ahead of whatever updated_at is by the time
+	// the UPDATE runs, so it is worked out in SQL from the stored value
+	now := nextVersion(current.UpdatedAt)
+	query := `
+		UPDATE users
+		SET updated_at = CASE WHEN updated_at < ? THEN ? ELSE updated_at + 1 END,
+		    deleted_at = CASE WHEN updated_at < ? THEN ? ELSE updated_at + 1 END
+		WHERE id = ? AND deleted_at IS NULL
+	`
+	args := []any{now, now, now, now, id}
+	if !deleted {
+		query = `
+			UPDATE users
+			SET updated_at = CASE WHEN updated_at < ? THEN ? ELSE updated_at + 1 END,
+			    deleted_at = NULL
+			WHERE id = ? AND deleted_at IS NOT NULL
+		`
+		args = []any{now, now, id}
+	}
+
+	result, err := r.stmts.exec(ctx, query, args...)
+	if err != nil {
+		r.logError(ctx, "Failed to change user deletion state", err, "id", id)
+		return User{}, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		r.logError(ctx, "Failed to get affected rows", err, "id", id)
+		return User{}, err
+	}
+	if affected > 0 {
+		r.logger.Info("Changed user deletion state", "id", id, "deleted", deleted)
+	}
+
+	// Read the row back: a concurrent PATCH may have changed the name, and
+	// if no row matched another request already made the same change
+	return r.GetUserByID(ctx, id)
+}
+
+// ImportUsers stores a batch of users, with their timestamps and deletion
+// state as given, in a single transaction. With preserveIDs the users keep
+// their IDs; a user whose ID is already taken is skipped and its ID returned
+// in conflicts. Otherwise new IDs are assigned in order.
+func (r *UserRepository) ImportUsers(ctx context.Context, users []User, preserveIDs bool) (int, []int, error) {
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Import)
+	defer cancel()
+
+	query := `INSERT INTO users (name, created_at, updated_at, deleted_at) VALUES (?, ?, ?, ?)`
+	if preserveIDs {
+		query = r.dialect.Upsert("users", []string{"id", "name", "created_at", "updated_at", "deleted_at"}, []string{"id"}, nil)
+	}
+	stmt, err := r.stmts.prepare(ctx, query)
+	if err != nil {
+		r.logError(ctx, "Failed to prepare user import", err)
+		return 0, nil, err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		r.logError(ctx, "Failed to begin user import", err)
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	txStmt := tx.StmtContext(ctx, stmt)
+	imported := 0
+	conflicts := []int{}
+	for _, user := range users {
+		args := []any{user.Name, user.CreatedAt, user.UpdatedAt, user.DeletedAt}
+		if preserveIDs {
+			args = append([]any{user.ID}, args...)
+		}
+
+		result, err := txStmt.ExecContext(ctx, args...)
+		if err != nil {
+			r.logError(ctx, "Failed to import user", err, "id", user.ID)
+			return 0, nil, err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			r.logError(ctx, "Failed to get affected rows", err, "id", user.ID)
+			return 0, nil, err
+		}
+		if affected == 0 {
+			conflicts = append(conflicts, user.ID)
+			continue
+		}
+		imported++
+	}
+
+	// Explicit IDs bypass the ID sequence on some databases; move it past them
+	if sync := r.dialect.SyncIDSequence("users", "id"); preserveIDs && sync != "" {
+		if _, err := tx.ExecContext(ctx, sync); err != nil {
+			r.logError(ctx, "Failed to advance user ID sequence", err)
+			return 0, nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		r.logError(ctx, "Failed to commit user import", err)
+		return 0, nil, err
+	}
+
+	r.logger.Info("Imported users", "imported", imported, "conflicts", len(conflicts), "preserve_ids", preserveIDs)
+	return imported, conflicts, nil
+}
@@ -0,0 +1,864 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// repositoryBackend opens an empty repository for one conformance test
+type repositoryBackend struct {
+	name string
+	open func(t *testing.T) UserRepositoryInterface
+}
+
+// repositoryBackends are the UserRepositoryInterface implementations that must
+// behave identically. Postgres only runs when TEST_POSTGRES_DSN is set.
+var repositoryBackends = []repositoryBackend{
+	{name: "memory", open: func(t *testing.T) UserRepositoryInterface {
+		return NewMemoryUserRepository()
+	}},
+	{name: "sqlite", open: func(t *testing.T) UserRepositoryInterface {
+		db := newTestSQLiteDB(t)
+		migrateTestDB(t, db, &sqliteDialect{})
+		return NewUserRepository(db, discardLogger(), &sqliteDialect{}, DefaultQueryTimeouts())
+	}},
+	{name: "postgres", open: func(t *testing.T) UserRepositoryInterface {
+		db := newTestPostgresDB(t)
+		migrateTestDB(t, db, postgresDialect{})
+		if _, err := db.Exec(`TRUNCATE users RESTART IDENTITY`); err != nil {
+			t.Fatalf("Failed to empty users table: %v", err)
+		}
+		return NewUserRepository(db, discardLogger(), postgresDialect{}, DefaultQueryTimeouts())
+	}},
+}
+
+// newTestPostgresDB connects to the database in TEST_POSTGRES_DSN, skipping the test if it isn't set
+func newTestPostgresDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open PostgreSQL database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Ping(); err != nil {
+		t.Fatalf("Failed to connect to PostgreSQL: %v", err)
+	}
+	return db
+}
+
+// migrateTestDB applies all migrations to a test database
+func migrateTestDB(t *testing.T, db *sql.DB, dialect Dialect) {
+	t.Helper()
+
+	if _, err := NewMigrator(db, discardLogger(), dialect).Up(context.Background()); err != nil {
+		t.Fatalf("Failed to migrate %s database: %v", dialect.Name(), err)
+	}
+}
+
+// forEachBackend runs a conformance test against every repository backend
+func forEachBackend(t *testing.T, test func(t *testing.T, repo UserRepositoryInterface)) {
+	for _, backend := range repositoryBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			test(t, backend.open(t))
+		})
+	}
+}
+
+// createTestUsers creates users with the given names in order
+func createTestUsers(t *testing.T, repo UserRepositoryInterface, names ...string) []User {
+	t.Helper()
+
+	users := make([]User, len(names))
+	for i, name := range names {
+		user, err := repo.CreateUser(context.Background(), name)
+		if err != nil {
+			t.Fatalf("CreateUser(%q) failed: %v", name, err)
+		}
+		users[i] = user
+	}
+	return users
+}
+
+// userIDs returns the IDs of users, for readable comparisons
+func userIDs(users []User) []int {
+	ids := make([]int, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	return ids
+}
+
+// assertIDs fails the test unless users have exactly the expected IDs in order
+func assertIDs(t *testing.T, users []User, expected ...int) {
+	t.Helper()
+
+	got := userIDs(users)
+	if len(got) != len(expected) {
+		t.Fatalf("Expected IDs %v, got %v", expected, got)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected IDs %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestRepositoryCreateAndGetUser(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo UserRepositoryInterface) {
+		ctx := context.Background()
+		users := createTestUsers(t, repo, "Alice", "Bob")
+
+		// IDs start at 1 and increase by one
+		assertIDs(t, users, 1, 2)
+
+		alice := users[0]
+		if alice.Name != "Alice" || alice.CreatedAt == 0 || alice.UpdatedAt != alice.CreatedAt || alice.DeletedAt != nil {
+			t.Errorf("Unexpected created user: %+v", alice)
+		}
+		if users[1].CreatedAt < alice.CreatedAt {
+			t.Errorf("Expected created_at to be non-decreasing, got %d then %d", alice.CreatedAt, users[1].CreatedAt)
+		}
+
+		got, err := repo.GetUserByID(ctx, alice.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID failed: %v", err)
+		}
+		if got.ID != alice.ID || got.Name != alice.Name || got.CreatedAt != alice.CreatedAt || got.UpdatedAt != alice.UpdatedAt {
+			t.Errorf("Expected %+v, got %+v", alice, got)
+		}
+
+		if _, err := repo.GetUserByID(ctx, 999); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+	})
+}
+
+func TestRepositoryListUsers(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo UserRepositoryInterface) {
+		ctx := context.Background()
+
+		users, err := repo.GetAllUsers(ctx, 1, 10, false)
+		if err != nil {
+			t.Fatalf("GetAllUsers failed: %v", err)
+		}
+		if users == nil || len(users) != 0 {
+			t.Errorf("Expected an empty, non-nil list, got %#v", users)
+		}
+
+		createTestUsers(t, repo, "A", "B", "C", "D", "E")
+
+		// Newest first
+		users, err = repo.GetAllUsers(ctx, 1, 2, false)
+		if err != nil {
+			t.Fatalf("GetAllUsers failed: %v", err)
+		}
+		assertIDs(t, users, 5, 4)
+
+		users, err = repo.GetAllUsers(ctx, 3, 2, false)
+		if err != nil {
+			t.Fatalf("GetAllUsers failed: %v", err)
+		}
+		assertIDs(t, users, 1)
+
+		// Deleted users are hidden unless requested
+		if _, err := repo.DeleteUser(ctx, 4); err != nil {
+			t.Fatalf("DeleteUser failed: %v", err)
+		}
+		users, err = repo.GetAllUsers(ctx, 1, 10, false)
+		if err != nil {
+			t.Fatalf("GetAllUsers failed: %v", err)
+		}
+		assertIDs(t, users, 5, 3, 2, 1)
+
+		users, err = repo.GetAllUsers(ctx, 1, 10, true)
+		if err != nil {
+			t.Fatalf("GetAllUsers failed: %v", err)
+		}
+		assertIDs(t, users, 5, 4, 3, 2, 1)
+	})
+}
+
+func TestRepositoryGetUsersAfter(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo UserRepositoryInterface) {
+		ctx := context.Background()
+		created := createTestUsers(t, repo, "A", "B", "C", "D", "E")
+
+		users, err := repo.GetUsersAfter(ctx, cursorFor(created[3]), 2, false)
+		if err != nil {
+			t.Fatalf("GetUsersAfter failed: %v", err)
+		}
+		assertIDs(t, users, 3, 2)
+
+		// Deleted users are skipped unless requested
+		if _, err := repo.DeleteUser(ctx, 2); err != nil {
+			t.Fatalf("DeleteUser failed: %v", err)
+		}
+		users, err = repo.GetUsersAfter(ctx, cursorFor(created[3]), 2, false)
+		if err != nil {
+			t.Fatalf("GetUsersAfter failed: %v", err)
+		}
+		assertIDs(t, users, 3, 1)
+
+		users, err = repo.GetUsersAfter(ctx, cursorFor(created[3]), 2, true)
+		if err != nil {
+			t.Fatalf("GetUsersAfter failed: %v", err)
+		}
+		assertIDs(t, users, 3, 2)
+
+		users, err = repo.GetUsersAfter(ctx, cursorFor(created[0]), 2, true)
+		if err != nil {
+			t.Fatalf("GetUsersAfter failed: %v", err)
+		}
+		if users == nil || len(users) != 0 {
+			t.Errorf("Expected an empty, non-nil list after the last user, got %#v", users)
+		}
+	})
+}
+
+func TestRepositoryGetUsersByIDs(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo UserRepositoryInterface) {
+		ctx := context.Background()
+		createTestUsers(t, repo, "A", "B", "C")
+		if _, err := repo.DeleteUser(ctx, 2); err != nil {
+			t.Fatalf("DeleteUser failed: %v", err)
+		}
+
+		users, err := repo.GetUsersByIDs(ctx, []int{3, 2, 99})
+		if err != nil {
+			t.Fatalf("GetUsersByIDs failed: %v", err)
+		}
+
+		// Order isn't part of the contract; the service reorders the result
+		found := map[int]User{}
+		for _, user := range users {
+			found[user.ID] = user
+		}
+		if len(found) != 2 || found[3].Name != "C" || found[2].DeletedAt == nil {
+			t.Errorf("Expected users 3 and deleted user 2, got %+v", users)
+		}
+
+		users, err = repo.GetUsersByIDs(ctx, []int{})
+		if err != nil || len(users) != 0 {
+			t.Errorf("Expected no users for no IDs, got %v, %v", users, err)
+		}
+	})
+}
+
+func TestRepositoryUpdateUser(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo UserRepositoryInterface) {
+		ctx := context.Background()
+		user := createTestUsers(t, repo, "Alice")[0]
+
+		updated, err := repo.UpdateUser(ctx, user.ID, "Alicia", user.UpdatedAt)
+		if err != nil {
+			t.Fatalf("UpdateUser failed: %v", err)
+		}
+		if updated.Name != "Alicia" || updated.UpdatedAt <= user.UpdatedAt || updated.CreatedAt != user.CreatedAt {
+			t.Errorf("Unexpected updated user: %+v", updated)
+		}
+
+		stored, err := repo.GetUserByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID failed: %v", err)
+		}
+		if stored.Name != "Alicia" || stored.UpdatedAt != updated.UpdatedAt {
+			t.Errorf("Expected stored user to match %+v, got %+v", updated, stored)
+		}
+
+		// The old version is now stale
+		if _, err := repo.UpdateUser(ctx, user.ID, "Ally", user.UpdatedAt); !errors.Is(err, ErrPreconditionFailed) {
+			t.Errorf("Expected ErrPreconditionFailed for stale version, got %v", err)
+		}
+
+		// Zero skips the version check
+		if _, err := repo.UpdateUser(ctx, user.ID, "Ally", 0); err != nil {
+			t.Errorf("Unconditional UpdateUser failed: %v", err)
+		}
+
+		if _, err := repo.UpdateUser(ctx, 999, "Nobody", 0); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+
+		if _, err := repo.DeleteUser(ctx, user.ID); err != nil {
+			t.Fatalf("DeleteUser failed: %v", err)
+		}
+		if _, err := repo.UpdateUser(ctx, user.ID, "Ghost", 0); !errors.Is(err, ErrUserGone) {
+			t.Errorf("Expected ErrUserGone for deleted user, got %v", err)
+		}
+	})
+}
+
+func TestRepositoryDeleteAndRestoreUser(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo UserRepositoryInterface) {
+		ctx := context.Background()
+		user := createTestUsers(t, repo, "Alice")[0]
+
+		deleted, err := repo.DeleteUser(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("DeleteUser failed: %v", err)
+		}
+		if deleted.DeletedAt == nil || *deleted.DeletedAt != deleted.UpdatedAt || deleted.UpdatedAt <= user.UpdatedAt {
+			t.Errorf("Unexpected deleted user: %+v", deleted)
+		}
+
+		// Deleting again is a no-op
+		again, err := repo.DeleteUser(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("Second DeleteUser failed: %v", err)
+		}
+		if again.UpdatedAt != deleted.UpdatedAt || again.DeletedAt == nil || *again.DeletedAt != *deleted.DeletedAt {
+			t.Errorf("Expected second delete to be a no-op, got %+v", again)
+		}
+
+		restored, err := repo.RestoreUser(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("RestoreUser failed: %v", err)
+		}
+		if restored.DeletedAt != nil || restored.UpdatedAt <= deleted.UpdatedAt {
+			t.Errorf("Unexpected restored user: %+v", restored)
+		}
+
+		stored, err := repo.GetUserByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID failed: %v", err)
+		}
+		if stored.DeletedAt != nil || stored.UpdatedAt != restored.UpdatedAt {
+			t.Errorf("Expected stored user to match %+v, got %+v", restored, stored)
+		}
+
+		if _, err := repo.DeleteUser(ctx, 999); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+		if _, err := repo.RestoreUser(ctx, 999); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+	})
+}
+
+func TestRepositorySearchUsers(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo UserRepositoryInterface) {
+		ctx := context.Background()
+		createTestUsers(t, repo, "José Álvarez", "Jose Smith", "Joseph Alvarez-Long", "Mary Jones")
+
+		tests := []struct {
+			query    string
+			expected []int
+		}{
+			{query: "jose alvarez", expected: []int{1, 3}},
+			{query: "ÁLVAR", expected: []int{1, 3}},
+			{query: "smith", expected: []int{2}},
+			{query: "nobody", expected: []int{}},
+			{query: "!!", expected: []int{}},
+		}
+
+		for _, tc := range tests {
+			users, err := repo.SearchUsers(ctx, tc.query, 1, 10, false)
+			if err != nil {
+				t.Fatalf("SearchUsers(%q) failed: %v", tc.query, err)
+			}
+
+			got := map[int]bool{}
+			for _, user := range users {
+				got[user.ID] = true
+			}
+			if len(got) != len(tc.expected) {
+				t.Errorf("SearchUsers(%q): expected IDs %v, got %v", tc.query, tc.expected, userIDs(users))
+				continue
+			}
+			for _, id := range tc.expected {
+				if !got[id] {
+					t.Errorf("SearchUsers(%q): expected IDs %v, got %v", tc.query, tc.expected, userIDs(users))
+				}
+			}
+		}
+
+		// Deleted users are hidden unless requested
+		if _, err := repo.DeleteUser(ctx, 2); err != nil {
+			t.Fatalf("DeleteUser failed: %v", err)
+		}
+		users, err := repo.SearchUsers(ctx, "smith", 1, 10, false)
+		if err != nil || len(users) != 0 {
+			t.Errorf("Expected deleted user to be hidden, got %v, %v", userIDs(users), err)
+		}
+		users, err = repo.SearchUsers(ctx, "smith", 1, 10, true)
+		if err != nil || len(users) != 1 {
+			t.Errorf("Expected deleted user with includeDeleted, got %v, %v", userIDs(users), err)
+		}
+	})
+}
+
+func TestRepositoryImportUsers(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo UserRepositoryInterface) {
+		ctx := context.Background()
+		createTestUsers(t, repo, "Existing")
+		deletedAt := int64(150)
+
+		// Preserved IDs may leave gaps; a taken ID is reported, not overwritten
+		imported, conflicts, err := repo.ImportUsers(ctx, []User{
+			{ID: 5, Name: "Five", CreatedAt: 100, UpdatedAt: 120},
+			{ID: 1, Name: "Clash", CreatedAt: 100, UpdatedAt: 100},
+			{ID: 3, Name: "Three", CreatedAt: 110, UpdatedAt: 150, DeletedAt: &deletedAt},
+		}, true)
+		if err != nil {
+			t.Fatalf("ImportUsers failed: %v", err)
+		}
+		if imported != 2 || !slices.Equal(conflicts, []int{1}) {
+			t.Errorf("Expected 2 imported and conflict [1], got %d and %v", imported, conflicts)
+		}
+
+		five, err := repo.GetUserByID(ctx, 5)
+		if err != nil {
+			t.Fatalf("GetUserByID(5) failed: %v", err)
+		}
+		if five.Name != "Five" || five.CreatedAt != 100 || five.UpdatedAt != 120 || five.DeletedAt != nil {
+			t.Errorf("Expected user 5 stored as given, got %+v", five)
+		}
+		three, err := repo.GetUserByID(ctx, 3)
+		if err != nil {
+			t.Fatalf("GetUserByID(3) failed: %v", err)
+		}
+		if three.DeletedAt == nil || *three.DeletedAt != deletedAt {
+			t.Errorf("Expected user 3 to stay deleted at %d, got %+v", deletedAt, three)
+		}
+		if existing, _ := repo.GetUserByID(ctx, 1); existing.Name != "Existing" {
+			t.Errorf("Expected user 1 untouched, got %+v", existing)
+		}
+		if _, err := repo.GetUserByID(ctx, 2); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected the gap at ID 2 to stay empty, got %v", err)
+		}
+
+		// New users continue after the largest imported ID
+		created := createTestUsers(t, repo, "After")
+		assertIDs(t, created, 6)
+
+		// Without preserveIDs every user gets a new ID
+		imported, conflicts, err = repo.ImportUsers(ctx, []User{
+			{ID: 1, Name: "Fresh", CreatedAt: 100, UpdatedAt: 100},
+		}, false)
+		if err != nil || imported != 1 || len(conflicts) != 0 {
+			t.Fatalf("Expected 1 imported without conflicts, got %d, %v, %v", imported, conflicts, err)
+		}
+		if fresh, err := repo.GetUserByID(ctx, 7); err != nil || fresh.Name != "Fresh" {
+			t.Errorf("Expected Fresh to get ID 7, got %+v, %v", fresh, err)
+		}
+	})
+}
+
+// insertTestUser stores a user with fixed ID and timestamps, bypassing
+// CreateUser so tests can set up ties in created_at
+func insertTestUser(t *testing.T, repo UserRepositoryInterface, user User) {
+	t.Helper()
+
+	switch repo := repo.(type) {
+	case *MemoryUserRepository:
+		repo.mu.Lock()
+		defer repo.mu.Unlock()
+		if user.ID != repo.lastID+1 {
+			t.Fatalf("Memory test users must be inserted in ID order, got ID %d after %d", user.ID, repo.lastID)
+		}
+		repo.users = append(repo.users, user)
+		repo.lastID = user.ID
+	case *UserRepository:
+		query := repo.dialect.Rebind(`INSERT INTO users (id, name, created_at, updated_at, deleted_at) VALUES (?, ?, ?, ?, ?)`)
+		if _, err := repo.db.Exec(query, user.ID, user.Name, user.CreatedAt, user.UpdatedAt, user.DeletedAt); err != nil {
+			t.Fatalf("Failed to insert user %d: %v", user.ID, err)
+		}
+		// Keep the sequence ahead of explicitly inserted IDs
+		if sync := repo.dialect.SyncIDSequence("users", "id"); sync != "" {
+			if _, err := repo.db.Exec(sync); err != nil {
+				t.Fatalf("Failed to advance the users ID sequence: %v", err)
+			}
+		}
+	default:
+		t.Fatalf("Don't know how to insert into %T", repo)
+	}
+}
+
+// insertTiedUsers stores five users where 2, 3 and 4 share created_at
+func insertTiedUsers(t *testing.T, repo UserRepositoryInterface) {
+	t.Helper()
+
+	for _, user := range []User{
+		{ID: 1, Name: "Ann One", CreatedAt: 100},
+		{ID: 2, Name: "Ann Two", CreatedAt: 200},
+		{ID: 3, Name: "Ann Six", CreatedAt: 200},
+		{ID: 4, Name: "Ann Ten", CreatedAt: 200},
+		{ID: 5, Name: "Ann Big", CreatedAt: 300},
+	} {
+		user.UpdatedAt = user.CreatedAt
+		insertTestUser(t, repo, user)
+	}
+}
+
+func TestRepositoryPaginationBoundaries(t *testing.T) {
+	tests := []struct {
+		name     string
+		pageNum  int
+		pageSize int
+		expected []int
+	}{
+		{name: "first page", pageNum: 1, pageSize: 2, expected: []int{5, 4}},
+		{name: "middle page", pageNum: 2, pageSize: 2, expected: []int{3, 2}},
+		{name: "partial last page", pageNum: 3, pageSize: 2, expected: []int{1}},
+		{name: "page past the end", pageNum: 4, pageSize: 2, expected: []int{}},
+		{name: "page size equals total", pageNum: 1, pageSize: 5, expected: []int{5, 4, 3, 2, 1}},
+		{name: "page after exact fit", pageNum: 2, pageSize: 5, expected: []int{}},
+		{name: "page size larger than total", pageNum: 1, pageSize: 100, expected: []int{5, 4, 3, 2, 1}},
+		{name: "single user pages", pageNum: 5, pageSize: 1, expected: []int{1}},
+	}
+
+	forEachBackend(t, func(t *testing.T, repo UserRepositoryInterface) {
+		createTestUsers(t, repo, "A", "B", "C", "D", "E")
+
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				users, err := repo.GetAllUsers(context.Background(), tc.pageNum, tc.pageSize, false)
+				if err != nil {
+					t.Fatalf("GetAllUsers failed: %v", err)
+				}
+				assertIDs(t, users, tc.expected...)
+			})
+		}
+	})
+}
+
+func TestRepositoryCursorWalk(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repo UserRepositoryInterface) {
+		ctx := context.Background()
+		createTestUsers(t, repo, "A", "B", "C", "D", "E")
+
+		// Walking with any page size visits every user exactly once, in order
+		for _, pageSize := range []int{1, 2, 4, 5, 6} {
+			users, err := repo.GetAllUsers(ctx, 1, pageSize, false)
+			if err != nil {
+				t.Fatalf("GetAllUsers failed: %v", err)
+			}
+			seen := userIDs(users)
+
+			for len(users) == pageSize {
+				users, err = repo.GetUsersAfter(ctx, cursorFor(users[len(users)-1]), pageSize, false)
+				if err != nil {
+					t.Fatalf("GetUsersAfter failed: %v", err)
+				}
+				seen = append(seen, userIDs(users)...)
+			}
+
+			expected := []int{5, 4, 3, 2, 1}
+			if len(seen) != len(expected) {
+				t.Fatalf("Page size %d: expected IDs %v, got %v", pageSize, expected, seen)
+			}
+			for i := range seen {
+				if seen[i] != expected[i] {
+					t.Fatalf("Page size %d: expected IDs %v, got %v", pageSize, expected, seen)
+				}
+			}
+		}
+	})
+}
+
+func TestRepositoryOrderingTies(t *testing.T) {
+	tests := []struct {
+		name     string
+		cursor   *UserCursor
+		pageSize int
+		expected []int
+	}{
+		{name: "ties broken by id", pageSize: 10, expected: []int{5, 4, 3, 2, 1}},
+		{name: "cursor inside a tie", cursor: &UserCursor{CreatedAt: 200, ID: 4}, pageSize: 10, expected: []int{3, 2, 1}},
+		{name: "page boundary inside a tie", cursor: &UserCursor{CreatedAt: 200, ID: 3}, pageSize: 1, expected: []int{2}},
+		{name: "cursor at last of a tie", cursor: &UserCursor{CreatedAt: 200, ID: 2}, pageSize: 10, expected: []int{1}},
+		{name: "cursor between existing users", cursor: &UserCursor{CreatedAt: 250, ID: 0}, pageSize: 10, expected: []int{4, 3, 2, 1}},
+	}
+
+	forEachBackend(t, func(t *testing.T, repo UserRepositoryInterface) {
+		insertTiedUsers(t, repo)
+
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				var users []User
+				var err error
+				if tc.cursor == nil {
+					users, err = repo.GetAllUsers(context.Background(), 1, tc.pageSize, false)
+				} else {
+					users, err = repo.GetUsersAfter(context.Background(), *tc.cursor, tc.pageSize, false)
+				}
+				if err != nil {
+					t.Fatalf("Listing users failed: %v", err)
+				}
+				assertIDs(t, users, tc.expected...)
+			})
+		}
+
+		// Search results of equal relevance fall back to the same order
+		users, err := repo.SearchUsers(context.Background(), "ann", 1, 10, false)
+		if err != nil {
+			t.Fatalf("SearchUsers failed: %v", err)
+		}
+		assertIDs(t, users, 5, 4, 3, 2, 1)
+	})
+}
+
+func TestRepositoryNotFound(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(repo UserRepositoryInterface, id int) error
+	}{
+		{name: "GetUserByID", call: func(repo UserRepositoryInterface, id int) error {
+			_, err := repo.GetUserByID(context.Background(), id)
+			return err
+		}},
+		{name: "UpdateUser", call: func(repo UserRepositoryInterface, id int) error {
+			_, err := repo.UpdateUser(context.Background(), id, "Nobody", 0)
+			return err
+		}},
+		{name: "DeleteUser", call: func(repo UserRepositoryInterface, id int) error {
+			_, err := repo.DeleteUser(context.Background(), id)
+			return err
+		}},
+		{name: "RestoreUser", call: func(repo UserRepositoryInterface, id int) error {
+			_, err := repo.RestoreUser(context.Background(), id)
+			return err
+		}},
+	}
+
+	forEachBackend(t, func(t *testing.T, repo UserRepositoryInterface) {
+		createTestUsers(t, repo, "A")
+
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				for _, id := range []int{0, -1, 2, 1 << 30} {
+					if err := tc.call(repo, id); !errors.Is(err, ErrUserNotFound) {
+						t.Errorf("ID %d: expected ErrUserNotFound, got %v", id, err)
+					}
+				}
+			})
+		}
+
+		users, err := repo.GetUsersByIDs(context.Background(), []int{2, 3})
+		if err != nil || len(users) != 0 {
+			t.Errorf("Expected no users for unknown IDs, got %v, %v", userIDs(users), err)
+		}
+	})
+}
+
+func TestRepositoryContextErrors(t *testing.T) {
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		expected error
+	}{
+		{name: "deadline exceeded", ctx: expired, expected: context.DeadlineExceeded},
+		{name: "cancelled", ctx: cancelled, expected: context.Canceled},
+	}
+
+	forEachBackend(t, func(t *testing.T, repo UserRepositoryInterface) {
+		createTestUsers(t, repo, "Alice")
+
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				calls := map[string]func() error{
+					"GetAllUsers": func() error { _, err := repo.GetAllUsers(tc.ctx, 1, 10, false); return err },
+					"GetUsersAfter": func() error {
+						_, err := repo.GetUsersAfter(tc.ctx, UserCursor{CreatedAt: 1 << 62}, 10, false)
+						return err
+					},
+					"GetUserByID":   func() error { _, err := repo.GetUserByID(tc.ctx, 1); return err },
+					"GetUsersByIDs": func() error { _, err := repo.GetUsersByIDs(tc.ctx, []int{1}); return err },
+					"SearchUsers":   func() error { _, err := repo.SearchUsers(tc.ctx, "alice", 1, 10, false); return err },
+					"CreateUser":    func() error { _, err := repo.CreateUser(tc.ctx, "Bob"); return err },
+					"UpdateUser":    func() error { _, err := repo.UpdateUser(tc.ctx, 1, "Alicia", 0); return err },
+					"DeleteUser":    func() error { _, err := repo.DeleteUser(tc.ctx, 1); return err },
+					"RestoreUser":   func() error { _, err := repo.RestoreUser(tc.ctx, 1); return err },
+				}
+				for method, call := range calls {
+					if err := call(); !errors.Is(err, tc.expected) {
+						t.Errorf("%s: expected %v, got %v", method, tc.expected, err)
+					}
+				}
+			})
+		}
+
+		// Nothing was changed by the failed calls
+		users, err := repo.GetAllUsers(context.Background(), 1, 10, true)
+		if err != nil {
+			t.Fatalf("GetAllUsers failed: %v", err)
+		}
+		if len(users) != 1 || users[0].Name != "Alice" || users[0].DeletedAt != nil {
+			t.Errorf("Expected only the unchanged original user, got %+v", users)
+		}
+	})
+}
+
+func TestUserRepositoryQueryTimeouts(t *testing.T) {
+	db := newTestSQLiteDB(t)
+	migrateTestDB(t, db, &sqliteDialect{})
+
+	// A timeout this short expires before any query can run
+	timeouts := QueryTimeouts{List: time.Nanosecond, Get: time.Nanosecond, Search: time.Nanosecond,
+		Create: time.Nanosecond, Update: time.Nanosecond, Delete: time.Nanosecond, Import: time.Nanosecond}
+	repo := NewUserRepository(db, discardLogger(), &sqliteDialect{}, timeouts)
+	ctx := context.Background()
+
+	if _, err := repo.GetAllUsers(ctx, 1, 10, false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetAllUsers: expected DeadlineExceeded, got %v", err)
+	}
+	if _, err := repo.GetUserByID(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetUserByID: expected DeadlineExceeded, got %v", err)
+	}
+	if _, err := repo.SearchUsers(ctx, "alice", 1, 10, false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SearchUsers: expected DeadlineExceeded, got %v", err)
+	}
+	if _, err := repo.CreateUser(ctx, "Alice"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("CreateUser: expected DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestRepositoryConcurrentInserts(t *testing.T) {
+	const workers = 10
+	const perWorker = 10
+
+	forEachBackend(t, func(t *testing.T, repo UserRepositoryInterface) {
+		ctx := context.Background()
+
+		var wg sync.WaitGroup
+		created := make(chan User, workers*perWorker)
+		errs := make(chan error, workers*perWorker)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < perWorker; i++ {
+					user, err := repo.CreateUser(ctx, fmt.Sprintf("Worker %d User %d", w, i))
+					if err != nil {
+						errs <- err
+						continue
+					}
+					created <- user
+				}
+			}(w)
+		}
+		wg.Wait()
+		close(created)
+		close(errs)
+
+		for err := range errs {
+			t.Fatalf("Concurrent CreateUser failed: %v", err)
+		}
+
+		// Every insert got its own ID, and the returned row is the one that was inserted
+		byID := map[int]User{}
+		for user := range created {
+			if _, dup := byID[user.ID]; dup {
+				t.Fatalf("ID %d was assigned twice", user.ID)
+			}
+			byID[user.ID] = user
+		}
+		for id := 1; id <= workers*perWorker; id++ {
+			user, ok := byID[id]
+			if !ok {
+				t.Fatalf("Expected IDs 1..%d, missing %d", workers*perWorker, id)
+			}
+			stored, err := repo.GetUserByID(ctx, id)
+			if err != nil {
+				t.Fatalf("GetUserByID(%d) failed: %v", id, err)
+			}
+			if stored.Name != user.Name || stored.CreatedAt != user.CreatedAt {
+				t.Errorf("CreateUser returned %+v but %+v was stored", user, stored)
+			}
+		}
+
+		users, err := repo.GetAllUsers(ctx, 1, workers*perWorker+1, false)
+		if err != nil {
+			t.Fatalf("GetAllUsers failed: %v", err)
+		}
+		if len(users) != workers*perWorker {
+			t.Fatalf("Expected %d users, got %d", workers*perWorker, len(users))
+		}
+		for i := 1; i < len(users); i++ {
+			if compareNewestFirst(users[i-1], users[i]) >= 0 {
+				t.Fatalf("Users out of order at %d: %+v before %+v", i, users[i-1], users[i])
+			}
+		}
+	})
+}
+
+func TestRepositoryConcurrentUpdateAndDelete(t *testing.T) {
+	const rounds = 10
+	const updaters = 4
+	const perUpdater = 20
+
+	forEachBackend(t, func(t *testing.T, repo UserRepositoryInterface) {
+		ctx := context.Background()
+
+		for round := 0; round < rounds; round++ {
+			user := createTestUsers(t, repo, fmt.Sprintf("User %d", round))[0]
+
+			// PATCHes keep bumping updated_at while the user is being deleted
+			var wg, running sync.WaitGroup
+			errs := make(chan error, updaters)
+			running.Add(updaters)
+			for w := 0; w < updaters; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < perUpdater; i++ {
+						_, err := repo.UpdateUser(ctx, user.ID, fmt.Sprintf("Worker %d Name %d", w, i), 0)
+						if i == 0 {
+							running.Done()
+						}
+						if errors.Is(err, ErrUserGone) {
+							return
+						}
+						// Losing the race to another writer is expected
+						if err != nil && !errors.Is(err, ErrPreconditionFailed) {
+							errs <- err
+							return
+						}
+					}
+				}(w)
+			}
+			running.Wait()
+
+			deleted, err := repo.DeleteUser(ctx, user.ID)
+			wg.Wait()
+			close(errs)
+			if err != nil {
+				t.Fatalf("DeleteUser failed: %v", err)
+			}
+			for err := range errs {
+				t.Fatalf("Concurrent UpdateUser failed: %v", err)
+			}
+
+			// The delete is never lost, and its response says so
+			if deleted.DeletedAt == nil {
+				t.Fatalf("Round %d: DeleteUser returned a live user: %+v", round, deleted)
+			}
+			stored, err := repo.GetUserByID(ctx, user.ID)
+			if err != nil {
+				t.Fatalf("GetUserByID failed: %v", err)
+			}
+			if stored.DeletedAt == nil || stored.UpdatedAt != deleted.UpdatedAt {
+				t.Fatalf("Round %d: expected stored user to match %+v, got %+v", round, deleted, stored)
+			}
+		}
+	})
+}
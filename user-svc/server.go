@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ServerConfig holds the HTTP server timeouts
+type ServerConfig struct {
+	Port              int
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+}
+
+// getServerConfig reads the HTTP server configuration from environment variables
+func getServerConfig() ServerConfig {
+	return ServerConfig{
+		Port:              getServerPort(),
+		ReadTimeout:       getEnvDuration("SERVER_READ_TIMEOUT", 10*time.Second),
+		ReadHeaderTimeout: getEnvDuration("SERVER_READ_HEADER_TIMEOUT", 5*time.Second),
+		WriteTimeout:      getEnvDuration("SERVER_WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:       getEnvDuration("SERVER_IDLE_TIMEOUT", 60*time.Second),
+		ShutdownTimeout:   getEnvDuration("SHUTDOWN_TIMEOUT", 20*time.Second),
+	}
+}
+
+// requestStats counts requests for the shutdown summary
+type requestStats struct {
+	started  time.Time
+	served   atomic.Int64
+	inFlight atomic.Int64
+}
+
+// middleware counts requests as they start and finish
+func (s *requestStats) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.inFlight.Add(1)
+		defer func() {
+			s.inFlight.Add(-1)
+			s.served.Add(1)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// runServer serves HTTP until ctx is cancelled (e.g. by SIGTERM), then stops
+// accepting connections and waits up to ShutdownTimeout for in-flight requests
+// to finish. It returns an error if the server fails or doesn't drain in time.
+func runServer(ctx context.Context, cfg ServerConfig, handler http.Handler) error {
+	stats := &requestStats{started: time.Now()}
+
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		Handler:           stats.middleware(handler),
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("Server starting", "port", cfg.Port)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	inFlight := stats.inFlight.Load()
+	slog.Info("Shutdown signal received, draining requests", "in_flight", inFlight, "timeout", cfg.ShutdownTimeout.String())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	err := server.Shutdown(shutdownCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		// Drain period is over; cut off whatever is still running
+		server.Close()
+	}
+
+	slog.Info("Server stopped",
+		"uptime", time.Since(stats.started).Round(time.Second).String(),
+		"requests_served", stats.served.Load(),
+		"in_flight_at_signal", inFlight,
+		"abandoned", stats.inFlight.Load(),
+		"drained", err == nil,
+	)
+
+	if err != nil {
+		return fmt.Errorf("graceful shutdown failed: %w", err)
+	}
+	return nil
+}
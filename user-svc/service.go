@@ -1,14 +1,23 @@
 package main
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"strings"
+)
 
 // Define custom error types
 var (
-	ErrUserNotFound    = errors.New("user not found")
-	ErrInvalidArgument = errors.New("invalid argument")
-	ErrRequiredField   = errors.New("required field missing")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserGone           = errors.New("user has been deleted")
+	ErrInvalidArgument    = errors.New("invalid argument")
+	ErrRequiredField      = errors.New("required field missing")
+	ErrPreconditionFailed = errors.New("precondition failed")
 )
 
+// MaxBatchSize is the maximum number of IDs accepted by a batch user lookup
+const MaxBatchSize = 100
+
 // Modified UserService to use the interface instead of concrete type
 type UserService struct {
 	repo UserRepositoryInterface
@@ -21,8 +30,8 @@ func NewUserService(repo UserRepositoryInterface) *UserService {
 	}
 }
 
-// GetAllUsers retrieves all users with pagination
-func (s *UserService) GetAllUsers(pageNum, pageSize int) ([]User, error) {
+// GetAllUsers retrieves all users with pagination, optionally including soft-deleted users
+func (s *UserService) GetAllUsers(ctx context.Context, pageNum, pageSize int, includeDeleted bool) ([]User, error) {
 	if pageNum <= 0 {
 		pageNum = 1
 	}
@@ -30,16 +39,26 @@ func (s *UserService) GetAllUsers(pageNum, pageSize int) ([]User, error) {
 		pageSize = 10
 	}
 
-	return s.repo.GetAllUsers(pageNum, pageSize)
+	return s.repo.GetAllUsers(ctx, pageNum, pageSize, includeDeleted)
+}
+
+// GetUsersAfter retrieves the page of users following a cursor
+func (s *UserService) GetUsersAfter(ctx context.Context, cursor UserCursor, pageSize int, includeDeleted bool) ([]User, error) {
+	if pageSize <= 0 {
+		pageSize = 10
+	}
+
+	return s.repo.GetUsersAfter(ctx, cursor, pageSize, includeDeleted)
 }
 
-// GetUserByID retrieves a user by ID
-func (s *UserService) GetUserByID(id int) (User, error) {
+// GetUserByID retrieves a user by ID. Soft-deleted users are returned together
+// with ErrUserGone so callers can tell them apart from missing ones.
+func (s *UserService) GetUserByID(ctx context.Context, id int) (User, error) {
 	if id <= 0 {
 		return User{}, ErrInvalidArgument
 	}
 
-	user, err := s.repo.GetUserByID(id)
+	user, err := s.repo.GetUserByID(ctx, id)
 	if err != nil {
 		if err.Error() == "user not found" {
 			return User{}, ErrUserNotFound
@@ -47,14 +66,108 @@ func (s *UserService) GetUserByID(id int) (User, error) {
 		return User{}, err
 	}
 
+	if user.DeletedAt != nil {
+		return user, ErrUserGone
+	}
+
 	return user, nil
 }
 
+// GetUsersByIDs retrieves several users at once. Users are returned in the
+// order their IDs were requested (duplicates removed), and IDs that don't
+// exist are reported in missing.
+func (s *UserService) GetUsersByIDs(ctx context.Context, ids []int) ([]User, []int, error) {
+	if len(ids) == 0 || len(ids) > MaxBatchSize {
+		return nil, nil, ErrInvalidArgument
+	}
+
+	unique := make([]int, 0, len(ids))
+	seen := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		if id <= 0 {
+			return nil, nil, ErrInvalidArgument
+		}
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	found, err := s.repo.GetUsersByIDs(ctx, unique)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byID := make(map[int]User, len(found))
+	for _, user := range found {
+		byID[user.ID] = user
+	}
+
+	users := make([]User, 0, len(found))
+	missing := make([]int, 0)
+	for _, id := range unique {
+		if user, ok := byID[id]; ok {
+			users = append(users, user)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	return users, missing, nil
+}
+
+// SearchUsers finds users by partial name, most relevant first
+func (s *UserService) SearchUsers(ctx context.Context, query string, pageNum, pageSize int, includeDeleted bool) ([]User, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, ErrRequiredField
+	}
+	if pageNum <= 0 {
+		pageNum = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 10
+	}
+
+	return s.repo.SearchUsers(ctx, query, pageNum, pageSize, includeDeleted)
+}
+
 // CreateUser creates a new user
-func (s *UserService) CreateUser(name string) (User, error) {
+func (s *UserService) CreateUser(ctx context.Context, name string) (User, error) {
+	if name == "" {
+		return User{}, ErrRequiredField
+	}
+
+	return s.repo.CreateUser(ctx, name)
+}
+
+// UpdateUser renames a user. A non-zero ifUpdatedAt makes the update
+// conditional on the user not having changed since that version.
+func (s *UserService) UpdateUser(ctx context.Context, id int, name string, ifUpdatedAt int64) (User, error) {
+	if id <= 0 || ifUpdatedAt < 0 {
+		return User{}, ErrInvalidArgument
+	}
 	if name == "" {
 		return User{}, ErrRequiredField
 	}
 
-	return s.repo.CreateUser(name)
+	return s.repo.UpdateUser(ctx, id, name, ifUpdatedAt)
+}
+
+// DeleteUser soft-deletes a user
+func (s *UserService) DeleteUser(ctx context.Context, id int) (User, error) {
+	if id <= 0 {
+		return User{}, ErrInvalidArgument
+	}
+
+	return s.repo.DeleteUser(ctx, id)
+}
+
+// RestoreUser undoes a soft delete
+func (s *UserService) RestoreUser(ctx context.Context, id int) (User, error) {
+	if id <= 0 {
+		return User{}, ErrInvalidArgument
+	}
+
+	return s.repo.RestoreUser(ctx, id)
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"reflect"
 	"testing"
@@ -9,25 +10,67 @@ import (
 // MockUserRepository implements UserRepository methods for testing
 type MockUserRepository struct {
 	getUserByIDFn func(id int) (User, error)
-	getAllUsersFn func(pageNum, pageSize int) ([]User, error)
+	getByIDsFn    func(ids []int) ([]User, error)
+	searchFn      func(query string, pageNum, pageSize int, includeDeleted bool) ([]User, error)
+	getAllUsersFn func(pageNum, pageSize int, includeDeleted bool) ([]User, error)
+	getAfterFn    func(cursor UserCursor, pageSize int, includeDeleted bool) ([]User, error)
 	createUserFn  func(name string) (User, error)
+	updateUserFn  func(id int, name string, ifUpdatedAt int64) (User, error)
+	deleteUserFn  func(id int) (User, error)
+	restoreUserFn func(id int) (User, error)
+	importUsersFn func(users []User, preserveIDs bool) (int, []int, error)
 }
 
 // GetUserByID mocks the repository method
-func (m *MockUserRepository) GetUserByID(id int) (User, error) {
+func (m *MockUserRepository) GetUserByID(ctx context.Context, id int) (User, error) {
 	return m.getUserByIDFn(id)
 }
 
+// GetUsersByIDs mocks the repository method
+func (m *MockUserRepository) GetUsersByIDs(ctx context.Context, ids []int) ([]User, error) {
+	return m.getByIDsFn(ids)
+}
+
+// SearchUsers mocks the repository method
+func (m *MockUserRepository) SearchUsers(ctx context.Context, query string, pageNum, pageSize int, includeDeleted bool) ([]User, error) {
+	return m.searchFn(query, pageNum, pageSize, includeDeleted)
+}
+
 // GetAllUsers mocks the repository method
-func (m *MockUserRepository) GetAllUsers(pageNum, pageSize int) ([]User, error) {
-	return m.getAllUsersFn(pageNum, pageSize)
+func (m *MockUserRepository) GetAllUsers(ctx context.Context, pageNum, pageSize int, includeDeleted bool) ([]User, error) {
+	return m.getAllUsersFn(pageNum, pageSize, includeDeleted)
+}
+
+// GetUsersAfter mocks the repository method
+func (m *MockUserRepository) GetUsersAfter(ctx context.Context, cursor UserCursor, pageSize int, includeDeleted bool) ([]User, error) {
+	return m.getAfterFn(cursor, pageSize, includeDeleted)
 }
 
 // CreateUser mocks the repository method
-func (m *MockUserRepository) CreateUser(name string) (User, error) {
+func (m *MockUserRepository) CreateUser(ctx context.Context, name string) (User, error) {
 	return m.createUserFn(name)
 }
 
+// UpdateUser mocks the repository method
+func (m *MockUserRepository) UpdateUser(ctx context.Context, id int, name string, ifUpdatedAt int64) (User, error) {
+	return m.updateUserFn(id, name, ifUpdatedAt)
+}
+
+// DeleteUser mocks the repository method
+func (m *MockUserRepository) DeleteUser(ctx context.Context, id int) (User, error) {
+	return m.deleteUserFn(id)
+}
+
+// RestoreUser mocks the repository method
+func (m *MockUserRepository) RestoreUser(ctx context.Context, id int) (User, error) {
+	return m.restoreUserFn(id)
+}
+
+// ImportUsers mocks the repository method
+func (m *MockUserRepository) ImportUsers(ctx context.Context, users []User, preserveIDs bool) (int, []int, error) {
+	return m.importUsersFn(users, preserveIDs)
+}
+
 // Setup test data
 func setupTestUsers() []User {
 	return []User{
@@ -55,7 +98,7 @@ func TestGetAllUsers(t *testing.T) {
 		pageSize    int
 		expected    []User
 		expectedErr error
-		mockFn      func(pageNum, pageSize int) ([]User, error)
+		mockFn      func(pageNum, pageSize int, includeDeleted bool) ([]User, error)
 	}{
 		{
 			name:        "Valid pagination",
@@ -63,7 +106,7 @@ func TestGetAllUsers(t *testing.T) {
 			pageSize:    10,
 			expected:    testUsers,
 			expectedErr: nil,
-			mockFn: func(pageNum, pageSize int) ([]User, error) {
+			mockFn: func(pageNum, pageSize int, includeDeleted bool) ([]User, error) {
 				return testUsers, nil
 			},
 		},
@@ -73,7 +116,7 @@ func TestGetAllUsers(t *testing.T) {
 			pageSize:    10,
 			expected:    testUsers,
 			expectedErr: nil,
-			mockFn: func(pageNum, pageSize int) ([]User, error) {
+			mockFn: func(pageNum, pageSize int, includeDeleted bool) ([]User, error) {
 				if pageNum != 1 {
 					t.Errorf("Expected pageNum to default to 1, got %d", pageNum)
 				}
@@ -86,7 +129,7 @@ func TestGetAllUsers(t *testing.T) {
 			pageSize:    0,
 			expected:    testUsers,
 			expectedErr: nil,
-			mockFn: func(pageNum, pageSize int) ([]User, error) {
+			mockFn: func(pageNum, pageSize int, includeDeleted bool) ([]User, error) {
 				if pageSize != 10 {
 					t.Errorf("Expected pageSize to default to 10, got %d", pageSize)
 				}
@@ -99,7 +142,7 @@ func TestGetAllUsers(t *testing.T) {
 			pageSize:    10,
 			expected:    nil,
 			expectedErr: errors.New("database error"),
-			mockFn: func(pageNum, pageSize int) ([]User, error) {
+			mockFn: func(pageNum, pageSize int, includeDeleted bool) ([]User, error) {
 				return nil, errors.New("database error")
 			},
 		},
@@ -112,7 +155,7 @@ func TestGetAllUsers(t *testing.T) {
 			}
 			service := NewUserService(mockRepo)
 
-			users, err := service.GetAllUsers(tt.pageNum, tt.pageSize)
+			users, err := service.GetAllUsers(context.Background(), tt.pageNum, tt.pageSize, false)
 
 			if !errors.Is(err, tt.expectedErr) && err.Error() != tt.expectedErr.Error() {
 				t.Errorf("Expected error %v, got %v", tt.expectedErr, err)
@@ -125,8 +168,36 @@ func TestGetAllUsers(t *testing.T) {
 	}
 }
 
+func TestGetUsersAfter(t *testing.T) {
+	testUsers := setupTestUsers()
+	cursor := UserCursor{CreatedAt: 100, ID: 4}
+
+	mockRepo := &MockUserRepository{
+		getAfterFn: func(c UserCursor, pageSize int, includeDeleted bool) ([]User, error) {
+			if c != cursor {
+				t.Errorf("Expected cursor %v, got %v", cursor, c)
+			}
+			if pageSize != 10 {
+				t.Errorf("Expected pageSize to default to 10, got %d", pageSize)
+			}
+			return testUsers, nil
+		},
+	}
+	service := NewUserService(mockRepo)
+
+	users, err := service.GetUsersAfter(context.Background(), cursor, 0, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(users, testUsers) {
+		t.Errorf("Expected users %v, got %v", testUsers, users)
+	}
+}
+
 func TestGetUserByID(t *testing.T) {
 	testUsers := setupTestUsers()
+	deletedAt := int64(300)
+	deletedUser := User{ID: 4, Name: "Dave", DeletedAt: &deletedAt}
 
 	tests := []struct {
 		name        string
@@ -163,6 +234,15 @@ func TestGetUserByID(t *testing.T) {
 				return User{}, errors.New("user not found")
 			},
 		},
+		{
+			name:        "Deleted user",
+			id:          4,
+			expected:    deletedUser,
+			expectedErr: ErrUserGone,
+			mockFn: func(id int) (User, error) {
+				return deletedUser, nil
+			},
+		},
 		{
 			name:        "Repository error",
 			id:          1,
@@ -181,7 +261,7 @@ func TestGetUserByID(t *testing.T) {
 			}
 			service := NewUserService(mockRepo)
 
-			user, err := service.GetUserByID(tt.id)
+			user, err := service.GetUserByID(context.Background(), tt.id)
 
 			if err != tt.expectedErr && (err == nil || tt.expectedErr == nil || err.Error() != tt.expectedErr.Error()) {
 				t.Errorf("Expected error %v, got %v", tt.expectedErr, err)
@@ -241,7 +321,7 @@ func TestCreateUser(t *testing.T) {
 			}
 			service := NewUserService(mockRepo)
 
-			user, err := service.CreateUser(tt.userName)
+			user, err := service.CreateUser(context.Background(), tt.userName)
 
 			if err != tt.expectedErr && (err == nil || tt.expectedErr == nil || err.Error() != tt.expectedErr.Error()) {
 				t.Errorf("Expected error %v, got %v", tt.expectedErr, err)
@@ -253,3 +333,241 @@ func TestCreateUser(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateUser(t *testing.T) {
+	updatedUser := User{ID: 1, Name: "Alicia", CreatedAt: 100, UpdatedAt: 200}
+
+	tests := []struct {
+		name        string
+		id          int
+		userName    string
+		ifUpdatedAt int64
+		expected    User
+		expectedErr error
+		mockFn      func(id int, name string, ifUpdatedAt int64) (User, error)
+	}{
+		{
+			name:        "Valid update",
+			id:          1,
+			userName:    "Alicia",
+			ifUpdatedAt: 100,
+			expected:    updatedUser,
+			expectedErr: nil,
+			mockFn: func(id int, name string, ifUpdatedAt int64) (User, error) {
+				if ifUpdatedAt != 100 {
+					t.Errorf("Expected ifUpdatedAt to be passed through, got %d", ifUpdatedAt)
+				}
+				return updatedUser, nil
+			},
+		},
+		{
+			name:        "Invalid ID",
+			id:          0,
+			userName:    "Alicia",
+			expected:    User{},
+			expectedErr: ErrInvalidArgument,
+			mockFn: func(id int, name string, ifUpdatedAt int64) (User, error) {
+				t.Errorf("Mock should not be called with invalid ID")
+				return User{}, nil
+			},
+		},
+		{
+			name:        "Empty name",
+			id:          1,
+			userName:    "",
+			expected:    User{},
+			expectedErr: ErrRequiredField,
+			mockFn: func(id int, name string, ifUpdatedAt int64) (User, error) {
+				t.Errorf("Mock should not be called with empty name")
+				return User{}, nil
+			},
+		},
+		{
+			name:        "Stale version",
+			id:          1,
+			userName:    "Alicia",
+			ifUpdatedAt: 50,
+			expected:    User{},
+			expectedErr: ErrPreconditionFailed,
+			mockFn: func(id int, name string, ifUpdatedAt int64) (User, error) {
+				return User{}, ErrPreconditionFailed
+			},
+		},
+		{
+			name:        "User not found",
+			id:          999,
+			userName:    "Alicia",
+			expected:    User{},
+			expectedErr: ErrUserNotFound,
+			mockFn: func(id int, name string, ifUpdatedAt int64) (User, error) {
+				return User{}, ErrUserNotFound
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockUserRepository{
+				updateUserFn: tt.mockFn,
+			}
+			service := NewUserService(mockRepo)
+
+			user, err := service.UpdateUser(context.Background(), tt.id, tt.userName, tt.ifUpdatedAt)
+
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+
+			if !reflect.DeepEqual(user, tt.expected) {
+				t.Errorf("Expected user %v, got %v", tt.expected, user)
+			}
+		})
+	}
+}
+
+func TestDeleteAndRestoreUser(t *testing.T) {
+	deletedAt := int64(300)
+	deletedUser := User{ID: 1, Name: "Alice", UpdatedAt: 300, DeletedAt: &deletedAt}
+	restoredUser := User{ID: 1, Name: "Alice", UpdatedAt: 400}
+
+	mockRepo := &MockUserRepository{
+		deleteUserFn: func(id int) (User, error) {
+			return deletedUser, nil
+		},
+		restoreUserFn: func(id int) (User, error) {
+			return restoredUser, nil
+		},
+	}
+	service := NewUserService(mockRepo)
+
+	user, err := service.DeleteUser(context.Background(), 1)
+	if err != nil || !reflect.DeepEqual(user, deletedUser) {
+		t.Errorf("Expected deleted user %v, got %v (err %v)", deletedUser, user, err)
+	}
+
+	user, err = service.RestoreUser(context.Background(), 1)
+	if err != nil || !reflect.DeepEqual(user, restoredUser) {
+		t.Errorf("Expected restored user %v, got %v (err %v)", restoredUser, user, err)
+	}
+
+	if _, err := service.DeleteUser(context.Background(), 0); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("Expected ErrInvalidArgument for delete with invalid ID, got %v", err)
+	}
+	if _, err := service.RestoreUser(context.Background(), -1); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("Expected ErrInvalidArgument for restore with invalid ID, got %v", err)
+	}
+}
+
+func TestGetUsersByIDs(t *testing.T) {
+	testUsers := setupTestUsers()
+
+	tests := []struct {
+		name            string
+		ids             []int
+		expected        []User
+		expectedMissing []int
+		expectedErr     error
+		mockFn          func(ids []int) ([]User, error)
+	}{
+		{
+			name:            "Preserves request order and reports missing IDs",
+			ids:             []int{3, 99, 1, 3},
+			expected:        []User{testUsers[2], testUsers[0]},
+			expectedMissing: []int{99},
+			expectedErr:     nil,
+			mockFn: func(ids []int) ([]User, error) {
+				if !reflect.DeepEqual(ids, []int{3, 99, 1}) {
+					t.Errorf("Expected deduplicated IDs [3 99 1], got %v", ids)
+				}
+				return []User{testUsers[0], testUsers[2]}, nil
+			},
+		},
+		{
+			name:        "Empty ID list",
+			ids:         []int{},
+			expectedErr: ErrInvalidArgument,
+			mockFn: func(ids []int) ([]User, error) {
+				t.Errorf("Mock should not be called with no IDs")
+				return nil, nil
+			},
+		},
+		{
+			name:        "Non-positive ID",
+			ids:         []int{1, 0},
+			expectedErr: ErrInvalidArgument,
+			mockFn: func(ids []int) ([]User, error) {
+				t.Errorf("Mock should not be called with invalid IDs")
+				return nil, nil
+			},
+		},
+		{
+			name:        "Too many IDs",
+			ids:         make([]int, MaxBatchSize+1),
+			expectedErr: ErrInvalidArgument,
+			mockFn: func(ids []int) ([]User, error) {
+				t.Errorf("Mock should not be called with too many IDs")
+				return nil, nil
+			},
+		},
+		{
+			name:        "Repository error",
+			ids:         []int{1},
+			expectedErr: errors.New("database error"),
+			mockFn: func(ids []int) ([]User, error) {
+				return nil, errors.New("database error")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockUserRepository{
+				getByIDsFn: tt.mockFn,
+			}
+			service := NewUserService(mockRepo)
+
+			users, missing, err := service.GetUsersByIDs(context.Background(), tt.ids)
+
+			if err != tt.expectedErr && (err == nil || tt.expectedErr == nil || err.Error() != tt.expectedErr.Error()) {
+				t.Errorf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+
+			if !reflect.DeepEqual(users, tt.expected) {
+				t.Errorf("Expected users %v, got %v", tt.expected, users)
+			}
+
+			if !reflect.DeepEqual(missing, tt.expectedMissing) {
+				t.Errorf("Expected missing IDs %v, got %v", tt.expectedMissing, missing)
+			}
+		})
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	testUsers := setupTestUsers()
+
+	mockRepo := &MockUserRepository{
+		searchFn: func(query string, pageNum, pageSize int, includeDeleted bool) ([]User, error) {
+			if query != "ali" {
+				t.Errorf("Expected trimmed query %q, got %q", "ali", query)
+			}
+			if pageNum != 1 || pageSize != 10 {
+				t.Errorf("Expected default pagination 1/10, got %d/%d", pageNum, pageSize)
+			}
+			return testUsers[:1], nil
+		},
+	}
+	service := NewUserService(mockRepo)
+
+	users, err := service.SearchUsers(context.Background(), "  ali ", 0, 0, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(users, testUsers[:1]) {
+		t.Errorf("Expected users %v, got %v", testUsers[:1], users)
+	}
+
+	if _, err := service.SearchUsers(context.Background(), "   ", 1, 10, false); !errors.Is(err, ErrRequiredField) {
+		t.Errorf("Expected ErrRequiredField for blank query, got %v", err)
+	}
+}
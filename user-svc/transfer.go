@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Formats supported by export and import
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+// csvColumns is the header of exported CSV files
+var csvColumns = []string{"id", "name", "created_at", "updated_at", "deleted_at"}
+
+// exportUsers writes every user to w, newest first, paging through the
+// repository with a cursor so memory use doesn't grow with the table.
+// It returns the number of users written.
+func exportUsers(ctx context.Context, repo UserRepositoryInterface, w io.Writer, format string, includeDeleted bool, pageSize int) (int, error) {
+	var writeUser func(User) error
+	var flush func() error
+
+	switch format {
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvColumns); err != nil {
+			return 0, err
+		}
+		writeUser = func(user User) error {
+			deletedAt := ""
+			if user.DeletedAt != nil {
+				deletedAt = strconv.FormatInt(*user.DeletedAt, 10)
+			}
+			return cw.Write([]string{
+				strconv.Itoa(user.ID),
+				user.Name,
+				strconv.FormatInt(user.CreatedAt, 10),
+				strconv.FormatInt(user.UpdatedAt, 10),
+				deletedAt,
+			})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case formatNDJSON:
+		bw := bufio.NewWriter(w)
+		encoder := json.NewEncoder(bw)
+		writeUser = func(user User) error { return encoder.Encode(user) }
+		flush = bw.Flush
+	default:
+		return 0, fmt.Errorf("unknown format %q, expected csv or ndjson", format)
+	}
+
+	written := 0
+	users, err := repo.GetAllUsers(ctx, 1, pageSize, includeDeleted)
+	for err == nil && len(users) > 0 {
+		for _, user := range users {
+			if err := writeUser(user); err != nil {
+				return written, err
+			}
+			written++
+		}
+		if len(users) < pageSize {
+			break
+		}
+		users, err = repo.GetUsersAfter(ctx, cursorFor(users[len(users)-1]), pageSize, includeDeleted)
+	}
+	if err != nil {
+		return written, err
+	}
+
+	return written, flush()
+}
+
+// ImportOptions controls how importUsers stores the users it reads
+type ImportOptions struct {
+	Format    string
+	BatchSize int
+	// PreserveIDs keeps the IDs from the input; users whose ID is taken are rejected
+	PreserveIDs bool
+	// PreserveTimestamps keeps created_at, updated_at and deleted_at from the
+	// input; otherwise users are stamped with the import time, and deleted
+	// users stay deleted as of that time
+	PreserveTimestamps bool
+	// Progress, if set, is called after every committed batch
+	Progress func(ImportStats)
+}
+
+// ImportStats counts the outcome of an import
+type ImportStats struct {
+	Read     int
+	Imported int
+	Rejected int
+}
+
+// importRecord is one user read from the input, with its position for error reporting
+type importRecord struct {
+	line  int
+	user  User
+	hasID bool
+	raw   []string // the CSV fields or the NDJSON line
+	err   error
+}
+
+// importUsers reads users from r and stores them in batches, each batch in
+// its own transaction. Invalid rows, and rows whose preserved ID is already
+// taken, are written to rejects together with the reason. Batches committed
+// before a failure stay imported.
+func importUsers(ctx context.Context, repo UserRepositoryInterface, r io.Reader, rejects *rejectWriter, opts ImportOptions) (ImportStats, error) {
+	var stats ImportStats
+
+	reader, err := newRecordReader(r, opts.Format)
+	if err != nil {
+		return stats, err
+	}
+	rejects.header = reader.header
+
+	batch := make([]importRecord, 0, opts.BatchSize)
+	commit := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		users := make([]User, len(batch))
+		for i, record := range batch {
+			users[i] = record.user
+		}
+		imported, conflicts, err := repo.ImportUsers(ctx, users, opts.PreserveIDs)
+		if err != nil {
+			return fmt.Errorf("import batch ending at line %d: %w", batch[len(batch)-1].line, err)
+		}
+
+		stats.Imported += imported
+		for _, id := range conflicts {
+			for _, record := range batch {
+				if record.user.ID == id {
+					if err := rejects.reject(record, fmt.Sprintf("id %d already exists", id)); err != nil {
+						return err
+					}
+					stats.Rejected++
+					break
+				}
+			}
+		}
+
+		batch = batch[:0]
+		if opts.Progress != nil {
+			opts.Progress(stats)
+		}
+		return nil
+	}
+
+	seenIDs := make(map[int]bool)
+	for {
+		record, err := reader.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats.Read++
+
+		if record.err == nil {
+			record.err = prepareImportRecord(&record, opts, time.Now().UnixMicro())
+		}
+		// A repeated ID would only conflict with the row earlier in the same batch
+		if record.err == nil && opts.PreserveIDs {
+			if seenIDs[record.user.ID] {
+				record.err = fmt.Errorf("id %d appears more than once", record.user.ID)
+			}
+			seenIDs[record.user.ID] = true
+		}
+		if record.err != nil {
+			if err := rejects.reject(record, record.err.Error()); err != nil {
+				return stats, err
+			}
+			stats.Rejected++
+			continue
+		}
+
+		batch = append(batch, record)
+		if len(batch) == opts.BatchSize {
+			if err := commit(); err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	return stats, commit()
+}
+
+// prepareImportRecord validates a record and applies the import options to it
+func prepareImportRecord(record *importRecord, opts ImportOptions, now int64) error {
+	user := &record.user
+
+	switch {
+	case user.Name == "":
+		return errors.New("name is required")
+	case strings.TrimSpace(user.Name) == "":
+		return errors.New("name must not be blank")
+	case !utf8.ValidString(user.Name):
+		return errors.New("name is not valid UTF-8")
+	}
+
+	if opts.PreserveIDs && (!record.hasID || user.ID <= 0) {
+		return errors.New("id must be a positive integer to preserve it")
+	}
+
+	if opts.PreserveTimestamps {
+		switch {
+		case user.CreatedAt <= 0:
+			return errors.New("created_at must be a positive timestamp to preserve it")
+		case user.UpdatedAt < user.CreatedAt:
+			return errors.New("updated_at must not be before created_at")
+		case user.DeletedAt != nil && *user.DeletedAt < user.CreatedAt:
+			return errors.New("deleted_at must not be before created_at")
+		}
+		return nil
+	}
+
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	if user.DeletedAt != nil {
+		user.DeletedAt = &now
+	}
+	return nil
+}
+
+// recordReader reads importRecords from CSV or NDJSON input
+type recordReader struct {
+	// header is the CSV header row, repeated in the rejected rows file
+	header []string
+	next   func() (importRecord, error)
+}
+
+// newRecordReader returns a reader for the given format. CSV input needs a
+// header row; columns are matched by name, so their order doesn't matter and
+// unknown columns are ignored.
+func newRecordReader(r io.Reader, format string) (*recordReader, error) {
+	switch format {
+	case formatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		header, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return &recordReader{next: func() (importRecord, error) { return importRecord{}, io.EOF }}, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read CSV header: %w", err)
+		}
+
+		columns := make(map[string]int, len(header))
+		for i, name := range header {
+			columns[strings.TrimSpace(strings.ToLower(name))] = i
+		}
+		if _, ok := columns["name"]; !ok {
+			return nil, errors.New("CSV header has no name column")
+		}
+
+		return &recordReader{header: header, next: func() (importRecord, error) {
+			fields, err := cr.Read()
+			if err != nil {
+				return importRecord{}, err
+			}
+			line, _ := cr.FieldPos(0)
+			return parseCSVRecord(line, fields, columns), nil
+		}}, nil
+	case formatNDJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64<<10), MaxUserBodyBytes)
+		line := 0
+		return &recordReader{next: func() (importRecord, error) {
+			for scanner.Scan() {
+				line++
+				text := scanner.Text()
+				if strings.TrimSpace(text) == "" {
+					continue
+				}
+				return parseNDJSONRecord(line, text), nil
+			}
+			if err := scanner.Err(); err != nil {
+				return importRecord{}, fmt.Errorf("read line %d: %w", line+1, err)
+			}
+			return importRecord{}, io.EOF
+		}}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected csv or ndjson", format)
+	}
+}
+
+// parseCSVRecord converts a CSV row into a record, noting the first bad field
+func parseCSVRecord(line int, fields []string, columns map[string]int) importRecord {
+	record := importRecord{line: line, raw: fields}
+
+	// Names are kept exactly as given, like the API does; numbers may be padded
+	field := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(fields) {
+			return fields[i]
+		}
+		return ""
+	}
+	parseInt := func(name string) int64 {
+		value := strings.TrimSpace(field(name))
+		if value == "" || record.err != nil {
+			return 0
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			record.err = fmt.Errorf("%s must be an integer", name)
+		}
+		return n
+	}
+
+	record.user.Name = field("name")
+	record.hasID = strings.TrimSpace(field("id")) != ""
+	record.user.ID = int(parseInt("id"))
+	record.user.CreatedAt = parseInt("created_at")
+	record.user.UpdatedAt = parseInt("updated_at")
+	if strings.TrimSpace(field("deleted_at")) != "" {
+		deletedAt := parseInt("deleted_at")
+		record.user.DeletedAt = &deletedAt
+	}
+	return record
+}
+
+// parseNDJSONRecord converts a JSON line into a record
+func parseNDJSONRecord(line int, text string) importRecord {
+	record := importRecord{line: line, raw: []string{text}}
+
+	var input struct {
+		ID        *int   `json:"id"`
+		Name      string `json:"name"`
+		CreatedAt int64  `json:"created_at"`
+		UpdatedAt int64  `json:"updated_at"`
+		DeletedAt *int64 `json:"deleted_at"`
+	}
+	if err := json.Unmarshal([]byte(text), &input); err != nil {
+		record.err = fmt.Errorf("invalid JSON: %v", err)
+		return record
+	}
+
+	record.user = User{Name: input.Name, CreatedAt: input.CreatedAt, UpdatedAt: input.UpdatedAt, DeletedAt: input.DeletedAt}
+	if input.ID != nil {
+		record.hasID = true
+		record.user.ID = *input.ID
+	}
+	return record
+}
+
+// rejectWriter writes rejected rows with their line number and reason, as
+// CSV with the input's columns or as NDJSON holding the original line. The
+// output is only created once the first row is rejected.
+type rejectWriter struct {
+	format string
+	header []string
+	open   func() (io.Writer, error)
+
+	csv  *csv.Writer
+	json *json.Encoder
+	out  *bufio.Writer
+}
+
+// newRejectWriter returns a rejectWriter calling open on the first rejection
+func newRejectWriter(format string, open func() (io.Writer, error)) *rejectWriter {
+	return &rejectWriter{format: format, open: open}
+}
+
+// reject records one rejected row
+func (w *rejectWriter) reject(record importRecord, reason string) error {
+	if w.out == nil {
+		out, err := w.open()
+		if err != nil {
+			return fmt.Errorf("open rejected rows file: %w", err)
+		}
+		w.out = bufio.NewWriter(out)
+		if w.format == formatCSV {
+			w.csv = csv.NewWriter(w.out)
+			if err := w.csv.Write(append([]string{"line", "error"}, w.header...)); err != nil {
+				return err
+			}
+		} else {
+			w.json = json.NewEncoder(w.out)
+		}
+	}
+
+	if w.csv != nil {
+		row := []string{strconv.Itoa(record.line), reason}
+		return w.csv.Write(append(row, record.raw...))
+	}
+	return w.json.Encode(struct {
+		Line   int    `json:"line"`
+		Error  string `json:"error"`
+		Record string `json:"record"`
+	}{record.line, reason, strings.Join(record.raw, "")})
+}
+
+// Close flushes the rejected rows, if there were any
+func (w *rejectWriter) Close() error {
+	if w.out == nil {
+		return nil
+	}
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return w.out.Flush()
+}
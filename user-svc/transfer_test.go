@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+// importInto runs importUsers against repo, collecting rejected rows in a buffer
+func importInto(t *testing.T, repo UserRepositoryInterface, input string, opts ImportOptions) (ImportStats, string, error) {
+	t.Helper()
+
+	var rejected bytes.Buffer
+	rejects := newRejectWriter(opts.Format, func() (io.Writer, error) { return &rejected, nil })
+	stats, err := importUsers(context.Background(), repo, strings.NewReader(input), rejects, opts)
+	if closeErr := rejects.Close(); closeErr != nil {
+		t.Fatalf("Failed to flush rejected rows: %v", closeErr)
+	}
+	return stats, rejected.String(), err
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	for _, format := range []string{formatCSV, formatNDJSON} {
+		t.Run(format, func(t *testing.T) {
+			ctx := context.Background()
+			source := NewMemoryUserRepository()
+			deletedAt := int64(300)
+			for _, user := range []User{
+				{ID: 1, Name: "Ann, \"the first\"", CreatedAt: 100, UpdatedAt: 100},
+				{ID: 2, Name: "Bob", CreatedAt: 200, UpdatedAt: 250},
+				{ID: 3, Name: "Cy", CreatedAt: 200, UpdatedAt: 300, DeletedAt: &deletedAt},
+			} {
+				insertTestUser(t, source, user)
+			}
+
+			// A page size of 2 makes the export follow the cursor
+			var exported bytes.Buffer
+			written, err := exportUsers(ctx, source, &exported, format, true, 2)
+			if err != nil || written != 3 {
+				t.Fatalf("Expected 3 users exported, got %d, %v", written, err)
+			}
+
+			target := NewMemoryUserRepository()
+			stats, rejected, err := importInto(t, target, exported.String(), ImportOptions{
+				Format: format, BatchSize: 2, PreserveIDs: true, PreserveTimestamps: true,
+			})
+			if err != nil {
+				t.Fatalf("importUsers failed: %v", err)
+			}
+			if stats != (ImportStats{Read: 3, Imported: 3}) || rejected != "" {
+				t.Errorf("Expected 3 users imported, got %+v, rejected %q", stats, rejected)
+			}
+
+			want, _ := source.GetAllUsers(ctx, 1, 10, true)
+			got, _ := target.GetAllUsers(ctx, 1, 10, true)
+			if len(got) != len(want) {
+				t.Fatalf("Expected %d users, got %d", len(want), len(got))
+			}
+			for i := range want {
+				if got[i].ID != want[i].ID || got[i].Name != want[i].Name || got[i].CreatedAt != want[i].CreatedAt ||
+					got[i].UpdatedAt != want[i].UpdatedAt || (got[i].DeletedAt == nil) != (want[i].DeletedAt == nil) {
+					t.Errorf("Expected %+v, got %+v", want[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExportWithoutDeletedUsers(t *testing.T) {
+	repo := NewMemoryUserRepository()
+	createTestUsers(t, repo, "Ann", "Bob")
+	if _, err := repo.DeleteUser(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if _, err := exportUsers(context.Background(), repo, &out, formatNDJSON, false, 10); err != nil {
+		t.Fatalf("exportUsers failed: %v", err)
+	}
+	if lines := strings.Count(out.String(), "\n"); lines != 1 || !strings.Contains(out.String(), `"Bob"`) {
+		t.Errorf("Expected only Bob, got %q", out.String())
+	}
+}
+
+func TestImportRejectsInvalidRows(t *testing.T) {
+	repo := NewMemoryUserRepository()
+	createTestUsers(t, repo, "Existing")
+
+	input := "name,id,created_at,updated_at\n" +
+		"Valid,7,100,100\n" +
+		",8,100,100\n" +
+		"\"  \",9,100,100\n" +
+		"Taken,1,100,100\n" +
+		"No ID,,100,100\n" +
+		"Bad time,10,abc,100\n" +
+		"Backwards,11,200,100\n" +
+		"Twice,7,100,100\n"
+
+	stats, rejected, err := importInto(t, repo, input, ImportOptions{
+		Format: formatCSV, BatchSize: 100, PreserveIDs: true, PreserveTimestamps: true,
+	})
+	if err != nil {
+		t.Fatalf("importUsers failed: %v", err)
+	}
+	if stats != (ImportStats{Read: 8, Imported: 1, Rejected: 7}) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	for _, want := range []string{
+		"line,error,name,id,created_at,updated_at",
+		"3,name is required,,8,100,100",
+		"4,name must not be blank",
+		"5,id 1 already exists,Taken,1,100,100",
+		"6,id must be a positive integer to preserve it",
+		"7,created_at must be an integer",
+		"8,updated_at must not be before created_at",
+		"9,id 7 appears more than once",
+	} {
+		if !strings.Contains(rejected, want) {
+			t.Errorf("Expected rejected rows to contain %q, got:\n%s", want, rejected)
+		}
+	}
+}
+
+func TestImportNDJSONAssignsIDsAndTimestamps(t *testing.T) {
+	repo := NewMemoryUserRepository()
+	createTestUsers(t, repo, "Existing")
+
+	input := `{"id":1,"name":"Ann","created_at":5,"updated_at":5}` + "\n\n" +
+		`{"name":"Bob","deleted_at":9,"extra":true}` + "\n" +
+		`{"name":` + "\n"
+
+	var progress []ImportStats
+	stats, rejected, err := importInto(t, repo, input, ImportOptions{
+		Format: formatNDJSON, BatchSize: 1,
+		Progress: func(stats ImportStats) { progress = append(progress, stats) },
+	})
+	if err != nil {
+		t.Fatalf("importUsers failed: %v", err)
+	}
+	if stats != (ImportStats{Read: 3, Imported: 2, Rejected: 1}) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if len(progress) != 2 {
+		t.Errorf("Expected progress after each of 2 batches, got %v", progress)
+	}
+	if !strings.Contains(rejected, `"line":4,"error":"invalid JSON`) {
+		t.Errorf("Expected the truncated line rejected, got %q", rejected)
+	}
+
+	ann, err := repo.GetUserByID(context.Background(), 2)
+	if err != nil || ann.Name != "Ann" || ann.CreatedAt == 5 {
+		t.Errorf("Expected Ann as user 2 stamped with the import time, got %+v, %v", ann, err)
+	}
+	bob, err := repo.GetUserByID(context.Background(), 3)
+	if err != nil || bob.DeletedAt == nil || *bob.DeletedAt != bob.CreatedAt {
+		t.Errorf("Expected Bob deleted as of the import, got %+v, %v", bob, err)
+	}
+}